@@ -0,0 +1,127 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/rifflock/lfshook"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FieldJobUuid   = "job_uuid"
+	FieldSpaceName = "space_name"
+	FieldWallet    = "wallet"
+	FieldTraceId   = "trace_id"
+
+	FormatText = "text"
+	FormatJson = "json"
+
+	timestampFormat = "2006-01-02 15:04:05.000"
+)
+
+var logger = newLogger()
+
+type fieldsKey struct{}
+
+func newLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetReportCaller(true)
+	l.SetFormatter(newFormatter(FormatText))
+	return l
+}
+
+func callerPrettyfier(f *runtime.Frame) (string, string) {
+	filename := filepath.Base(f.File)
+	funcName := f.Function[strings.LastIndex(f.Function, ".")+1:]
+	return funcName, fmt.Sprintf("%s:%d", filename, f.Line)
+}
+
+func newFormatter(format string) logrus.Formatter {
+	if format == FormatJson {
+		return &logrus.JSONFormatter{
+			TimestampFormat:  timestampFormat,
+			CallerPrettyfier: callerPrettyfier,
+		}
+	}
+	return &logrus.TextFormatter{
+		TimestampFormat:  timestampFormat,
+		FullTimestamp:    true,
+		CallerPrettyfier: callerPrettyfier,
+	}
+}
+
+// Init sets the level and the format, text or json, of the logger. When dir is set the entries are
+// also written to info.log, warn.log and error.log in that directory.
+func Init(level, format, dir string) error {
+	if level != "" {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		logger.SetLevel(lvl)
+	}
+	if format != "" && format != FormatText && format != FormatJson {
+		return fmt.Errorf("unknown log format %q, use %s or %s", format, FormatText, FormatJson)
+	}
+	formatter := newFormatter(format)
+	logger.SetFormatter(formatter)
+
+	logger.ReplaceHooks(make(logrus.LevelHooks))
+	if dir != "" {
+		logger.AddHook(lfshook.NewHook(lfshook.PathMap{
+			logrus.DebugLevel: filepath.Join(dir, "info.log"),
+			logrus.InfoLevel:  filepath.Join(dir, "info.log"),
+			logrus.WarnLevel:  filepath.Join(dir, "warn.log"),
+			logrus.ErrorLevel: filepath.Join(dir, "error.log"),
+			logrus.FatalLevel: filepath.Join(dir, "error.log"),
+			logrus.PanicLevel: filepath.Join(dir, "error.log"),
+		}, formatter))
+	}
+	return nil
+}
+
+func GetLogger() *logrus.Logger {
+	return logger
+}
+
+// WithFields returns a context whose log entries carry the fields, on top of the fields it already carries.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := make(logrus.Fields)
+	if parent, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		for k, v := range parent {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		if v != "" {
+			merged[k] = v
+		}
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// WithJob returns a context whose log entries carry the job uuid, the space name and the creator wallet.
+func WithJob(ctx context.Context, jobUuid, spaceName, wallet string) context.Context {
+	return WithFields(ctx, logrus.Fields{
+		FieldJobUuid:   jobUuid,
+		FieldSpaceName: spaceName,
+		FieldWallet:    wallet,
+	})
+}
+
+// FromContext returns a log entry with the fields carried by the context and the id of its trace.
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := logger.WithContext(ctx)
+	if fields, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		entry = entry.WithFields(fields)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		entry = entry.WithField(FieldTraceId, spanContext.TraceID().String())
+	}
+	return entry
+}
@@ -212,6 +212,36 @@ var HardwareResource = map[string]Resource{
 			Quantity:    0,
 		},
 	},
+	"8": {
+		Cpu: Specification{
+			Description: "2 vCPU",
+			Quantity:    2,
+		},
+		Memory: Specification{
+			Description: "8Gi",
+			Quantity:    8,
+		},
+		Gpu: Specification{
+			Description: "Nvidia A100 1g.5gb",
+			Quantity:    1,
+			Sharing:     "1g.5gb",
+		},
+	},
+	"9": {
+		Cpu: Specification{
+			Description: "4 vCPU",
+			Quantity:    4,
+		},
+		Memory: Specification{
+			Description: "15Gi",
+			Quantity:    15,
+		},
+		Gpu: Specification{
+			Description: "Nvidia T4",
+			Quantity:    1,
+			Sharing:     "shared",
+		},
+	},
 }
 
 type Resource struct {
@@ -223,4 +253,6 @@ type Resource struct {
 type Specification struct {
 	Description string
 	Quantity    int64
+	// Sharing requests fractional GPUs, "shared" for a time-sliced replica or a MIG profile like "1g.5gb"
+	Sharing string
 }
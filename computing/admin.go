@@ -0,0 +1,29 @@
+package computing
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/common"
+)
+
+// AdminAuth guards the operator endpoints with the API.AdminToken bearer token. Without a token
+// they are refused, so the public API of a provider does not expose them.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.CreateErrorResponse(strconv.Itoa(http.StatusForbidden), "admin endpoints are disabled, set API.AdminToken to enable them"))
+			return
+		}
+		header := c.GetHeader("Authorization")
+		given := strings.TrimPrefix(header, "Bearer ")
+		if given == header || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.CreateErrorResponse(strconv.Itoa(http.StatusUnauthorized), "invalid admin token"))
+			return
+		}
+		c.Next()
+	}
+}
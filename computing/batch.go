@@ -0,0 +1,833 @@
+package computing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	"go.opentelemetry.io/otel/attribute"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultCollectorImage    = "busybox:1.36"
+	defaultBatchInterval     = 10
+	defaultBatchLogTailLines = 200
+
+	batchOutputsVolume = "lad-outputs"
+	batchCollectVolume = "lad-collect"
+	batchOutputsDir    = "/lad/outputs"
+	batchCollectDir    = "/lad/collect"
+	batchCollectorName = "collector"
+	// batchCollectWait is how long the collector keeps the packaged outputs once the workload exited.
+	batchCollectWait = 3600
+	batchNobodyUser  = 65534
+)
+
+var artifactNamePattern = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// batchRun is a batch or cron job watched until it is gone, the outputs of its runs are collected from
+// the collector container.
+type batchRun struct {
+	JobUuid   string `json:"job_uuid"`
+	Type      string `json:"type"`
+	Namespace string `json:"namespace"`
+	SpaceName string `json:"space_name"`
+	Wallet    string `json:"wallet"`
+	// Service is the service of the deploy.yaml the workload runs, empty for a job of a single image.
+	Service string `json:"service,omitempty"`
+	// JobName is the job of a batch job, CronJobName the cron job starting the runs of a cron job.
+	JobName     string   `json:"job_name,omitempty"`
+	CronJobName string   `json:"cron_job_name,omitempty"`
+	Container   string   `json:"container"`
+	Outputs     []string `json:"outputs"`
+	StartedAt   int64    `json:"started_at"`
+}
+
+// BatchOptions are how a batch or cron workload runs.
+type BatchOptions struct {
+	// Outputs are the directories uploaded after each run.
+	Outputs []string
+	// Deadline is how many seconds a run may take, 0 for no limit.
+	Deadline int
+	// BackoffLimit is how many times a failed run is retried.
+	BackoffLimit int32
+	// Schedule is the cron schedule of a cron job.
+	Schedule string
+	// Service is the service of the deploy.yaml the workload runs, it tells apart the jobs of a space.
+	Service string
+}
+
+// BatchArtifact is an output directory of a batch job, packaged as a gzipped tarball.
+type BatchArtifact struct {
+	Path string `json:"path"`
+	StoredObject
+}
+
+// BatchResult is what a run of a batch or cron job left behind, it is the job result of the run.
+type BatchResult struct {
+	JobUuid string `json:"job_uuid"`
+	// Run is the name of the Kubernetes job of the run.
+	Run        string `json:"run"`
+	Status     string `json:"status"`
+	ExitCode   int32  `json:"exit_code"`
+	Reason     string `json:"reason,omitempty"`
+	StartedAt  int64  `json:"started_at"`
+	FinishedAt int64  `json:"finished_at"`
+	// Artifacts are only collected from a workload that exited with 0.
+	Artifacts []BatchArtifact `json:"artifacts"`
+	Logs      *StoredObject   `json:"logs,omitempty"`
+	LogsTail  string          `json:"logs_tail"`
+	// Errors are the outputs or logs that could not be collected.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// BatchStatus is where a batch job stands, read from the job and its pods.
+type BatchStatus struct {
+	// Finished is set once the workload exited and the collector is done, or the job failed.
+	Finished  bool
+	Succeeded bool
+	// Collectable is set when the collector packaged the outputs of a successful workload.
+	Collectable bool
+	PodName     string
+	// ExitCode is the exit code of the workload, -1 when it did not exit.
+	ExitCode int32
+	Reason   string
+}
+
+func batchConfig() conf.Batch {
+	var c conf.Batch
+	if conf.GetConfig() != nil {
+		c = conf.GetConfig().Batch
+	}
+	if c.CollectorImage == "" {
+		c.CollectorImage = defaultCollectorImage
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultBatchInterval
+	}
+	if c.LogTailLines <= 0 {
+		c.LogTailLines = defaultBatchLogTailLines
+	}
+	if c.BackoffLimit < 0 {
+		c.BackoffLimit = 0
+	}
+	return c
+}
+
+func checkJobType(jobType string) error {
+	switch jobType {
+	case "", constants.JobTypeService, constants.JobTypeBatch, constants.JobTypeCron:
+		return nil
+	}
+	return fmt.Errorf("unknown job type %q, use %s, %s or %s", jobType, constants.JobTypeService, constants.JobTypeBatch, constants.JobTypeCron)
+}
+
+// ResolveJobType returns the type a service of a deploy.yaml runs as: its own type, else the type of the
+// job, else batch for a service declaring outputs and service otherwise.
+func ResolveJobType(jobType string, resource yaml.ContainerResource) (string, error) {
+	resolved := strings.ToLower(strings.TrimSpace(resource.Type))
+	if resolved == "" {
+		resolved = strings.ToLower(strings.TrimSpace(jobType))
+	}
+	if err := checkJobType(resolved); err != nil {
+		return "", err
+	}
+	switch {
+	case resolved == "" && len(resource.Outputs) > 0:
+		resolved = constants.JobTypeBatch
+	case resolved == "":
+		resolved = constants.JobTypeService
+	case resolved == constants.JobTypeService && len(resource.Outputs) > 0:
+		return "", fmt.Errorf("service %s declares outputs, they are only collected from %s and %s jobs", resource.Name, constants.JobTypeBatch, constants.JobTypeCron)
+	}
+	return resolved, nil
+}
+
+// newBatchOptions returns how a batch or cron workload runs, retries overrides the configured backoff limit.
+// A batch job may run for its whole duration, the runs of a cron job are not limited.
+func newBatchOptions(jobType string, outputs []string, retries int, schedule string, duration int) BatchOptions {
+	opts := BatchOptions{
+		Outputs:      outputs,
+		BackoffLimit: int32(batchConfig().BackoffLimit),
+		Schedule:     schedule,
+	}
+	if retries > 0 {
+		opts.BackoffLimit = int32(retries)
+	}
+	if jobType == constants.JobTypeBatch {
+		opts.Deadline = duration
+	}
+	return opts
+}
+
+// cleanOutputs checks the output directories of a batch service, they must be distinct absolute paths.
+func cleanOutputs(outputs []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var cleaned []string
+	for _, output := range outputs {
+		dir := path.Clean(strings.TrimSpace(output))
+		if !path.IsAbs(dir) || dir == "/" {
+			return nil, fmt.Errorf("output %q must be an absolute directory other than /", output)
+		}
+		if seen[dir] {
+			return nil, fmt.Errorf("output %q is declared twice", output)
+		}
+		seen[dir] = true
+		cleaned = append(cleaned, dir)
+	}
+	return cleaned, nil
+}
+
+// batchObjectName names the job or cron job of a workload, each service of a space gets its own.
+func batchObjectName(prefix, spaceName, service string) string {
+	if service == "" {
+		return prefix + spaceName
+	}
+	return prefix + spaceName + "-" + service
+}
+
+// BatchRunField is the field of REDIS_BATCH_ACTIVE a batch or cron workload is tracked under.
+func BatchRunField(jobUuid, service string) string {
+	if service == "" {
+		return jobUuid
+	}
+	return jobUuid + "/" + service
+}
+
+// NewBatchJob builds the job running a workload to completion. The workload runs as an init container
+// with each output directory on a shared volume, then the collector packages every output into a
+// tarball and waits for the provider to fetch them.
+func NewBatchJob(namespace, spaceName string, spec coreV1.PodSpec, workload coreV1.Container, opts BatchOptions) *batchV1.Job {
+	outputs := opts.Outputs
+	var collect strings.Builder
+	collect.WriteString("set -e\n")
+	for i := range outputs {
+		fmt.Fprintf(&collect, "tar czf %[1]s/%[2]d.tar.gz.tmp -C %[3]s/%[2]d . && mv %[1]s/%[2]d.tar.gz.tmp %[1]s/%[2]d.tar.gz\n",
+			batchCollectDir, i, batchOutputsDir)
+	}
+	fmt.Fprintf(&collect, "touch %s/ready\nsleep %d\n", batchCollectDir, batchCollectWait)
+
+	for i, output := range outputs {
+		workload.VolumeMounts = append(workload.VolumeMounts, coreV1.VolumeMount{
+			Name:      batchOutputsVolume,
+			MountPath: output,
+			SubPath:   strconv.Itoa(i),
+		})
+	}
+	collector := coreV1.Container{
+		Name:            batchCollectorName,
+		Image:           batchConfig().CollectorImage,
+		ImagePullPolicy: coreV1.PullIfNotPresent,
+		Command:         []string{"sh", "-c", collect.String()},
+		Resources: coreV1.ResourceRequirements{
+			Limits: coreV1.ResourceList{
+				coreV1.ResourceCPU:    resource.MustParse("200m"),
+				coreV1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+			Requests: coreV1.ResourceList{
+				coreV1.ResourceCPU:    resource.MustParse("100m"),
+				coreV1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+		},
+		VolumeMounts: []coreV1.VolumeMount{
+			{Name: batchOutputsVolume, MountPath: batchOutputsDir, ReadOnly: true},
+			{Name: batchCollectVolume, MountPath: batchCollectDir},
+		},
+		ReadinessProbe: &coreV1.Probe{
+			ProbeHandler: coreV1.ProbeHandler{
+				Exec: &coreV1.ExecAction{Command: []string{"test", "-f", batchCollectDir + "/ready"}},
+			},
+			PeriodSeconds: 5,
+		},
+	}
+
+	spec.RestartPolicy = coreV1.RestartPolicyNever
+	spec.InitContainers = []coreV1.Container{workload}
+	spec.Containers = []coreV1.Container{collector}
+	spec.Volumes = append(spec.Volumes,
+		coreV1.Volume{Name: batchOutputsVolume, VolumeSource: coreV1.VolumeSource{EmptyDir: &coreV1.EmptyDirVolumeSource{}}},
+		coreV1.Volume{Name: batchCollectVolume, VolumeSource: coreV1.VolumeSource{EmptyDir: &coreV1.EmptyDirVolumeSource{}}},
+	)
+	applyPodSecurity(&spec)
+	if spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil && *spec.SecurityContext.RunAsNonRoot {
+		// the collector image runs as root by default
+		nobody := int64(batchNobodyUser)
+		if spec.Containers[0].SecurityContext == nil {
+			spec.Containers[0].SecurityContext = &coreV1.SecurityContext{}
+		}
+		spec.Containers[0].SecurityContext.RunAsUser = &nobody
+	}
+
+	backoffLimit := opts.BackoffLimit
+	labels := map[string]string{"lad_app": spaceName}
+	job := &batchV1.Job{
+		TypeMeta: metaV1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      batchObjectName(constants.K8S_JOB_NAME_PREFIX, spaceName, opts.Service),
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchV1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: coreV1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:    labels,
+					Namespace: namespace,
+				},
+				Spec: spec,
+			},
+		},
+	}
+	if opts.Deadline > 0 {
+		deadline := int64(opts.Deadline)
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	return job
+}
+
+// NewCronJob builds the cron job starting a run of a workload on its schedule, each run is the job
+// NewBatchJob builds. A run is skipped while the previous one has not been collected.
+func NewCronJob(namespace, spaceName string, spec coreV1.PodSpec, workload coreV1.Container, opts BatchOptions) *batchV1.CronJob {
+	job := NewBatchJob(namespace, spaceName, spec, workload, opts)
+	historyLimit := int32(1)
+	return &batchV1.CronJob{
+		TypeMeta: metaV1.TypeMeta{
+			Kind:       "CronJob",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      batchObjectName(constants.K8S_CRONJOB_NAME_PREFIX, spaceName, opts.Service),
+			Namespace: namespace,
+			Labels:    job.Labels,
+		},
+		Spec: batchV1.CronJobSpec{
+			Schedule:                   opts.Schedule,
+			ConcurrencyPolicy:          batchV1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: &historyLimit,
+			FailedJobsHistoryLimit:     &historyLimit,
+			JobTemplate: batchV1.JobTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{Labels: job.Labels},
+				Spec:       job.Spec,
+			},
+		},
+	}
+}
+
+// deployBatchJob runs a workload as a batch or cron job, it is watched by watchBatchJobs until it is gone.
+func deployBatchJob(ctx context.Context, jobUuid, creatorWallet, spaceName, jobType string, spec coreV1.PodSpec, workload coreV1.Container, opts BatchOptions, duration int) error {
+	var err error
+	if opts.Outputs, err = cleanOutputs(opts.Outputs); err != nil {
+		return err
+	}
+	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + creatorWallet
+	run := batchRun{
+		JobUuid:   jobUuid,
+		Type:      jobType,
+		Namespace: k8sNameSpace,
+		SpaceName: spaceName,
+		Wallet:    creatorWallet,
+		Service:   opts.Service,
+		Container: workload.Name,
+		Outputs:   opts.Outputs,
+		StartedAt: time.Now().Unix(),
+	}
+
+	k8sService := NewK8sService()
+	if jobType == constants.JobTypeCron {
+		if opts.Schedule == "" {
+			return fmt.Errorf("the schedule of cron job %s is not set", spaceName)
+		}
+		cronJob := NewCronJob(k8sNameSpace, spaceName, spec, workload, opts)
+		if err = syncTenantQuota(k8sNameSpace, &cronJob.Spec.JobTemplate.Spec.Template.Spec); err != nil {
+			return err
+		}
+		createCronJob, err := k8sService.CreateCronJob(ctx, k8sNameSpace, cronJob)
+		if err != nil {
+			return err
+		}
+		logs.FromContext(ctx).Infof("Created cronJob: %s, schedule: %s", createCronJob.GetName(), opts.Schedule)
+		run.CronJobName = createCronJob.GetName()
+	} else {
+		job := NewBatchJob(k8sNameSpace, spaceName, spec, workload, opts)
+		if err = syncTenantQuota(k8sNameSpace, &job.Spec.Template.Spec); err != nil {
+			return err
+		}
+		createJob, err := k8sService.CreateJob(ctx, k8sNameSpace, job)
+		if err != nil {
+			return err
+		}
+		logs.FromContext(ctx).Infof("Created job: %s", createJob.GetName())
+		run.JobName = createJob.GetName()
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+	if _, err = conn.Do("HSET", constants.REDIS_BATCH_ACTIVE, BatchRunField(jobUuid, run.Service), data); err != nil {
+		return fmt.Errorf("failed track batch job, error: %w", err)
+	}
+
+	// the job is deleted when it outlives its duration
+	watchContainerRunningTime(ctx, jobUuid, k8sNameSpace, spaceName, int64(duration))
+	return nil
+}
+
+func jobFinished(job *batchV1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchV1.JobComplete || condition.Type == batchV1.JobFailed) && condition.Status == coreV1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// InspectBatchJob reads the state of the last run of a batch job from the job and its pods, workload is
+// the name of the init container running the workload. A failed run only finishes the job once it is
+// not retried anymore.
+func InspectBatchJob(job *batchV1.Job, pods []coreV1.Pod, workload string) BatchStatus {
+	status := BatchStatus{ExitCode: -1}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.After(pods[j].CreationTimestamp.Time)
+	})
+	if len(pods) > 0 {
+		pod := pods[0]
+		status.PodName = pod.Name
+		for _, container := range pod.Status.InitContainerStatuses {
+			if container.Name != workload || container.State.Terminated == nil {
+				continue
+			}
+			status.ExitCode = container.State.Terminated.ExitCode
+			status.Succeeded = status.ExitCode == 0
+			if !status.Succeeded {
+				status.Reason = container.State.Terminated.Reason
+			}
+		}
+		if status.Succeeded {
+			for _, container := range pod.Status.ContainerStatuses {
+				if container.Name != batchCollectorName {
+					continue
+				}
+				if container.Ready {
+					status.Finished, status.Collectable = true, true
+					return status
+				}
+				if container.State.Terminated != nil {
+					status.Finished = true
+					status.Reason = "collector exited before packaging the outputs"
+					return status
+				}
+			}
+		}
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchV1.JobFailed && condition.Status == coreV1.ConditionTrue {
+			// a workload that exited with 0 keeps its success even when the outputs are lost
+			status.Finished, status.Collectable = true, false
+			if status.Reason == "" {
+				status.Reason = condition.Reason
+				if condition.Message != "" {
+					status.Reason += ": " + condition.Message
+				}
+			}
+		}
+	}
+	return status
+}
+
+func watchBatchJobs() {
+	ticker := time.NewTicker(time.Duration(batchConfig().Interval) * time.Second)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.GetLogger().Errorf("catch panic error: %+v", err)
+			}
+		}()
+
+		for range ticker.C {
+			checkBatchJobs()
+		}
+	}()
+}
+
+// checkBatchJobs collects the results of the batch and cron runs that finished since the last round.
+func checkBatchJobs() {
+	conn := redisPool.Get()
+	active, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_BATCH_ACTIVE))
+	conn.Close()
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get batch jobs, error: %+v", err)
+		return
+	}
+
+	for field, data := range active {
+		var run batchRun
+		if err = json.Unmarshal([]byte(data), &run); err != nil {
+			logs.GetLogger().Errorf("Failed read batch job %s, error: %+v", field, err)
+			forgetBatchRun(field)
+			continue
+		}
+		checkBatchJob(logs.WithJob(context.Background(), run.JobUuid, run.SpaceName, run.Wallet), &run)
+	}
+}
+
+func checkBatchJob(ctx context.Context, run *batchRun) {
+	if run.Type == constants.JobTypeCron {
+		checkCronJob(ctx, run)
+		return
+	}
+
+	k8sService := NewK8sService()
+	job, err := k8sService.GetJob(ctx, run.Namespace, run.JobName)
+	if errors.IsNotFound(err) {
+		// deleted or expired before it finished
+		logs.FromContext(ctx).Infof("Batch job %s is gone, stop watching it", run.JobName)
+		forgetBatchRun(BatchRunField(run.JobUuid, run.Service))
+		return
+	}
+	if err != nil {
+		logs.FromContext(ctx).Warnf("Failed get batch job %s, error: %+v", run.JobName, err)
+		return
+	}
+	pods, err := k8sService.ListJobPods(ctx, run.Namespace, run.JobName)
+	if err != nil {
+		logs.FromContext(ctx).Warnf("Failed get pods of batch job %s, error: %+v", run.JobName, err)
+		return
+	}
+
+	status := InspectBatchJob(job, pods, run.Container)
+	if !status.Finished {
+		return
+	}
+	logs.FromContext(ctx).Infof("Batch job %s finished, exit code: %d, succeeded: %t", run.JobName, status.ExitCode, status.Succeeded)
+	finishBatchJob(ctx, run, collectBatchResult(ctx, run, job, status))
+}
+
+// checkCronJob collects the finished runs of a cron job, a collected run is deleted.
+func checkCronJob(ctx context.Context, run *batchRun) {
+	k8sService := NewK8sService()
+	if _, err := k8sService.GetCronJob(ctx, run.Namespace, run.CronJobName); err != nil {
+		if errors.IsNotFound(err) {
+			logs.FromContext(ctx).Infof("CronJob %s is gone, stop watching it", run.CronJobName)
+			forgetBatchRun(BatchRunField(run.JobUuid, run.Service))
+			return
+		}
+		logs.FromContext(ctx).Warnf("Failed get cronJob %s, error: %+v", run.CronJobName, err)
+		return
+	}
+	jobs, err := k8sService.ListJobs(ctx, run.Namespace)
+	if err != nil {
+		logs.FromContext(ctx).Warnf("Failed get runs of cronJob %s, error: %+v", run.CronJobName, err)
+		return
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if owner := metaV1.GetControllerOf(job); owner == nil || owner.Kind != "CronJob" || owner.Name != run.CronJobName {
+			continue
+		}
+		pods, err := k8sService.ListJobPods(ctx, run.Namespace, job.Name)
+		if err != nil {
+			logs.FromContext(ctx).Warnf("Failed get pods of job %s, error: %+v", job.Name, err)
+			continue
+		}
+		status := InspectBatchJob(job, pods, run.Container)
+		if !status.Finished {
+			continue
+		}
+		logs.FromContext(ctx).Infof("Run %s of cronJob %s finished, exit code: %d, succeeded: %t", job.Name, run.CronJobName, status.ExitCode, status.Succeeded)
+		finishCronRun(ctx, run, collectBatchResult(ctx, run, job, status))
+	}
+}
+
+// collectBatchResult uploads the logs of a finished run and the outputs of a successful one.
+func collectBatchResult(ctx context.Context, run *batchRun, job *batchV1.Job, status BatchStatus) *BatchResult {
+	ctx, span := startSpan(ctx, "batch.collect", attribute.String("job.uuid", run.JobUuid), attribute.String("job.run", job.Name))
+	defer span.End()
+
+	result := &BatchResult{
+		JobUuid:    run.JobUuid,
+		Run:        job.Name,
+		Status:     jobStateFailed,
+		ExitCode:   status.ExitCode,
+		Reason:     status.Reason,
+		StartedAt:  run.StartedAt,
+		FinishedAt: time.Now().Unix(),
+		Artifacts:  []BatchArtifact{},
+	}
+	if job.Status.StartTime != nil {
+		result.StartedAt = job.Status.StartTime.Unix()
+	}
+	if status.Succeeded {
+		result.Status = jobStateCompleted
+	}
+	if status.PodName == "" {
+		return result
+	}
+
+	var err error
+	if result.Logs, result.LogsTail, err = collectBatchLogs(ctx, run, job.Name, status.PodName); err != nil {
+		logs.FromContext(ctx).Errorf("Failed collect logs of job %s, error: %+v", job.Name, err)
+		result.Errors = append(result.Errors, fmt.Sprintf("logs: %v", err))
+	}
+	if !status.Collectable {
+		return result
+	}
+	for i, output := range run.Outputs {
+		object, err := collectBatchOutput(ctx, run, job.Name, status.PodName, i, output)
+		if err != nil {
+			logs.FromContext(ctx).Errorf("Failed collect output %s of job %s, error: %+v", output, job.Name, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", output, err))
+			continue
+		}
+		result.Artifacts = append(result.Artifacts, BatchArtifact{Path: output, StoredObject: *object})
+	}
+	return result
+}
+
+// collectBatchLogs uploads the logs of the workload and returns their last lines.
+func collectBatchLogs(ctx context.Context, run *batchRun, runName, podName string) (*StoredObject, string, error) {
+	k8sService := NewK8sService()
+	tail, err := k8sService.GetPodLogs(ctx, run.Namespace, podName, run.Container, int64(batchConfig().LogTailLines))
+	if err != nil {
+		return nil, "", err
+	}
+	tailBytes, err := io.ReadAll(tail)
+	tail.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	objectName := filepath.Join("results", run.JobUuid, runName+".log")
+	localPath := filepath.Join(conf.GetConfig().MCS.FileCachePath, objectName)
+	stream, err := k8sService.GetPodLogs(ctx, run.Namespace, podName, run.Container, 0)
+	if err != nil {
+		return nil, string(tailBytes), err
+	}
+	defer stream.Close()
+	if err = writeCacheFile(localPath, stream); err != nil {
+		return nil, string(tailBytes), err
+	}
+	defer os.Remove(localPath)
+	object, err := uploadFile(ctx, objectName, localPath)
+	return object, string(tailBytes), err
+}
+
+// collectBatchOutput fetches the tarball of an output from the collector and uploads it.
+func collectBatchOutput(ctx context.Context, run *batchRun, runName, podName string, index int, output string) (*StoredObject, error) {
+	name := strings.Trim(artifactNamePattern.ReplaceAllString(path.Base(output), "_"), "_")
+	objectName := filepath.Join("artifacts", run.JobUuid, runName, fmt.Sprintf("%d-%s.tar.gz", index, name))
+	localPath := filepath.Join(conf.GetConfig().MCS.FileCachePath, objectName)
+
+	reader, writer := io.Pipe()
+	go func() {
+		command := []string{"cat", fmt.Sprintf("%s/%d.tar.gz", batchCollectDir, index)}
+		writer.CloseWithError(NewK8sService().ExecPod(ctx, run.Namespace, podName, batchCollectorName, command, writer))
+	}()
+	err := writeCacheFile(localPath, reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(localPath)
+	return uploadFile(ctx, objectName, localPath)
+}
+
+func writeCacheFile(filePath string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if _, err = io.Copy(writer, content); err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// finishBatchJob publishes the result of a batch job, reports it and deletes the job with its pods. The
+// resources of the space are released once none of its services runs anymore.
+func finishBatchJob(ctx context.Context, run *batchRun, result *BatchResult) {
+	resultURI := saveBatchResult(ctx, result)
+	setJobReceiptResult(run.Namespace, run.SpaceName, resultURI)
+
+	var err error
+	reason := TerminationCompleted
+	if result.Status != jobStateCompleted {
+		reason = TerminationFailed
+		err = fmt.Errorf("batch job exited with code %d: %s", result.ExitCode, result.Reason)
+	}
+	jobsTotal.WithLabelValues(result.Status).Inc()
+	reportJobResult(ctx, run.JobUuid, result.Status, resultURI, err)
+
+	if err = NewK8sService().DeleteJob(ctx, run.Namespace, run.JobName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete job, jobName: %s, error: %+v", run.JobName, err)
+	}
+	forgetBatchRun(BatchRunField(run.JobUuid, run.Service))
+
+	running, err := spaceRunning(ctx, run)
+	if err != nil {
+		// the space is released when the job expires
+		logs.FromContext(ctx).Errorf("Failed check the services of space %s, error: %+v", run.SpaceName, err)
+		return
+	}
+	if running {
+		logs.FromContext(ctx).Infof("Batch job %s finished, other services of space %s keep running", run.JobName, run.SpaceName)
+		return
+	}
+	deleteJob(ctx, run.Namespace, run.SpaceName, reason)
+	conn := redisPool.Get()
+	conn.Do("DEL", run.JobUuid, constants.REDIS_FULL_PREFIX+run.JobUuid)
+	conn.Close()
+	releaseWalletSpace(run.Wallet, run.SpaceName)
+}
+
+// spaceRunning reports whether the job of a batch run still runs a deployment or other batch and cron jobs.
+func spaceRunning(ctx context.Context, run *batchRun) (bool, error) {
+	conn := redisPool.Get()
+	active, err := JobHasBatchRuns(conn, run.JobUuid)
+	conn.Close()
+	if err != nil || active {
+		return active, err
+	}
+	_, err = NewK8sService().GetDeploymentImages(ctx, run.Namespace, constants.K8S_DEPLOY_NAME_PREFIX+run.SpaceName)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// JobHasBatchRuns reports whether any batch or cron workload of a job is still tracked.
+func JobHasBatchRuns(conn redis.Conn, jobUuid string) (bool, error) {
+	fields, err := redis.Strings(conn.Do("HKEYS", constants.REDIS_BATCH_ACTIVE))
+	if err != nil {
+		return false, err
+	}
+	for _, field := range fields {
+		if field == jobUuid || strings.HasPrefix(field, jobUuid+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// finishCronRun publishes the result of a run of a cron job and reports it, the cron job keeps running
+// until it is deleted or expires.
+func finishCronRun(ctx context.Context, run *batchRun, result *BatchResult) {
+	resultURI := saveBatchResult(ctx, result)
+	setJobReceiptResult(run.Namespace, run.SpaceName, resultURI)
+
+	var err error
+	state := jobStateRunCompleted
+	if result.Status != jobStateCompleted {
+		state = jobStateRunFailed
+		err = fmt.Errorf("run %s exited with code %d: %s", result.Run, result.ExitCode, result.Reason)
+	}
+	jobsTotal.WithLabelValues(state).Inc()
+	reportJobResult(ctx, run.JobUuid, state, resultURI, err)
+
+	if err = NewK8sService().DeleteJob(ctx, run.Namespace, result.Run); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete job, jobName: %s, error: %+v", result.Run, err)
+	}
+}
+
+// saveBatchResult keeps the result of a run and uploads it, it returns where the result can be read.
+func saveBatchResult(ctx context.Context, result *BatchResult) string {
+	data, err := json.Marshal(result)
+	if err != nil {
+		logs.FromContext(ctx).Errorf("Failed marshal batch result, error: %+v", err)
+		return ""
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+	key := constants.REDIS_JOB_RESULT_PREFIX + result.JobUuid
+	conn.Send("MULTI")
+	conn.Send("RPUSH", key, data)
+	conn.Send("EXPIRE", key, jobReceiptTTL)
+	if _, err = conn.Do("EXEC"); err != nil {
+		logs.FromContext(ctx).Errorf("Failed save batch result, error: %+v", err)
+	}
+
+	objectName := filepath.Join("results", result.JobUuid, result.Run+".json")
+	localPath := filepath.Join(conf.GetConfig().MCS.FileCachePath, objectName)
+	if err = writeCacheFile(localPath, bytes.NewReader(data)); err != nil {
+		logs.FromContext(ctx).Errorf("Failed write batch result, error: %+v", err)
+		return ""
+	}
+	defer os.Remove(localPath)
+	object, err := uploadFile(ctx, objectName, localPath)
+	if err != nil {
+		logs.FromContext(ctx).Errorf("Failed upload batch result, error: %+v", err)
+		return ""
+	}
+	logs.FromContext(ctx).Infof("Result of run %s uploaded to %s", result.Run, object.URI)
+	return object.URI
+}
+
+func forgetBatchRun(field string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	conn.Do("HDEL", constants.REDIS_BATCH_ACTIVE, field)
+}
+
+// GetJobResults returns the results of the finished runs of a batch or cron job.
+func GetJobResults(c *gin.Context) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", constants.REDIS_JOB_RESULT_PREFIX+c.Param("uuid"), 0, -1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+	if len(values) == 0 {
+		c.JSON(http.StatusNotFound, common.CreateErrorResponse(strconv.Itoa(http.StatusNotFound), "no result for the job"))
+		return
+	}
+	results := make([]BatchResult, 0, len(values))
+	for _, data := range values {
+		var result BatchResult
+		if err = json.Unmarshal(data, &result); err != nil {
+			c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+			return
+		}
+		results = append(results, result)
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(results))
+}
@@ -2,19 +2,19 @@ package computing
 
 import (
 	// ... other imports ...
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
-	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	"github.com/lagrangedao/go-computing-provider/docker"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"io/fs"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -30,7 +30,7 @@ func getSpaceName(apiURL string) (string, string, error) {
 	pathSegments := parsedURL.Path[1:]
 	segments := strings.Split(pathSegments, "/")
 
-	if len(segments) < 2 || segments[0] != "spaces" {
+	if len(segments) < 3 || segments[0] != "spaces" {
 		return "", "", errors.New("invalid URL format")
 	}
 
@@ -39,95 +39,81 @@ func getSpaceName(apiURL string) (string, string, error) {
 	return creator, spaceName, nil
 }
 
-func BuildSpaceTaskImage(spaceName, jobSourceURI string) (bool, string, string, error) {
-	logs.GetLogger().Infof("Attempting to download spaces from Lagrange. Spaces name: %s", spaceName)
-
-	spaceAPIURL := fmt.Sprintf(jobSourceURI)
-	resp, err := http.Get(spaceAPIURL)
+func BuildSpaceTaskImage(ctx context.Context, spaceName, jobSourceURI string) (bool, string, string, error) {
+	source, err := NewSpaceSource(jobSourceURI)
 	if err != nil {
-		return false, "", "", fmt.Errorf("error making request to Space API: %w", err)
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-
-		}
-	}(resp.Body)
-	logs.GetLogger().Infof("Space API response received. Response: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusOK {
-		return false, "", "", fmt.Errorf("space API response not OK. Status Code: %d", resp.StatusCode)
-	}
-
-	var spaceJSON struct {
-		Data struct {
-			Files []struct {
-				Name string `json:"name"`
-				URL  string `json:"url"`
-			} `json:"files"`
-		} `json:"data"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&spaceJSON); err != nil {
-		return false, "", "", fmt.Errorf("error decoding Space API response JSON: %v", err)
+		return false, "", "", err
 	}
+	logs.FromContext(ctx).Infof("Attempting to download spaces. Spaces name: %s, source: %s", spaceName, jobSourceURI)
 
 	buildFolder := "build/"
-	files := spaceJSON.Data.Files
-	if len(files) > 0 {
-		downloadSpacePath := filepath.Join(filepath.Dir(files[0].Name), filepath.Base(files[0].Name))
-		for _, file := range files {
-			dirPath := filepath.Dir(file.Name)
-			if err = os.MkdirAll(filepath.Join(buildFolder, dirPath), os.ModePerm); err != nil {
-				return false, "", "", err
-			}
-			if err = downloadFile(filepath.Join(buildFolder, file.Name), file.URL); err != nil {
-				return false, "", "", fmt.Errorf("error downloading file: %w", err)
-			}
-			logs.GetLogger().Infof("Download %s successfully.", spaceName)
+	fetchCtx, span := startSpan(ctx, "space.download", attribute.String("space.source", jobSourceURI))
+	imagePath, err := source.Fetch(fetchCtx, buildFolder)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, NotFoundError) {
+			logs.FromContext(ctx).Warnf("Space %s is not found.", spaceName)
 		}
+		return false, "", "", err
+	}
+	logs.FromContext(ctx).Infof("Download %s successfully.", spaceName)
 
-		imagePath := filepath.Join(buildFolder, filepath.Dir(downloadSpacePath))
-		var containsYaml bool
-		var yamlPath string
-		err = filepath.Walk(imagePath, func(path string, info fs.FileInfo, err error) error {
-			if strings.HasSuffix(info.Name(), "deploy.yaml") || strings.HasSuffix(info.Name(), "deploy.yml") {
-				containsYaml = true
-				yamlPath = path
-				return filepath.SkipDir
-			}
-			return nil
-		})
+	var containsYaml bool
+	var yamlPath string
+	err = filepath.Walk(imagePath, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
-			return containsYaml, yamlPath, imagePath, err
+			return err
 		}
-		return containsYaml, yamlPath, imagePath, nil
-	} else {
-		logs.GetLogger().Warnf("Space %s is not found.", spaceName)
+		if strings.HasSuffix(info.Name(), "deploy.yaml") || strings.HasSuffix(info.Name(), "deploy.yml") {
+			containsYaml = true
+			yamlPath = path
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return containsYaml, yamlPath, imagePath, err
 	}
-	return false, "", "", NotFoundError
+	return containsYaml, yamlPath, imagePath, nil
 }
 
-func BuildImagesByDockerfile(spaceName, imagePath string) (string, string) {
-	imageName := fmt.Sprintf("lagrange/%s:%d", spaceName, time.Now().Unix())
-	if conf.GetConfig().Registry.UserName != "" {
-		imageName = fmt.Sprintf("%s/%s:%d",
-			strings.TrimSpace(conf.GetConfig().Registry.UserName), spaceName, time.Now().Unix())
+func BuildImagesByDockerfile(ctx context.Context, spaceName, imagePath string) (string, string) {
+	tag := strconv.FormatInt(time.Now().Unix(), 10)
+	imageName := strings.ToLower(fmt.Sprintf("lagrange/%s:%s", spaceName, tag))
+	registry := docker.PushRegistry()
+	if registry != nil {
+		imageName = registry.ImageName(spaceName, tag)
 	}
-	imageName = strings.ToLower(imageName)
 	dockerfilePath := filepath.Join(imagePath, "Dockerfile")
-	log.Printf("Image path: %s", imagePath)
+	logs.FromContext(ctx).Infof("Image path: %s", imagePath)
 
+	start := time.Now()
 	dockerService := docker.NewDockerService()
-	if err := dockerService.BuildImage(imagePath, imageName); err != nil {
-		logs.GetLogger().Errorf("Error building Docker image: %v", err)
+	buildCtx, span := startSpan(ctx, "docker.build", attribute.String("image.name", imageName))
+	err := dockerService.BuildImage(buildCtx, imagePath, imageName)
+	endSpan(span, err)
+	if err != nil {
+		logs.FromContext(ctx).Errorf("Error building Docker image: %v", err)
+		buildFailures.WithLabelValues(buildFailureBuild).Inc()
+		buildDuration.WithLabelValues(metricResult(err)).Observe(time.Since(start).Seconds())
 		return "", ""
 	}
+	// tracked right away so that images of failed deployments are garbage collected too
+	recordBuiltImage(imageName)
 
-	if conf.GetConfig().Registry.UserName != "" {
-		if err := dockerService.PushImage(imageName); err != nil {
-			logs.GetLogger().Errorf("Error Docker push image: %v", err)
+	if registry != nil {
+		pushCtx, span := startSpan(ctx, "docker.push", attribute.String("image.name", imageName))
+		err := dockerService.PushImage(pushCtx, imageName)
+		endSpan(span, err)
+		if err != nil {
+			logs.FromContext(ctx).Errorf("Error Docker push image: %v", err)
+			buildFailures.WithLabelValues(buildFailurePush).Inc()
+			buildDuration.WithLabelValues(metricResult(err)).Observe(time.Since(start).Seconds())
 			return "", ""
 		}
+		recordPushedImage(imageName)
 	}
+	buildDuration.WithLabelValues(metricResult(nil)).Observe(time.Since(start).Seconds())
 	return imageName, dockerfilePath
 }
 
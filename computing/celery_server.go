@@ -6,9 +6,9 @@ import (
 
 	"github.com/lagrangedao/go-computing-provider/conf"
 
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/gocelery/gocelery"
 	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 )
 
 var redisPool *redis.Pool
@@ -33,9 +33,10 @@ func newRedisPool(url string, password string) *redis.Pool {
 				conn, err = redis.DialURL(url, redis.DialPassword(password))
 			}
 			if err != nil {
+				redisErrors.WithLabelValues("DIAL").Inc()
 				return nil, err
 			}
-			return conn, err
+			return metricsConn{conn}, err
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			_, err := c.Do("PING")
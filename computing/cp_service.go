@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/lagrangedao/go-computing-provider/docker"
 	"github.com/lagrangedao/go-computing-provider/yaml"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	appV1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -21,11 +23,11 @@ import (
 
 	"github.com/lagrangedao/go-computing-provider/conf"
 
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/gin-gonic/gin"
 	"github.com/gomodule/redigo/redis"
 	"github.com/google/uuid"
 	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	"github.com/lagrangedao/go-computing-provider/constants"
 	"github.com/lagrangedao/go-computing-provider/models"
 	coreV1 "k8s.io/api/core/v1"
@@ -50,18 +52,97 @@ func ReceiveJob(c *gin.Context) {
 		return
 	}
 	logs.GetLogger().Infof("Job received: %s", jobData.JobSourceURI)
+	jobsTotal.WithLabelValues(jobStateReceived).Inc()
+
+	if err := checkReady(); err != nil {
+		logs.GetLogger().Warnf("Job %s refused: %v", jobData.UUID, err)
+		jobsTotal.WithLabelValues(jobStateDeclined).Inc()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	jobData.Type = strings.ToLower(strings.TrimSpace(jobData.Type))
+	if err := checkJobType(jobData.Type); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// a submission retried by LAD must not redeploy the job it created
+	idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+	conn := redisPool.Get()
+	defer conn.Close()
+	recorded, err := FindJobSubmission(conn, jobData.UUID, idempotencyKey)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get job submission, uuid: %s, error: %v", jobData.UUID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if recorded != nil {
+		replayJobSubmission(c, recorded, jobData)
+		return
+	}
 
 	jobSourceURI := jobData.JobSourceURI
-	creator, spaceName, err := getSpaceName(jobSourceURI)
+	creator, spaceName, err := resolveSpaceName(jobSourceURI)
 	if err != nil {
 		logs.GetLogger().Errorf("Failed get space name: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = checkHardwareGpu(jobData.Hardware); err != nil {
+		logs.GetLogger().Warnf("Job %s rejected: %v", jobData.UUID, err)
+		jobsTotal.WithLabelValues(jobStateDeclined).Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bid, err := autobidJob(jobData.Hardware, jobData.Duration, jobData.Price)
+	if err != nil {
+		logs.GetLogger().Warnf("Job %s rejected: %v", jobData.UUID, err)
+		jobsTotal.WithLabelValues(jobStateDeclined).Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !bid.Accept {
+		logs.GetLogger().Warnf("Job %s declined by autobid: %s", jobData.UUID, strings.Join(bid.Reasons, "; "))
+		jobsTotal.WithLabelValues(jobStateDeclined).Inc()
+		c.JSON(http.StatusConflict, gin.H{"error": "job declined by autobid policy", "reasons": bid.Reasons})
+		return
+	}
+
+	// batch and cron jobs are not served
+	var hostName string
+	if jobData.Type == "" || jobData.Type == constants.JobTypeService {
+		hostName = generateString(10) + conf.GetConfig().API.Domain
+		jobData.HostName = hostName
+		jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
+	}
+	// the submission is claimed before the wallet quota, a concurrent retry must not count the job twice
+	if recorded, err = ClaimJobSubmission(conn, jobData, idempotencyKey); err != nil {
+		logs.GetLogger().Errorf("Failed record job submission, uuid: %s, error: %v", jobData.UUID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if recorded != nil {
+		replayJobSubmission(c, recorded, jobData)
+		return
+	}
+
+	if err = admitWalletJob(creator, spaceName, jobData.UUID, jobData.Hardware, jobData.Duration); err != nil {
+		logs.GetLogger().Warnf("Job %s rejected: %v", jobData.UUID, err)
+		jobsTotal.WithLabelValues(jobStateDeclined).Inc()
+		ReleaseJobSubmission(conn, jobData.UUID, idempotencyKey)
+		c.JSON(walletQuotaStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	hostName := generateString(10) + conf.GetConfig().API.Domain
-	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY, creator, spaceName, jobSourceURI, jobData.Hardware, hostName, jobData.Duration, jobData.UUID)
+	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY_V2, creator, spaceName, jobSourceURI, jobData.Hardware, hostName, jobData.Duration, jobData.UUID, jobData.Type, jobData.Schedule, TraceCarrier(c.Request.Context()))
 	if err != nil {
 		logs.GetLogger().Errorf("Failed sync delpoy task, error: %v", err)
+		ReleaseJobSubmission(conn, jobData.UUID, idempotencyKey)
+		rollbackWalletJob(creator, jobData.UUID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	go func() {
@@ -73,14 +154,88 @@ func ReceiveJob(c *gin.Context) {
 		logs.GetLogger().Infof("Job: %s, service running successfully, job_result_url: %s", jobSourceURI, result.(string))
 	}()
 
-	jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
-	submitJob(&jobData)
-
+	submitJob(c.Request.Context(), conn, &jobData)
 	c.JSON(http.StatusOK, jobData)
 }
 
-func submitJob(jobData *models.JobData) {
+// submitJob uploads the detail of a submitted job and records it, the job result points to the uploaded
+// detail. An upload slower than jobDetailUploadWait goes on in the background, the job result is the URL
+// of the service until it finishes and is then reported to LAD.
+func submitJob(ctx context.Context, conn redis.Conn, jobData *models.JobData) {
 	logs.GetLogger().Printf("submitting job...")
+	jobData.Status = constants.BiddingSubmitted
+	jobData.UpdatedAt = strconv.FormatInt(time.Now().Unix(), 10)
+
+	jobDetailFile, taskDetailFilePath, err := writeJobDetail(*jobData)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed write job detail, error: %v", err)
+		if err = UpdateJobSubmission(conn, *jobData, ""); err != nil {
+			logs.GetLogger().Errorf("Failed update job submission, uuid: %s, error: %v", jobData.UUID, err)
+		}
+		return
+	}
+	// the job detail is a new object for every submission and redeployment
+	run := jobDetailFile
+
+	// the upload outlives the request when it is slow
+	jobUuid, state := jobData.UUID, jobData.Status
+	uploadCtx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	uploaded := make(chan string, 1)
+	go func() {
+		ctx, span := startSpan(uploadCtx, "storage.upload", attribute.String("job.uuid", jobUuid))
+		object, err := uploadFile(ctx, jobDetailFile, taskDetailFilePath)
+		endSpan(span, err)
+		if err != nil {
+			// the job still runs, it is reported with the URL of its service
+			logs.GetLogger().Errorf("Failed upload job detail, the job result stays the service URL, error: %v", err)
+			uploaded <- ""
+			return
+		}
+		logs.GetLogger().Printf("Job submitted to storage %s", object.URI)
+		uploaded <- object.URI
+	}()
+
+	timer := time.NewTimer(jobDetailUploadWait)
+	defer timer.Stop()
+	var pending bool
+	select {
+	case resultURI := <-uploaded:
+		if resultURI != "" {
+			jobData.JobResultURI = resultURI
+		}
+	case <-timer.C:
+		logs.GetLogger().Warnf("Upload of the detail of job %s is slow, answering with %s", jobData.UUID, jobData.JobResultURI)
+		pending = true
+	}
+	if err = UpdateJobSubmission(conn, *jobData, run); err != nil {
+		logs.GetLogger().Errorf("Failed update job submission, uuid: %s, error: %v", jobData.UUID, err)
+	}
+	if !pending {
+		return
+	}
+
+	go func() {
+		resultURI := <-uploaded
+		if resultURI == "" {
+			return
+		}
+		conn := redisPool.Get()
+		set, err := SetJobSubmissionResult(conn, jobUuid, run, resultURI)
+		conn.Close()
+		if err != nil {
+			logs.GetLogger().Errorf("Failed update job submission, uuid: %s, error: %v", jobUuid, err)
+			return
+		}
+		if !set {
+			logs.GetLogger().Infof("Job %s was redeployed during the upload of its detail, %s is not reported", jobUuid, resultURI)
+			return
+		}
+		reportJobResult(uploadCtx, jobUuid, state, resultURI, nil)
+	}()
+}
+
+// writeJobDetail writes the job as JSON into the file cache, returning the object name and path of the file.
+func writeJobDetail(jobData models.JobData) (string, string, error) {
 	oldMask := syscall.Umask(0)
 	defer syscall.Umask(oldMask)
 
@@ -90,33 +245,14 @@ func submitJob(jobData *models.JobData) {
 	os.MkdirAll(filepath.Join(fileCachePath, folderPath), os.ModePerm)
 	taskDetailFilePath := filepath.Join(fileCachePath, jobDetailFile)
 
-	jobData.Status = constants.BiddingSubmitted
-	jobData.UpdatedAt = strconv.FormatInt(time.Now().Unix(), 10)
 	bytes, err := json.Marshal(jobData)
 	if err != nil {
-		logs.GetLogger().Errorf("Failed Marshal JobData, error: %v", err)
-		return
+		return "", "", err
 	}
 	if err = os.WriteFile(taskDetailFilePath, bytes, os.ModePerm); err != nil {
-		logs.GetLogger().Errorf("Failed jobData write to file, error: %v", err)
-		return
+		return "", "", err
 	}
-
-	storageService := NewStorageService()
-	mcsOssFile, err := storageService.UploadFileToBucket(jobDetailFile, taskDetailFilePath, true)
-	if err != nil {
-		logs.GetLogger().Errorf("Failed upload file to bucket, error: %v", err)
-		return
-	}
-	mcsFileJson, _ := json.Marshal(mcsOssFile)
-	logs.GetLogger().Printf("Job submitted to IPFS %s", string(mcsFileJson))
-
-	gatewayUrl, err := storageService.GetGatewayUrl()
-	if err != nil {
-		logs.GetLogger().Errorf("Failed get mcs ipfs gatewayUrl, error: %v", err)
-		return
-	}
-	jobData.JobResultURI = *gatewayUrl + "/ipfs/" + mcsOssFile.PayloadCid
+	return jobDetailFile, taskDetailFilePath, nil
 }
 
 func RedeployJob(c *gin.Context) {
@@ -128,23 +264,45 @@ func RedeployJob(c *gin.Context) {
 	}
 	logs.GetLogger().Infof("Job received: %+v", jobData)
 
+	if err := checkReady(); err != nil {
+		logs.GetLogger().Warnf("Job %s refused: %v", jobData.UUID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	jobData.Type = strings.ToLower(strings.TrimSpace(jobData.Type))
+	if err := checkJobType(jobData.Type); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	jobSourceURI := jobData.JobSourceURI
-	creator, spaceName, err := getSpaceName(jobSourceURI)
+	creator, spaceName, err := resolveSpaceName(jobSourceURI)
 	if err != nil {
 		logs.GetLogger().Errorf("Failed get space name: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = admitWalletJob(creator, spaceName, jobData.UUID, jobData.Hardware, jobData.Duration); err != nil {
+		logs.GetLogger().Warnf("Job %s rejected: %v", jobData.UUID, err)
+		c.JSON(walletQuotaStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	var hostName string
-	if jobData.JobResultURI != "" {
+	switch {
+	case jobData.Type != "" && jobData.Type != constants.JobTypeService:
+	case jobData.JobResultURI != "":
 		hostName = strings.ReplaceAll(jobData.JobResultURI, "https://", "")
-	} else {
+	default:
 		hostName = generateString(10) + conf.GetConfig().API.Domain
 	}
 
-	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY, creator, spaceName, jobSourceURI, jobData.Hardware, hostName, jobData.Duration)
+	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY_V2, creator, spaceName, jobSourceURI, jobData.Hardware, hostName, jobData.Duration, jobData.UUID, jobData.Type, jobData.Schedule, TraceCarrier(c.Request.Context()))
 	if err != nil {
 		logs.GetLogger().Errorf("Failed sync delpoy task, error: %v", err)
+		rollbackWalletJob(creator, jobData.UUID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	logs.GetLogger().Infof("delayTask detail info: %+v", delayTask)
@@ -158,8 +316,14 @@ func RedeployJob(c *gin.Context) {
 		logs.GetLogger().Infof("Job: %s, service running successfully, job_result_url: %s", jobSourceURI, result.(string))
 	}()
 
-	jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
-	submitJob(&jobData)
+	if hostName != "" {
+		jobData.HostName = hostName
+		jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
+	}
+	// retries of the original submission return the redeployed job
+	conn := redisPool.Get()
+	defer conn.Close()
+	submitJob(c.Request.Context(), conn, &jobData)
 	logs.GetLogger().Infof("update Job received: %+v", jobData)
 
 	c.JSON(http.StatusOK, jobData)
@@ -211,11 +375,22 @@ func ReNewJob(c *gin.Context) {
 			"message": "The job was terminated due to its expiration date",
 		})
 	} else {
+		expireTime := time.Now().Unix() + leftTime + int64(jobData.Duration)
+		wallet := strings.TrimPrefix(namespace, constants.K8S_NAMESPACE_NAME_PREFIX)
+		if err = renewWalletJob(wallet, jobData.JobUuid, jobData.Duration, expireTime); err != nil {
+			logs.GetLogger().Warnf("Renew of job %s rejected: %v", jobData.JobUuid, err)
+			c.JSON(http.StatusTooManyRequests, map[string]string{
+				"status":  "failed",
+				"message": err.Error(),
+			})
+			return
+		}
+
 		fullArgs := []interface{}{redisKey}
 		fields := map[string]string{
 			"k8s_namespace": namespace,
 			"space_name":    spaceName,
-			"expire_time":   strconv.Itoa(int(time.Now().Unix()) + int(leftTime) + jobData.Duration),
+			"expire_time":   strconv.FormatInt(expireTime, 10),
 		}
 		for key, val := range fields {
 			fullArgs = append(fullArgs, key, val)
@@ -243,7 +418,9 @@ func DeleteJob(c *gin.Context) {
 	}
 
 	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(deleteJobReq.CreatorWallet)
-	deleteJob(k8sNameSpace, deleteJobReq.SpaceName)
+	ctx := logs.WithJob(c.Request.Context(), "", deleteJobReq.SpaceName, deleteJobReq.CreatorWallet)
+	deleteJob(ctx, k8sNameSpace, deleteJobReq.SpaceName, TerminationDeleted)
+	releaseWalletSpace(deleteJobReq.CreatorWallet, deleteJobReq.SpaceName)
 	c.JSON(http.StatusOK, common.CreateSuccessResponse("deleted success"))
 }
 
@@ -268,28 +445,156 @@ func StatisticalSources(c *gin.Context) {
 	})
 }
 
-func DeploySpaceTask(creator, spaceName, jobSourceURI, hardware, hostName string, duration int, jobUuid string) string {
-	logs.GetLogger().Infof("Processing job: %s", jobSourceURI)
-	containsYaml, yamlPath, imagePath, err := BuildSpaceTaskImage(spaceName, jobSourceURI)
+// DeploySpaceTaskV1 runs the deploy tasks queued with the arguments of worker.deploy, before the job type,
+// schedule and trace context were added, as service jobs without a trace.
+func DeploySpaceTaskV1(creator, spaceName, jobSourceURI, hardware, hostName string, duration int, jobUuid string) string {
+	return DeploySpaceTask(creator, spaceName, jobSourceURI, hardware, hostName, duration, jobUuid, "", "", "")
+}
+
+// DeploySpaceTask is the celery task deploying a job, traceContext carries the trace of the request that queued it.
+// It returns the host the job is served on, empty for a job without exposed ports.
+func DeploySpaceTask(creator, spaceName, jobSourceURI, hardware, hostName string, duration int, jobUuid, jobType, schedule, traceContext string) string {
+	ctx, span := startSpan(ContextFromCarrier(context.Background(), traceContext), "DeploySpaceTask",
+		attribute.String("job.uuid", jobUuid), attribute.String("job.space", spaceName), attribute.String("job.hardware", hardware))
+	ctx = logs.WithJob(ctx, jobUuid, spaceName, creator)
+	logs.FromContext(ctx).Infof("Processing job: %s", jobSourceURI)
+	start, deployKind, deployErr := time.Now(), deployKindDockerfile, fmt.Errorf("job %s was not deployed", jobUuid)
+	var servedHost string
+	defer func() {
+		span.SetAttributes(attribute.String("job.kind", deployKind))
+		endSpan(span, deployErr)
+		state := jobStateDeployed
+		if deployErr != nil {
+			state = jobStateFailed
+		}
+		jobsTotal.WithLabelValues(state).Inc()
+		if deployErr != nil {
+			// a job that is not running does not count against the wallet
+			rollbackWalletJob(creator, jobUuid)
+		}
+		reportJobStatus(ctx, jobUuid, state, deployErr)
+		deployDuration.WithLabelValues(deployKind, metricResult(deployErr)).Observe(time.Since(start).Seconds())
+	}()
+
+	if err := checkHardwareGpu(hardware); err != nil {
+		logs.FromContext(ctx).Errorf("Job %s rejected: %v", jobUuid, err)
+		return ""
+	}
+	containsYaml, yamlPath, imagePath, err := BuildSpaceTaskImage(ctx, spaceName, jobSourceURI)
 	if err != nil {
-		logs.GetLogger().Error(err)
+		buildFailures.WithLabelValues(buildFailureDownload).Inc()
+		logs.FromContext(ctx).Error(err)
 		return ""
 	}
 
 	creator = strings.ToLower(creator)
 	spaceName = strings.ToLower(spaceName)
 	if containsYaml {
-		yamlToK8s(jobUuid, creator, spaceName, yamlPath, hostName, duration)
+		deployKind = deployKindYaml
+		containerResources, err := yaml.HandlerYaml(yamlPath)
+		if err != nil {
+			buildFailures.WithLabelValues(buildFailureYaml).Inc()
+			logs.FromContext(ctx).Error(err)
+			return ""
+		}
+		// the gpus of a deploy.yaml are only known now, the hardware profile counted at admission may be lower
+		if err = claimWalletGpus(creator, jobUuid, yaml.CountGpus(containerResources)); err != nil {
+			logs.FromContext(ctx).Errorf("Job %s rejected: %v", jobUuid, err)
+			deployErr = err
+			return ""
+		}
+		if bid := autobidYaml(jobUuid, containerResources, duration); !bid.Accept {
+			deployErr = fmt.Errorf("job declined by autobid policy: %s", strings.Join(bid.Reasons, "; "))
+			logs.FromContext(ctx).Warnf("Job %s rejected: %v", jobUuid, deployErr)
+			return ""
+		}
+
+		for _, resource := range containerResources {
+			if req := yamlGpuRequest(resource); req != nil {
+				if _, err = findGpuNodes(ctx, req); err != nil {
+					logs.FromContext(ctx).Errorf("Job %s rejected: %v", jobUuid, err)
+					return ""
+				}
+			}
+		}
+
+		var targets []ImagePolicyTarget
+		for _, resource := range containerResources {
+			targets = append(targets, ImagePolicyTarget{Image: resource.ImageName, CheckRegistry: true, CheckRuntime: true, Pull: true})
+			for _, depend := range resource.Depends {
+				targets = append(targets, ImagePolicyTarget{Image: depend.ImageName, CheckRegistry: true, CheckRuntime: true, Pull: true})
+			}
+		}
+		_, policySpan := startSpan(ctx, "image.policy")
+		verdict := checkImagePolicy(jobUuid, targets)
+		policySpan.SetAttributes(attribute.Bool("image.allowed", verdict.Allowed))
+		policySpan.End()
+		if !verdict.Allowed {
+			buildFailures.WithLabelValues(buildFailureImagePolicy).Inc()
+			logs.FromContext(ctx).Errorf("Job %s rejected by image policy: %+v", jobUuid, verdict.Images)
+			return ""
+		}
+		if servedHost, deployErr = yamlToK8s(ctx, jobUuid, creator, spaceName, yamlPath, hostName, jobType, schedule, containerResources, duration); deployErr != nil {
+			logs.FromContext(ctx).Errorf("Failed deploy job %s, error: %v", jobUuid, deployErr)
+			return ""
+		}
 	} else {
-		imageName, dockerfilePath := BuildImagesByDockerfile(spaceName, imagePath)
 		r, ok := common.HardwareResource[hardware]
 		if !ok {
-			logs.GetLogger().Warnf("not found resource.")
+			logs.FromContext(ctx).Warnf("not found resource.")
+			return ""
+		}
+
+		// the base images are checked before building, so that denied images are never built or pushed
+		baseImages, err := docker.ExtractBaseImages(filepath.Join(imagePath, "Dockerfile"))
+		if err != nil {
+			logs.FromContext(ctx).Warnf("Failed to extract base images: %v", err)
+		}
+		var targets []ImagePolicyTarget
+		for _, baseImage := range baseImages {
+			targets = append(targets, ImagePolicyTarget{Image: baseImage, CheckRegistry: true})
+		}
+		_, policySpan := startSpan(ctx, "image.policy")
+		verdict := evaluateImagePolicy(jobUuid, targets)
+		policySpan.SetAttributes(attribute.Bool("image.allowed", verdict.Allowed))
+		policySpan.End()
+		if !verdict.Allowed {
+			if err = saveImagePolicyVerdict(verdict); err != nil {
+				logs.FromContext(ctx).Errorf("Failed save image policy verdict, job: %s, error: %+v", jobUuid, err)
+			}
+			buildFailures.WithLabelValues(buildFailureImagePolicy).Inc()
+			logs.FromContext(ctx).Errorf("Job %s rejected by image policy: %+v", jobUuid, verdict.Images)
+			return ""
+		}
+
+		imageName, dockerfilePath := BuildImagesByDockerfile(ctx, spaceName, imagePath)
+		if imageName == "" {
 			return ""
 		}
-		dockerfileToK8s(jobUuid, hostName, creator, spaceName, imageName, dockerfilePath, r, duration)
+		_, policySpan = startSpan(ctx, "image.policy")
+		verdict.Merge(evaluateImagePolicy(jobUuid, []ImagePolicyTarget{{Image: imageName, CheckRuntime: true}}))
+		policySpan.SetAttributes(attribute.Bool("image.allowed", verdict.Allowed))
+		policySpan.End()
+		if err = saveImagePolicyVerdict(verdict); err != nil {
+			logs.FromContext(ctx).Errorf("Failed save image policy verdict, job: %s, error: %+v", jobUuid, err)
+		}
+		if !verdict.Allowed {
+			removeDeniedImage(imageName)
+			buildFailures.WithLabelValues(buildFailureImagePolicy).Inc()
+			logs.FromContext(ctx).Errorf("Job %s rejected by image policy: %+v", jobUuid, verdict.Images)
+			return ""
+		}
+		if servedHost, deployErr = dockerfileToK8s(ctx, jobUuid, hostName, creator, spaceName, imageName, dockerfilePath, jobType, schedule, r, duration); deployErr != nil {
+			logs.FromContext(ctx).Errorf("Failed deploy job %s, error: %v", jobUuid, deployErr)
+			return ""
+		}
+	}
+	var resultURI string
+	if servedHost != "" {
+		resultURI = "https://" + servedHost
 	}
-	return hostName
+	openJobReceipt(jobUuid, creator, spaceName, hardware, resultURI)
+	return servedHost
 }
 
 type DeploymentReq struct {
@@ -302,25 +607,76 @@ type DeploymentReq struct {
 	Res           common.Resource
 }
 
-func dockerfileToK8s(jobUuid, hostName, creatorWallet, spaceName, imageName, dockerfilePath string, res common.Resource, duration int) {
+// dockerfileToK8s deploys the image built from the Dockerfile of a space, it returns the host the job is
+// served on, empty when the Dockerfile exposes no port or the job is not a service.
+func dockerfileToK8s(ctx context.Context, jobUuid, hostName, creatorWallet, spaceName, imageName, dockerfilePath, jobType, schedule string, res common.Resource, duration int) (servedHost string, err error) {
+	ctx, span := startSpan(ctx, "kubernetes.deploy")
+	defer func() { endSpan(span, err) }()
+
+	if jobType == "" {
+		jobType = constants.JobTypeService
+	}
+	var containerPort int64
 	exposedPort, err := docker.ExtractExposedPort(dockerfilePath)
-	if err != nil {
-		logs.GetLogger().Infof("Failed to extract exposed port: %v", err)
-		return
+	if err != nil && err != docker.ErrNoExposedPort {
+		return "", fmt.Errorf("failed to extract exposed port: %w", err)
 	}
-	containerPort, err := strconv.ParseInt(exposedPort, 10, 64)
-	if err != nil {
-		logs.GetLogger().Errorf("Failed to convert exposed port: %v", err)
-		return
+	if err == nil {
+		if containerPort, err = strconv.ParseInt(exposedPort, 10, 64); err != nil {
+			return "", fmt.Errorf("failed to convert exposed port: %w", err)
+		}
 	}
 
 	// first delete old resource
 	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + creatorWallet
-	deleteJob(k8sNameSpace, spaceName)
+	deleteJob(ctx, k8sNameSpace, spaceName, TerminationRedeployed)
+	// the replaced job is gone, a failed deployment must not count it again
+	dropReplacedWalletJobs(jobUuid)
 
-	if err := deployNamespace(creatorWallet); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if err := deployNamespace(ctx, creatorWallet); err != nil {
+		return "", err
+	}
+	imagePullSecrets, err := deployImagePullSecret(ctx, k8sNameSpace)
+	if err != nil {
+		return "", err
+	}
+
+	baseImages, err := docker.ExtractBaseImages(dockerfilePath)
+	if err != nil {
+		logs.FromContext(ctx).Warnf("Failed to extract base images: %v", err)
+	}
+	recordBaseImages(baseImages)
+
+	gpuReq, err := hardwareGpuRequest(res)
+	if err != nil {
+		return "", err
+	}
+	affinity, err := gpuAffinity(gpuReq)
+	if err != nil {
+		return "", err
+	}
+	limits := coreV1.ResourceList{}
+	if gpuReq != nil {
+		limits[gpuReq.resource] = *resource.NewQuantity(gpuReq.count, resource.DecimalSI)
+	}
+
+	if jobType != constants.JobTypeService {
+		spec := coreV1.PodSpec{
+			Affinity:         mergeAffinity(affinity, imageLocalityAffinity(baseImages)),
+			ImagePullSecrets: imagePullSecrets,
+		}
+		workload := coreV1.Container{
+			Name:            constants.K8S_CONTAINER_NAME_PREFIX + spaceName,
+			Image:           imageName,
+			ImagePullPolicy: coreV1.PullIfNotPresent,
+			Resources:       coreV1.ResourceRequirements{Limits: limits},
+		}
+		opts := newBatchOptions(jobType, nil, 0, schedule, duration)
+		return "", deployBatchJob(ctx, jobUuid, creatorWallet, spaceName, jobType, spec, workload, opts, duration)
+	}
+	var ports []coreV1.ContainerPort
+	if containerPort > 0 {
+		ports = []coreV1.ContainerPort{{ContainerPort: int32(containerPort)}}
 	}
 
 	// create deployment
@@ -346,19 +702,15 @@ func dockerfileToK8s(jobUuid, hostName, creatorWallet, spaceName, imageName, doc
 				},
 
 				Spec: coreV1.PodSpec{
-					//NodeSelector: generateLabel(""),
+					Affinity:         mergeAffinity(affinity, imageLocalityAffinity(baseImages)),
+					ImagePullSecrets: imagePullSecrets,
 					Containers: []coreV1.Container{{
 						Name:            constants.K8S_CONTAINER_NAME_PREFIX + spaceName,
 						Image:           imageName,
 						ImagePullPolicy: coreV1.PullIfNotPresent,
-						Ports: []coreV1.ContainerPort{{
-							ContainerPort: int32(containerPort),
-						}},
+						Ports:           ports,
 						Resources: coreV1.ResourceRequirements{
-							Limits: coreV1.ResourceList{
-								//coreV1.ResourceCPU:                    *resource.NewQuantity(deploy.Res.Cpu.Quantity, resource.DecimalSI),
-								//coreV1.ResourceMemory:                 resource.MustParse(deploy.Res.Memory.Description),
-							},
+							Limits:   limits,
 							Requests: coreV1.ResourceList{
 								//coreV1.ResourceCPU:    *resource.NewQuantity(deploy.Res.Cpu.Quantity, resource.DecimalSI),
 								//coreV1.ResourceMemory: resource.MustParse(deploy.Res.Memory.Description),
@@ -368,39 +720,59 @@ func dockerfileToK8s(jobUuid, hostName, creatorWallet, spaceName, imageName, doc
 				},
 			},
 		}}
-	createDeployment, err := k8sService.CreateDeployment(context.TODO(), k8sNameSpace, deployment)
+	applyPodSecurity(&deployment.Spec.Template.Spec)
+	if err = syncTenantQuota(k8sNameSpace, &deployment.Spec.Template.Spec); err != nil {
+		return "", err
+	}
+	createDeployment, err := k8sService.CreateDeployment(ctx, k8sNameSpace, deployment)
 	if err != nil {
-		logs.GetLogger().Error(err)
-		return
+		return "", err
 	}
-	logs.GetLogger().Infof("Created deployment: %s", createDeployment.GetObjectMeta().GetName())
+	logs.FromContext(ctx).Infof("Created deployment: %s", createDeployment.GetObjectMeta().GetName())
+	retainImage(k8sNameSpace, createDeployment.GetName(), imageName)
 
-	if err := deployK8sResource(k8sNameSpace, spaceName, hostName, containerPort); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if containerPort > 0 && hostName != "" {
+		if err := deployK8sResource(ctx, k8sNameSpace, spaceName, hostName, containerPort); err != nil {
+			return "", err
+		}
+		servedHost = hostName
 	}
 
-	watchContainerRunningTime(jobUuid, k8sNameSpace, spaceName, int64(duration))
-	return
+	watchContainerRunningTime(ctx, jobUuid, k8sNameSpace, spaceName, int64(duration))
+	return servedHost, nil
 }
 
-func yamlToK8s(jobUuid, creatorWallet, spaceName, yamlPath, hostName string, duration int) {
-	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + creatorWallet
-	deleteJob(k8sNameSpace, spaceName)
+// yamlToK8s deploys the services of the deploy.yaml of a space, each as the type it resolves to. It returns
+// the host the job is served on, empty when no service exposes a port.
+func yamlToK8s(ctx context.Context, jobUuid, creatorWallet, spaceName, yamlPath, hostName, jobType, schedule string, containerResources []yaml.ContainerResource, duration int) (servedHost string, err error) {
+	ctx, span := startSpan(ctx, "kubernetes.deploy")
+	defer func() { endSpan(span, err) }()
 
-	containerResources, err := yaml.HandlerYaml(yamlPath)
-	if err != nil {
-		logs.GetLogger().Error(err)
-		return
+	resourceTypes := make([]string, len(containerResources))
+	for i, resource := range containerResources {
+		if resourceTypes[i], err = ResolveJobType(jobType, resource); err != nil {
+			return "", err
+		}
+		if resourceTypes[i] != constants.JobTypeService && len(resource.Depends) > 0 {
+			return "", fmt.Errorf("service %s is a %s job, depends-on is only supported by services", resource.Name, resourceTypes[i])
+		}
 	}
 
-	if err := deployNamespace(creatorWallet); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + creatorWallet
+	deleteJob(ctx, k8sNameSpace, spaceName, TerminationRedeployed)
+	// the replaced job is gone, a failed deployment must not count it again
+	dropReplacedWalletJobs(jobUuid)
+
+	if err := deployNamespace(ctx, creatorWallet); err != nil {
+		return "", err
+	}
+	imagePullSecrets, err := deployImagePullSecret(ctx, k8sNameSpace)
+	if err != nil {
+		return "", err
 	}
 
 	k8sService := NewK8sService()
-	for _, resource := range containerResources {
+	for n, resource := range containerResources {
 		for i, envVar := range resource.Env {
 			if strings.Contains(envVar.Name, "NEXTAUTH_URL") {
 				resource.Env[i].Value = "https://" + hostName
@@ -412,10 +784,9 @@ func yamlToK8s(jobUuid, creatorWallet, spaceName, yamlPath, hostName string, dur
 		var volumes []coreV1.Volume
 		if resource.VolumeMounts.Path != "" {
 			fileNameWithoutExt := filepath.Base(resource.VolumeMounts.Name[:len(resource.VolumeMounts.Name)-len(filepath.Ext(resource.VolumeMounts.Name))])
-			configMap, err := k8sService.CreateConfigMap(context.TODO(), k8sNameSpace, spaceName, filepath.Dir(yamlPath), resource.VolumeMounts.Name)
+			configMap, err := k8sService.CreateConfigMap(ctx, k8sNameSpace, spaceName, filepath.Dir(yamlPath), resource.VolumeMounts.Name)
 			if err != nil {
-				logs.GetLogger().Error(err)
-				return
+				return "", err
 			}
 			configName := configMap.GetName()
 			volumes = []coreV1.Volume{
@@ -438,6 +809,48 @@ func yamlToK8s(jobUuid, creatorWallet, spaceName, yamlPath, hostName string, dur
 			}
 		}
 
+		jobImages := []string{resource.ImageName}
+		for _, depend := range resource.Depends {
+			jobImages = append(jobImages, depend.ImageName)
+		}
+		recordBaseImages(jobImages)
+
+		affinity, err := gpuAffinity(yamlGpuRequest(resource))
+		if err != nil {
+			return "", err
+		}
+
+		if resourceType := resourceTypes[n]; resourceType != constants.JobTypeService {
+			spec := coreV1.PodSpec{
+				Affinity:         mergeAffinity(affinity, imageLocalityAffinity(jobImages)),
+				ImagePullSecrets: imagePullSecrets,
+				Volumes:          volumes,
+			}
+			workload := coreV1.Container{
+				Name:            constants.K8S_CONTAINER_NAME_PREFIX + spaceName + "-" + resource.Name,
+				Image:           resource.ImageName,
+				Command:         resource.Command,
+				Args:            resource.Args,
+				Env:             resource.Env,
+				ImagePullPolicy: coreV1.PullIfNotPresent,
+				Resources: coreV1.ResourceRequirements{
+					Limits:   resource.ResourceLimit,
+					Requests: resource.ResourceLimit,
+				},
+				VolumeMounts: volumeMount,
+			}
+			resourceSchedule := resource.Schedule
+			if resourceSchedule == "" {
+				resourceSchedule = schedule
+			}
+			opts := newBatchOptions(resourceType, resource.Outputs, resource.Retries, resourceSchedule, duration)
+			opts.Service = resource.Name
+			if err = deployBatchJob(ctx, jobUuid, creatorWallet, spaceName, resourceType, spec, workload, opts, duration); err != nil {
+				return "", err
+			}
+			continue
+		}
+
 		var containers []coreV1.Container
 		for _, depend := range resource.Depends {
 			var handler = new(coreV1.ExecAction)
@@ -499,34 +912,42 @@ func yamlToK8s(jobUuid, creatorWallet, spaceName, yamlPath, hostName string, dur
 						Namespace: k8sNameSpace,
 					},
 					Spec: coreV1.PodSpec{
-						Containers: containers,
-						Volumes:    volumes,
+						Affinity:         mergeAffinity(affinity, imageLocalityAffinity(jobImages)),
+						ImagePullSecrets: imagePullSecrets,
+						Containers:       containers,
+						Volumes:          volumes,
 					},
 				},
 			}}
 
-		createDeployment, err := k8sService.CreateDeployment(context.TODO(), k8sNameSpace, deployment)
+		applyPodSecurity(&deployment.Spec.Template.Spec)
+		if err = syncTenantQuota(k8sNameSpace, &deployment.Spec.Template.Spec); err != nil {
+			return "", err
+		}
+		createDeployment, err := k8sService.CreateDeployment(ctx, k8sNameSpace, deployment)
 		if err != nil {
-			logs.GetLogger().Error(err)
-			return
+			return "", err
 		}
-		logs.GetLogger().Infof("Created deployment: %s", createDeployment.GetObjectMeta().GetName())
+		logs.FromContext(ctx).Infof("Created deployment: %s", createDeployment.GetObjectMeta().GetName())
 
-		if err := deployK8sResource(k8sNameSpace, spaceName, hostName, int64(resource.Ports[0].ContainerPort)); err != nil {
-			logs.GetLogger().Error(err)
-			return
+		if len(resource.Ports) > 0 && hostName != "" {
+			if err := deployK8sResource(ctx, k8sNameSpace, spaceName, hostName, int64(resource.Ports[0].ContainerPort)); err != nil {
+				return "", err
+			}
+			servedHost = hostName
 		}
 
 		// watch running time and release resources when expired
-		watchContainerRunningTime(jobUuid, k8sNameSpace, spaceName, int64(duration))
+		watchContainerRunningTime(ctx, jobUuid, k8sNameSpace, spaceName, int64(duration))
 	}
+	return servedHost, nil
 }
 
-func deployNamespace(creatorWallet string) error {
+func deployNamespace(ctx context.Context, creatorWallet string) error {
 	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + creatorWallet
 	k8sService := NewK8sService()
 	// create namespace
-	if _, err := k8sService.GetNameSpace(context.TODO(), k8sNameSpace, metaV1.GetOptions{}); err != nil {
+	if _, err := k8sService.GetNameSpace(ctx, k8sNameSpace, metaV1.GetOptions{}); err != nil {
 		if errors.IsNotFound(err) {
 			namespace := &coreV1.Namespace{
 				ObjectMeta: metaV1.ObjectMeta{
@@ -536,89 +957,116 @@ func deployNamespace(creatorWallet string) error {
 					},
 				},
 			}
-			createdNamespace, err := k8sService.CreateNameSpace(context.TODO(), namespace, metaV1.CreateOptions{})
+			createdNamespace, err := k8sService.CreateNameSpace(ctx, namespace, metaV1.CreateOptions{})
 			if err != nil {
 				return fmt.Errorf("failed create namespace, error: %w", err)
 			}
-			logs.GetLogger().Infof("create namespace successfully, namespace: %s", createdNamespace.Name)
-
-			//networkPolicy, err := k8sService.CreateNetworkPolicy(context.TODO(), k8sNameSpace)
-			//if err != nil {
-			//	return fmt.Errorf("failed create networkPolicy, error: %w", err)
-			//}
-			//logs.GetLogger().Infof("create networkPolicy successfully, networkPolicyName: %s", networkPolicy.Name)
+			logs.FromContext(ctx).Infof("create namespace successfully, namespace: %s", createdNamespace.Name)
 		} else {
 			return err
 		}
 	}
-	return nil
+	return provisionTenantNamespace(k8sNameSpace)
+}
+
+// deployImagePullSecret stores the credentials of the configured registries in the namespace
+// and returns the references to put into the pod spec.
+func deployImagePullSecret(ctx context.Context, k8sNameSpace string) ([]coreV1.LocalObjectReference, error) {
+	dockerConfigJson, err := docker.DockerConfigJson(docker.Registries())
+	if err != nil {
+		return nil, fmt.Errorf("failed build registry credentials, error: %w", err)
+	}
+	if dockerConfigJson == nil {
+		return nil, nil
+	}
+
+	k8sService := NewK8sService()
+	if _, err = k8sService.ApplyImagePullSecret(ctx, k8sNameSpace, dockerConfigJson); err != nil {
+		return nil, fmt.Errorf("failed create image pull secret, error: %w", err)
+	}
+	return []coreV1.LocalObjectReference{{Name: constants.K8S_IMAGE_PULL_SECRET_NAME}}, nil
 }
 
-func deployK8sResource(k8sNameSpace, spaceName, hostName string, containerPort int64) error {
+func deployK8sResource(ctx context.Context, k8sNameSpace, spaceName, hostName string, containerPort int64) error {
 	k8sService := NewK8sService()
 
 	// create service
-	createService, err := k8sService.CreateService(context.TODO(), k8sNameSpace, spaceName, int32(containerPort))
+	createService, err := k8sService.CreateService(ctx, k8sNameSpace, spaceName, int32(containerPort))
 	if err != nil {
 		return fmt.Errorf("failed creata service, error: %w", err)
 	}
-	logs.GetLogger().Infof("Created service successfully: %s", createService.GetObjectMeta().GetName())
+	logs.FromContext(ctx).Infof("Created service successfully: %s", createService.GetObjectMeta().GetName())
 
 	// create ingress
-	createIngress, err := k8sService.CreateIngress(context.TODO(), k8sNameSpace, spaceName, hostName, int32(containerPort))
+	createIngress, err := k8sService.CreateIngress(ctx, k8sNameSpace, spaceName, hostName, int32(containerPort))
 	if err != nil {
 		return fmt.Errorf("failed creata ingress, error: %w", err)
 	}
-	logs.GetLogger().Infof("Created Ingress successfully: %s", createIngress.GetObjectMeta().GetName())
+	logs.FromContext(ctx).Infof("Created Ingress successfully: %s", createIngress.GetObjectMeta().GetName())
 	return nil
 }
 
-func deleteJob(namespace, spaceName string) {
+// deleteJob deletes the resources of the job running in a space, reason is recorded in its receipt.
+func deleteJob(ctx context.Context, namespace, spaceName, reason string) {
+	ctx, span := startSpan(ctx, "kubernetes.delete", attribute.String("k8s.namespace", namespace))
+	defer span.End()
+
 	deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceName
 	serviceName := constants.K8S_SERVICE_NAME_PREFIX + spaceName
 	ingressName := constants.K8S_INGRESS_NAME_PREFIX + spaceName
 
+	jobUuid := activeJobUuid(namespace, spaceName)
+	finishJobUsage(namespace, spaceName)
+	closeJobReceipt(ctx, namespace, spaceName, reason)
+	// a redeployed job keeps running, completed and failed runs are counted and reported with their own state
+	if reason == TerminationDeleted || reason == TerminationExpired {
+		jobsTotal.WithLabelValues(jobStateDeleted).Inc()
+		reportJobStatus(ctx, jobUuid, jobStateDeleted, nil)
+	}
+
 	k8sService := NewK8sService()
-	if err := k8sService.DeleteIngress(context.TODO(), namespace, ingressName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete ingress, ingressName: %s, error: %+v", deployName, err)
+	if err := k8sService.DeleteIngress(ctx, namespace, ingressName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete ingress, ingressName: %s, error: %+v", deployName, err)
 		return
 	}
-	logs.GetLogger().Infof("Deleted ingress %s finished", ingressName)
+	logs.FromContext(ctx).Infof("Deleted ingress %s finished", ingressName)
 
-	if err := k8sService.DeleteService(context.TODO(), namespace, serviceName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete service, serviceName: %s, error: %+v", serviceName, err)
+	if err := k8sService.DeleteService(ctx, namespace, serviceName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete service, serviceName: %s, error: %+v", serviceName, err)
 		return
 	}
-	logs.GetLogger().Infof("Deleted service %s finished", serviceName)
+	logs.FromContext(ctx).Infof("Deleted service %s finished", serviceName)
 
-	dockerService := docker.NewDockerService()
-	deployImageIds, err := k8sService.GetDeploymentImages(context.TODO(), namespace, deployName)
+	deployImageIds, err := k8sService.GetDeploymentImages(ctx, namespace, deployName)
 	if err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed get deploy imageIds, deployName: %s, error: %+v", deployName, err)
+		logs.FromContext(ctx).Errorf("Failed get deploy imageIds, deployName: %s, error: %+v", deployName, err)
 		return
 	}
-	for _, imageId := range deployImageIds {
-		err = dockerService.RemoveImage(imageId)
-		if err != nil {
-			logs.GetLogger().Errorf("Failed delete unused image, imageId: %s, error: %+v", imageId, err)
-			continue
-		}
+	releaseImages(namespace, deployName, deployImageIds)
+
+	if err := k8sService.DeleteSpaceCronJobs(ctx, namespace, spaceName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete cronJobs, spaceName: %s, error: %+v", spaceName, err)
+		return
+	}
+	if err := k8sService.DeleteSpaceJobs(ctx, namespace, spaceName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete jobs, spaceName: %s, error: %+v", spaceName, err)
+		return
 	}
 
-	if err := k8sService.DeleteDeployment(context.TODO(), namespace, deployName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete deployment, deployName: %s, error: %+v", deployName, err)
+	if err := k8sService.DeleteDeployment(ctx, namespace, deployName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete deployment, deployName: %s, error: %+v", deployName, err)
 		return
 	}
 	time.Sleep(6 * time.Second)
-	logs.GetLogger().Infof("Deleted deployment %s finished", deployName)
+	logs.FromContext(ctx).Infof("Deleted deployment %s finished", deployName)
 
-	if err := k8sService.DeleteDeployRs(context.TODO(), namespace, spaceName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete eplicationController, spaceName: %s, error: %+v", spaceName, err)
+	if err := k8sService.DeleteDeployRs(ctx, namespace, spaceName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete eplicationController, spaceName: %s, error: %+v", spaceName, err)
 		return
 	}
 
-	if err := k8sService.DeletePod(context.TODO(), namespace, spaceName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete pods, spaceName: %s, error: %+v", spaceName, err)
+	if err := k8sService.DeletePod(ctx, namespace, spaceName); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed delete pods, spaceName: %s, error: %+v", spaceName, err)
 		return
 	}
 
@@ -629,25 +1077,31 @@ func deleteJob(namespace, spaceName string) {
 		<-ticker.C
 		count++
 		if count >= 20 {
-			break
+			// terminating pods still count against the quota, it is shrunk by the next deployment
+			logs.FromContext(ctx).Warnf("Pods of space %s are still terminating, keep the resource quota of namespace %s", spaceName, namespace)
+			return
 		}
 		getPods, err := k8sService.GetPods(namespace, spaceName)
 		if err != nil && !errors.IsNotFound(err) {
-			logs.GetLogger().Errorf("Failed get pods form namespace, namepace: %s, error: %+v", namespace, err)
+			logs.FromContext(ctx).Errorf("Failed get pods form namespace, namepace: %s, error: %+v", namespace, err)
 			continue
 		}
 		if !getPods {
-			logs.GetLogger().Infof("Deleted all resource finised. spaceName %s", spaceName)
+			logs.FromContext(ctx).Infof("Deleted all resource finised. spaceName %s", spaceName)
 			break
 		}
 	}
+
+	if err := syncTenantQuota(namespace, nil); err != nil && !errors.IsNotFound(err) {
+		logs.FromContext(ctx).Errorf("Failed shrink resource quota, namespace: %s, error: %+v", namespace, err)
+	}
 }
 
-func watchContainerRunningTime(key, namespace, spaceName string, runTime int64) {
+func watchContainerRunningTime(ctx context.Context, key, namespace, spaceName string, runTime int64) {
 	conn := redisPool.Get()
 	_, err := conn.Do("SET", key, "wait-delete", "EX", runTime)
 	if err != nil {
-		logs.GetLogger().Errorf("Failed set redis key and expire time, key: %s, error: %+v", key, err)
+		logs.FromContext(ctx).Errorf("Failed set redis key and expire time, key: %s, error: %+v", key, err)
 		return
 	}
 
@@ -661,6 +1115,7 @@ func watchContainerRunningTime(key, namespace, spaceName string, runTime int64)
 		fullArgs = append(fullArgs, key, val)
 	}
 	conn.Do("HSET", fullArgs...)
+	startJobUsage(key, namespace, spaceName)
 
 	go func() {
 		psc := redis.PubSubConn{Conn: redisPool.Get()}
@@ -669,12 +1124,12 @@ func watchContainerRunningTime(key, namespace, spaceName string, runTime int64)
 			switch n := psc.Receive().(type) {
 			case redis.Message:
 				if n.Channel == "__keyevent@0__:expired" && string(n.Data) == key {
-					logs.GetLogger().Infof("The namespace: %s, spacename: %s, job has reached its runtime and will stop running.", namespace, spaceName)
-					deleteJob(namespace, spaceName)
+					logs.FromContext(ctx).Infof("The namespace: %s, spacename: %s, job has reached its runtime and will stop running.", namespace, spaceName)
+					deleteJob(ctx, namespace, spaceName, TerminationExpired)
 					redisPool.Get().Do("DEL", constants.REDIS_FULL_PREFIX+key)
 				}
 			case redis.Subscription:
-				logs.GetLogger().Infof("Subscribe %s", n.Channel)
+				logs.FromContext(ctx).Infof("Subscribe %s", n.Channel)
 			case error:
 				return
 			}
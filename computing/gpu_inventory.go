@@ -0,0 +1,247 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	GpuSourceFeatureDiscovery = "gpu-feature-discovery"
+	GpuSourceCollector        = "collector"
+
+	Nvidia_Driver_Major  string = "nvidia.com/cuda.driver.major"
+	Nvidia_Driver_Minor  string = "nvidia.com/cuda.driver.minor"
+	Nvidia_Driver_Rev    string = "nvidia.com/cuda.driver.rev"
+	Nvidia_Runtime_Major string = "nvidia.com/cuda.runtime.major"
+	Nvidia_Runtime_Minor string = "nvidia.com/cuda.runtime.minor"
+
+	defaultCollectorNamespace = coreV1.NamespaceDefault
+	defaultCollectorSelector  = "app=hardware-collect"
+	defaultCollectorPort      = 8080
+	defaultCollectorPath      = "/gpu"
+	defaultGpuCacheTTL        = 60
+	defaultGpuStaleAfter      = 600
+	collectorRequestTimeout   = 5 * time.Second
+)
+
+type gpuInventoryEntry struct {
+	gpu       *models.Gpu
+	updatedAt time.Time
+	checkedAt time.Time
+}
+
+// gpuInventoryCache keeps the last GPU inventory of every node, a node whose refresh fails keeps
+// serving its previous inventory, flagged stale once it is older than StaleAfter.
+type gpuInventoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*gpuInventoryEntry
+}
+
+var gpuInventory = &gpuInventoryCache{entries: make(map[string]*gpuInventoryEntry)}
+
+func gpuInventoryConfig() conf.GpuInventory {
+	var c conf.GpuInventory
+	if cfg := conf.GetConfig(); cfg != nil {
+		c = cfg.GpuInventory
+	}
+	if c.CollectorNamespace == "" {
+		c.CollectorNamespace = defaultCollectorNamespace
+	}
+	if c.CollectorSelector == "" {
+		c.CollectorSelector = defaultCollectorSelector
+	}
+	if c.CollectorPort <= 0 {
+		c.CollectorPort = defaultCollectorPort
+	}
+	if c.CollectorPath == "" {
+		c.CollectorPath = defaultCollectorPath
+	}
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = defaultGpuCacheTTL
+	}
+	if c.StaleAfter <= 0 {
+		c.StaleAfter = defaultGpuStaleAfter
+	}
+	return c
+}
+
+// GpuInventory returns the GPUs of the nodes, read from the GPU Feature Discovery labels and falling back
+// to the collector pod of the node. Nodes without GPU information are missing from the result, errors
+// never fail the caller. The expired nodes are refreshed in parallel without holding the cache lock.
+func (s *K8sService) GpuInventory(ctx context.Context, nodes []coreV1.Node) map[string]models.Gpu {
+	c := gpuInventoryConfig()
+
+	var expired []*coreV1.Node
+	gpuInventory.mu.Lock()
+	for i, node := range nodes {
+		entry, ok := gpuInventory.entries[node.Name]
+		if !ok {
+			entry = new(gpuInventoryEntry)
+			gpuInventory.entries[node.Name] = entry
+		}
+		if time.Since(entry.checkedAt) >= time.Duration(c.CacheTTL)*time.Second {
+			// concurrent callers keep serving the previous inventory while this one refreshes it
+			entry.checkedAt = time.Now()
+			expired = append(expired, &nodes[i])
+		}
+	}
+	gpuInventory.mu.Unlock()
+
+	refreshed := make([]*models.Gpu, len(expired))
+	if len(expired) > 0 {
+		var collectors map[string]string
+		for _, node := range expired {
+			if _, ok := node.Labels[Nvidia_Gpu_Product]; !ok {
+				collectors = s.gpuCollectors(ctx, c)
+				break
+			}
+		}
+		var wg sync.WaitGroup
+		for i, node := range expired {
+			wg.Add(1)
+			go func(i int, node *coreV1.Node) {
+				defer wg.Done()
+				refreshed[i] = fetchNodeGpu(ctx, c, node, collectors)
+			}(i, node)
+		}
+		wg.Wait()
+	}
+
+	gpuInventory.mu.Lock()
+	defer gpuInventory.mu.Unlock()
+	for i, node := range expired {
+		if refreshed[i] != nil {
+			entry := gpuInventory.entries[node.Name]
+			entry.gpu, entry.updatedAt = refreshed[i], time.Now()
+		}
+	}
+	result := make(map[string]models.Gpu)
+	for _, node := range nodes {
+		entry := gpuInventory.entries[node.Name]
+		if entry.gpu == nil {
+			continue
+		}
+
+		gpu := *entry.gpu
+		gpu.UpdatedAt = entry.updatedAt.Unix()
+		gpu.Stale = time.Since(entry.updatedAt) > time.Duration(c.StaleAfter)*time.Second
+		result[node.Name] = gpu
+	}
+	return result
+}
+
+// fetchNodeGpu reads the inventory of a node, nil when the collector fails and the previous one is kept.
+func fetchNodeGpu(ctx context.Context, c conf.GpuInventory, node *coreV1.Node, collectors map[string]string) *models.Gpu {
+	if gpu, ok := featureDiscoveryGpu(node); ok {
+		return &gpu
+	}
+
+	podIP, ok := collectors[node.Name]
+	if !ok {
+		return nil
+	}
+	gpu, err := collectorGpu(ctx, c, podIP)
+	if err != nil {
+		logs.FromContext(ctx).Warnf("Failed get gpu info of node %s from collector, error: %+v", node.Name, err)
+		return nil
+	}
+	return &gpu
+}
+
+// featureDiscoveryGpu builds the GPU inventory of a node from the labels of NVIDIA GPU Feature Discovery.
+// The labels carry no memory usage, only the total memory of each GPU.
+func featureDiscoveryGpu(node *coreV1.Node) (models.Gpu, bool) {
+	product, ok := node.Labels[Nvidia_Gpu_Product]
+	if !ok {
+		return models.Gpu{}, false
+	}
+	count, err := strconv.Atoi(node.Labels[Nvidia_Gpu_Count])
+	if err != nil {
+		allocatable := node.Status.Allocatable[yaml.NvidiaGpuResource]
+		count = int(allocatable.Value())
+	}
+
+	gpu := models.Gpu{
+		AttachedGpus: count,
+		Source:       GpuSourceFeatureDiscovery,
+	}
+	if major, ok := node.Labels[Nvidia_Driver_Major]; ok {
+		gpu.DriverVersion = strings.Join([]string{major, node.Labels[Nvidia_Driver_Minor], node.Labels[Nvidia_Driver_Rev]}, ".")
+		gpu.DriverVersion = strings.TrimRight(gpu.DriverVersion, ".")
+	}
+	if major, ok := node.Labels[Nvidia_Runtime_Major]; ok {
+		gpu.CudaVersion = major + "." + node.Labels[Nvidia_Runtime_Minor]
+	}
+
+	var memory models.Common
+	if mib, ok := node.Labels[Nvidia_Gpu_Memory]; ok {
+		memory.Total = mib + " MiB"
+	}
+	for i := 0; i < count; i++ {
+		gpu.Details = append(gpu.Details, models.GpuDetail{
+			ProductName:   product,
+			FbMemoryUsage: memory,
+		})
+	}
+	return gpu, true
+}
+
+// gpuCollectors returns the IP of the running collector pod of each node.
+func (s *K8sService) gpuCollectors(ctx context.Context, c conf.GpuInventory) map[string]string {
+	collectors := make(map[string]string)
+	podList, err := s.k8sClient.CoreV1().Pods(c.CollectorNamespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: c.CollectorSelector,
+	})
+	if err != nil {
+		logs.FromContext(ctx).Warnf("Failed list gpu collectors, error: %+v", err)
+		return collectors
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == coreV1.PodRunning && pod.Status.PodIP != "" {
+			collectors[pod.Spec.NodeName] = pod.Status.PodIP
+		}
+	}
+	return collectors
+}
+
+// collectorGpu queries the HTTP endpoint of a collector pod, which answers {"gpu": {...}}.
+func collectorGpu(ctx context.Context, c conf.GpuInventory, podIP string) (models.Gpu, error) {
+	ctx, cancel := context.WithTimeout(ctx, collectorRequestTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d%s", podIP, c.CollectorPort, c.CollectorPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.Gpu{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return models.Gpu{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return models.Gpu{}, fmt.Errorf("collector %s returned status %d", url, resp.StatusCode)
+	}
+
+	var gpuInfo struct {
+		Gpu models.Gpu `json:"gpu"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&gpuInfo); err != nil {
+		return models.Gpu{}, fmt.Errorf("failed decode collector response: %w", err)
+	}
+	gpuInfo.Gpu.Source = GpuSourceCollector
+	return gpuInfo.Gpu, nil
+}
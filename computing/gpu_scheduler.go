@@ -0,0 +1,388 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	Amd_Gpu_Product string = "amd.com/gpu.product-name"
+	Amd_Gpu_Family  string = "amd.com/gpu.family"
+	Amd_Gpu_Vram    string = "amd.com/gpu.vram"
+
+	// reported framebuffer sizes are slightly below the marketed ones, e.g. 15360 MiB for a 16GB T4
+	gpuMemoryTolerance = 0.9
+)
+
+var (
+	gpuBrandTokens   = map[string]bool{"NVIDIA": true, "AMD": true, "TESLA": true, "GEFORCE": true, "RADEON": true, "INSTINCT": true, "SHARED": true}
+	gpuMemoryPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMGT]I?B?)?$`)
+	labelValueFilter = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// gpuDeviceCache holds the GPUs of the cluster between job submissions, the map is never modified once stored.
+var gpuDeviceCache struct {
+	mu        sync.Mutex
+	devices   map[string][]gpuDevice
+	checkedAt time.Time
+}
+
+// gpuRequest is the GPU a job asks for, an empty model accepts any GPU of the vendor.
+type gpuRequest struct {
+	resource  coreV1.ResourceName
+	model     string
+	memoryMiB int64
+	count     int64
+}
+
+func (r *gpuRequest) String() string {
+	s := fmt.Sprintf("%d x %s", r.count, r.model)
+	if r.memoryMiB > 0 {
+		s += fmt.Sprintf(" with %d MiB memory", r.memoryMiB)
+	}
+	return s
+}
+
+// gpuDevice is a GPU installed on a node.
+type gpuDevice struct {
+	resource  coreV1.ResourceName
+	product   string
+	memoryMiB int64
+}
+
+// HardwareGpuResource returns the resource the GPUs of a hardware profile are claimed with,
+// or an empty name when the profile has none.
+func HardwareGpuResource(res common.Resource) (coreV1.ResourceName, error) {
+	if res.Gpu.Quantity <= 0 {
+		return "", nil
+	}
+	return yaml.GpuSharingResourceName(res.Gpu.Description, res.Gpu.Sharing)
+}
+
+// hardwareGpuRequest returns the GPU of a hardware profile, e.g. "Nvidia A100 40GB", or nil when it has none.
+func hardwareGpuRequest(res common.Resource) (*gpuRequest, error) {
+	name, err := HardwareGpuResource(res)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	req := &gpuRequest{
+		resource: name,
+		model:    res.Gpu.Description,
+		count:    res.Gpu.Quantity,
+	}
+	if !isMigResource(name) {
+		_, req.memoryMiB = parseGpuModel(res.Gpu.Description)
+	}
+	return req, nil
+}
+
+// yamlGpuRequest returns the GPU of a deploy.yaml compute profile, or nil when it has none.
+func yamlGpuRequest(resource yaml.ContainerResource) *gpuRequest {
+	if resource.GpuModel == "" {
+		return nil
+	}
+	name, err := yaml.GpuSharingResourceName(resource.GpuModel, resource.GpuSharing)
+	if err != nil {
+		return nil
+	}
+	quantity, ok := resource.ResourceLimit[name]
+	if !ok {
+		return nil
+	}
+	req := &gpuRequest{
+		resource: name,
+		model:    resource.GpuModel,
+		count:    quantity.Value(),
+	}
+	if !isMigResource(name) {
+		_, req.memoryMiB = parseGpuModel(resource.GpuModel)
+		if resource.GpuMemory != "" {
+			req.memoryMiB = ParseGpuMemory(resource.GpuMemory)
+		}
+	}
+	return req
+}
+
+// isMigResource reports whether a resource is a MIG profile, whose memory is fixed by the profile.
+func isMigResource(name coreV1.ResourceName) bool {
+	return strings.HasPrefix(string(name), yaml.NvidiaMigResourcePrefix)
+}
+
+// parseGpuModel splits a GPU model into the tokens identifying it and the memory size it names.
+// Vendor and brand names are dropped, so "nvidia-a100", "A100" and "Nvidia A100 40GB" all yield A100,
+// as is the -SHARED suffix GPU Feature Discovery adds to time-sliced products.
+func parseGpuModel(model string) ([]string, int64) {
+	var tokens []string
+	var memoryMiB int64
+	for _, token := range strings.FieldsFunc(strings.ToUpper(model), func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.')
+	}) {
+		if gpuBrandTokens[token] {
+			continue
+		}
+		if strings.HasSuffix(token, "B") {
+			if size := ParseGpuMemory(token); size > 0 {
+				memoryMiB = size
+				continue
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, memoryMiB
+}
+
+// ParseGpuMemory converts sizes such as "40960 MiB", "40GB" or "40Gi" to MiB, unparsable sizes are 0.
+func ParseGpuMemory(size string) int64 {
+	match := gpuMemoryPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(size)))
+	if match == nil {
+		return 0
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.TrimSuffix(strings.TrimSuffix(match[2], "B"), "I") {
+	case "K":
+		value /= 1024
+	case "G":
+		value *= 1024
+	case "T":
+		value *= 1024 * 1024
+	}
+	return int64(value)
+}
+
+// MatchGpuModel reports whether a GPU product satisfies the requested model, every token of the
+// request has to appear in the product, so "A100" matches "NVIDIA A100-SXM4-40GB" but "A10" does not.
+func MatchGpuModel(requested, product string) bool {
+	requestedTokens, _ := parseGpuModel(requested)
+	productTokens, _ := parseGpuModel(product)
+	have := make(map[string]bool, len(productTokens))
+	for _, token := range productTokens {
+		have[token] = true
+	}
+	for _, token := range requestedTokens {
+		if !have[token] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *gpuRequest) matches(device gpuDevice) bool {
+	if device.resource != r.resource || !MatchGpuModel(r.model, device.product) {
+		return false
+	}
+	if r.memoryMiB > 0 && device.memoryMiB > 0 {
+		return float64(device.memoryMiB) >= float64(r.memoryMiB)*gpuMemoryTolerance
+	}
+	return true
+}
+
+// nodeGpuDevices lists the GPUs of every node. NVIDIA GPUs come from the GPU inventory,
+// AMD GPUs come from the AMD device plugin labeller.
+func nodeGpuDevices(ctx context.Context, nodes []coreV1.Node) map[string][]gpuDevice {
+	gpuInfoMap := NewK8sService().GpuInventory(ctx, nodes)
+
+	devices := make(map[string][]gpuDevice)
+	for _, node := range nodes {
+		for _, detail := range gpuInfoMap[node.Name].Details {
+			devices[node.Name] = append(devices[node.Name], gpuDevice{
+				resource:  yaml.NvidiaGpuResource,
+				product:   detail.ProductName,
+				memoryMiB: ParseGpuMemory(detail.FbMemoryUsage.Total),
+			})
+		}
+
+		whole := devices[node.Name]
+		shared := sharedGpuDevices(node, whole)
+		// GPUs split into MIG devices or time-sliced replicas are not schedulable as whole GPUs
+		allocatable := node.Status.Allocatable[yaml.NvidiaGpuResource]
+		if int64(len(whole)) > allocatable.Value() {
+			whole = whole[:allocatable.Value()]
+		}
+		devices[node.Name] = append(whole, shared...)
+
+		product := node.Labels[Amd_Gpu_Product]
+		if product == "" {
+			product = node.Labels[Amd_Gpu_Family]
+		}
+		if product != "" {
+			devices[node.Name] = append(devices[node.Name], labelledGpuDevices(node, yaml.AmdGpuResource, product, node.Labels[Amd_Gpu_Vram])...)
+		}
+	}
+	return devices
+}
+
+func labelledGpuDevices(node coreV1.Node, resourceName coreV1.ResourceName, product, memory string) []gpuDevice {
+	allocatable := node.Status.Allocatable[resourceName]
+	var devices []gpuDevice
+	for i := int64(0); i < allocatable.Value(); i++ {
+		devices = append(devices, gpuDevice{
+			resource:  resourceName,
+			product:   product,
+			memoryMiB: ParseGpuMemory(memory),
+		})
+	}
+	return devices
+}
+
+// clusterGpuDevices returns the GPUs of every node, cached for the TTL of the GPU inventory so that
+// job submissions do not list the nodes and query their collectors each time.
+func clusterGpuDevices(ctx context.Context) (map[string][]gpuDevice, error) {
+	ttl := time.Duration(gpuInventoryConfig().CacheTTL) * time.Second
+	gpuDeviceCache.mu.Lock()
+	devices, checkedAt := gpuDeviceCache.devices, gpuDeviceCache.checkedAt
+	gpuDeviceCache.mu.Unlock()
+	if devices != nil && time.Since(checkedAt) < ttl {
+		return devices, nil
+	}
+
+	nodes, err := NewK8sService().GetNodeList()
+	if err != nil {
+		return nil, fmt.Errorf("failed list nodes, error: %w", err)
+	}
+	devices = nodeGpuDevices(ctx, nodes)
+
+	gpuDeviceCache.mu.Lock()
+	gpuDeviceCache.devices, gpuDeviceCache.checkedAt = devices, time.Now()
+	gpuDeviceCache.mu.Unlock()
+	return devices, nil
+}
+
+// findGpuNodes returns the nodes holding enough GPUs of the requested model and memory.
+func findGpuNodes(ctx context.Context, req *gpuRequest) ([]string, error) {
+	nodeDevices, err := clusterGpuDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for nodeName, devices := range nodeDevices {
+		var count int64
+		for _, device := range devices {
+			if req.matches(device) {
+				count++
+			}
+		}
+		if count > 0 && count >= req.count {
+			matched = append(matched, nodeName)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no node has %s", req)
+	}
+	return matched, nil
+}
+
+// gpuAffinity pins a job to the nodes matching its GPU request, nil requests need no affinity.
+func gpuAffinity(req *gpuRequest) (*coreV1.Affinity, error) {
+	if req == nil {
+		return nil, nil
+	}
+	nodes, err := findGpuNodes(context.TODO(), req)
+	if err != nil {
+		return nil, err
+	}
+	return &coreV1.Affinity{
+		NodeAffinity: &coreV1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &coreV1.NodeSelector{
+				NodeSelectorTerms: []coreV1.NodeSelectorTerm{{
+					MatchFields: []coreV1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: coreV1.NodeSelectorOpIn,
+						Values:   nodes,
+					}},
+				}},
+			},
+		},
+	}, nil
+}
+
+// mergeAffinity adds the preferred node terms of other to the required terms of base.
+func mergeAffinity(base, other *coreV1.Affinity) *coreV1.Affinity {
+	if base == nil {
+		return other
+	}
+	if other == nil || other.NodeAffinity == nil {
+		return base
+	}
+	base.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+		base.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		other.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution...)
+	return base
+}
+
+// checkHardwareGpu rejects a hardware profile whose GPU no node of the cluster provides.
+func checkHardwareGpu(hardware string) error {
+	res, ok := common.HardwareResource[hardware]
+	if !ok {
+		return nil
+	}
+	req, err := hardwareGpuRequest(res)
+	if err != nil || req == nil {
+		return err
+	}
+	_, err = findGpuNodes(context.TODO(), req)
+	return err
+}
+
+// gpuLabelValue turns a product name into a valid label value, as GPU Feature Discovery does.
+func gpuLabelValue(product string) string {
+	value := labelValueFilter.ReplaceAllString(strings.TrimSpace(product), "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-._")
+}
+
+// labelGpuNodes sets nvidia.com/gpu.product on nodes that GPU Feature Discovery has not labelled,
+// and drops the product-name keys set to "true" by earlier versions.
+func labelGpuNodes() {
+	k8sService := NewK8sService()
+	nodes, err := k8sService.GetNodeList()
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+
+	for nodeName, devices := range nodeGpuDevices(context.TODO(), nodes) {
+		var node coreV1.Node
+		for _, n := range nodes {
+			if n.Name == nodeName {
+				node = n
+			}
+		}
+
+		set := make(map[string]string)
+		var remove []string
+		for _, device := range devices {
+			if device.resource != yaml.NvidiaGpuResource || device.product == "" {
+				continue
+			}
+			legacyKey := strings.ReplaceAll(device.product, " ", "-")
+			if node.Labels[legacyKey] == "true" {
+				remove = append(remove, legacyKey)
+			}
+			if _, ok := node.Labels[Nvidia_Gpu_Product]; !ok {
+				set[Nvidia_Gpu_Product] = gpuLabelValue(device.product)
+			}
+		}
+		if len(set) == 0 && len(remove) == 0 {
+			continue
+		}
+		if err = k8sService.UpdateNodeLabels(nodeName, set, remove); err != nil {
+			logs.GetLogger().Error(err)
+		}
+	}
+}
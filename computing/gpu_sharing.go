@@ -0,0 +1,108 @@
+package computing
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+// gpuShareResources returns the fractional GPU resources a node advertises, the MIG profiles exposed by
+// the mixed MIG strategy and the replicas exposed by time-slicing with renameByDefault.
+func gpuShareResources(node *coreV1.Node) []coreV1.ResourceName {
+	var names []coreV1.ResourceName
+	for name, quantity := range node.Status.Allocatable {
+		if quantity.IsZero() {
+			continue
+		}
+		if name == yaml.NvidiaSharedGpuResource || isMigResource(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// gpuShareDevice describes a fractional GPU from the GPU Feature Discovery labels, falling back to the
+// whole GPU of the node. MIG labels are nvidia.com/mig-<profile>.product and .memory.
+func gpuShareDevice(node *coreV1.Node, name coreV1.ResourceName, whole []gpuDevice) gpuDevice {
+	device := gpuDevice{resource: name}
+	if len(whole) > 0 {
+		device.product = whole[0].product
+		device.memoryMiB = whole[0].memoryMiB
+	}
+	if product, ok := node.Labels[Nvidia_Gpu_Product]; ok {
+		device.product = product
+	}
+	if memory, ok := node.Labels[Nvidia_Gpu_Memory]; ok {
+		device.memoryMiB = ParseGpuMemory(memory + "MiB")
+	}
+
+	if isMigResource(name) {
+		profile := strings.TrimPrefix(string(name), yaml.NvidiaMigResourcePrefix)
+		device.product += "-MIG-" + profile
+		device.memoryMiB = 0
+		if product, ok := node.Labels[string(name)+".product"]; ok {
+			device.product = product
+		}
+		if memory, ok := node.Labels[string(name)+".memory"]; ok {
+			device.memoryMiB = ParseGpuMemory(memory + "MiB")
+		}
+	}
+	return device
+}
+
+// sharedGpuDevices lists the fractional GPUs of a node, one device per allocatable unit.
+func sharedGpuDevices(node coreV1.Node, whole []gpuDevice) []gpuDevice {
+	var devices []gpuDevice
+	for _, name := range gpuShareResources(&node) {
+		device := gpuShareDevice(&node, name, whole)
+		allocatable := node.Status.Allocatable[name]
+		for i := int64(0); i < allocatable.Value(); i++ {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// NodeGpuShares reports the fractional GPUs of a node and how many of them the pods on it claim.
+func NodeGpuShares(node *coreV1.Node, pods []coreV1.Pod, details []models.GpuDetail) []models.GpuShare {
+	var whole []gpuDevice
+	for _, detail := range details {
+		whole = append(whole, gpuDevice{product: detail.ProductName, memoryMiB: ParseGpuMemory(detail.FbMemoryUsage.Total)})
+	}
+
+	shares := make([]models.GpuShare, 0)
+	for _, name := range gpuShareResources(node) {
+		device := gpuShareDevice(node, name, whole)
+		allocatable := node.Status.Allocatable[name]
+
+		var used int64
+		for _, pod := range pods {
+			for _, container := range pod.Spec.Containers {
+				if quantity, ok := container.Resources.Limits[name]; ok {
+					used += quantity.Value()
+				}
+			}
+		}
+
+		share := models.GpuShare{
+			Resource: string(name),
+			Product:  device.product,
+			Total:    allocatable.Value(),
+			Used:     used,
+			Free:     allocatable.Value() - used,
+		}
+		if device.memoryMiB > 0 {
+			share.Memory = strconv.FormatInt(device.memoryMiB, 10) + " MiB"
+		}
+		if share.Free < 0 {
+			share.Free = 0
+		}
+		shares = append(shares, share)
+	}
+	return shares
+}
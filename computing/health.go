@@ -0,0 +1,292 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/docker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ComponentRedis      = "redis"
+	ComponentKubernetes = "kubernetes"
+	ComponentDocker     = "docker"
+	ComponentStorage    = "storage"
+	ComponentLad        = "lad"
+
+	defaultHealthInterval = 30
+	defaultHealthTimeout  = 5
+)
+
+var componentUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "component_up",
+	Help:      "Whether a dependency of the provider passed its last health check.",
+}, []string{"component"})
+
+// ComponentHealth is the result of the last check of a dependency, LastError is kept after it recovers.
+type ComponentHealth struct {
+	Name        string `json:"name"`
+	Healthy     bool   `json:"healthy"`
+	Required    bool   `json:"required"`
+	LatencyMs   int64  `json:"latency_ms"`
+	CheckedAt   int64  `json:"checked_at"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+}
+
+type Readiness struct {
+	Ready      bool              `json:"ready"`
+	Components []ComponentHealth `json:"components"`
+	expected   int
+}
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+var healthChecks = []healthCheck{
+	{ComponentRedis, checkRedis},
+	{ComponentKubernetes, checkKubernetes},
+	{ComponentDocker, checkDocker},
+	{ComponentStorage, checkStorage},
+	{ComponentLad, checkLad},
+}
+
+var defaultOptionalComponents = []string{ComponentStorage}
+
+var health = struct {
+	mu         sync.RWMutex
+	components map[string]*ComponentHealth
+}{components: make(map[string]*ComponentHealth)}
+
+func healthConfig() conf.Health {
+	var c conf.Health
+	if cfg := conf.GetConfig(); cfg != nil {
+		c = cfg.Health
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultHealthInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultHealthTimeout
+	}
+	return c
+}
+
+// OptionalComponents returns the dependencies that do not gate readiness, the job storage when none are
+// configured: a job whose detail cannot be uploaded still runs and is reported with the URL of its service.
+func OptionalComponents(c conf.Health) map[string]bool {
+	names := c.Optional
+	if names == nil {
+		names = defaultOptionalComponents
+	}
+	optional := make(map[string]bool)
+	for _, name := range names {
+		optional[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return optional
+}
+
+func watchHealth() {
+	ticker := time.NewTicker(time.Duration(healthConfig().Interval) * time.Second)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.GetLogger().Errorf("catch panic error: %+v", err)
+			}
+		}()
+
+		runHealthChecks()
+		for range ticker.C {
+			runHealthChecks()
+		}
+	}()
+}
+
+// runHealthChecks checks all dependencies concurrently, each within the configured timeout.
+func runHealthChecks() {
+	c := healthConfig()
+	optional := OptionalComponents(c)
+
+	var wg sync.WaitGroup
+	for _, hc := range healthChecks {
+		wg.Add(1)
+		go func(hc healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+			defer cancel()
+
+			start := time.Now()
+			err := runHealthCheck(ctx, hc)
+			recordHealth(hc.name, !optional[hc.name], time.Since(start), err)
+		}(hc)
+	}
+	wg.Wait()
+}
+
+func runHealthCheck(ctx context.Context, hc healthCheck) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("check panicked: %v", r)
+		}
+	}()
+	return hc.check(ctx)
+}
+
+func recordHealth(name string, required bool, latency time.Duration, err error) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	component, ok := health.components[name]
+	if !ok {
+		component = &ComponentHealth{Name: name}
+		health.components[name] = component
+	}
+	wasHealthy := component.Healthy || !ok
+	component.Required = required
+	component.Healthy = err == nil
+	component.LatencyMs = latency.Milliseconds()
+	component.CheckedAt = time.Now().Unix()
+	if err != nil {
+		component.LastError = err.Error()
+		component.LastErrorAt = component.CheckedAt
+		if wasHealthy {
+			logs.GetLogger().Warnf("Health check of %s failed, error: %v", name, err)
+		}
+		componentUp.WithLabelValues(name).Set(0)
+	} else {
+		if !wasHealthy {
+			logs.GetLogger().Infof("Health check of %s recovered", name)
+		}
+		componentUp.WithLabelValues(name).Set(1)
+	}
+}
+
+// readiness returns the last health of every dependency.
+func readiness() Readiness {
+	health.mu.RLock()
+	defer health.mu.RUnlock()
+
+	var components []ComponentHealth
+	for _, component := range health.components {
+		components = append(components, *component)
+	}
+	return NewReadiness(components, len(healthChecks))
+}
+
+// NewReadiness builds the readiness from the last health of the dependencies, the provider is ready once
+// all expected dependencies were checked and every required one passed its last check.
+func NewReadiness(components []ComponentHealth, expected int) Readiness {
+	result := Readiness{Ready: len(components) >= expected, Components: components, expected: expected}
+	for _, component := range components {
+		if component.Required && !component.Healthy {
+			result.Ready = false
+		}
+	}
+	sort.Slice(result.Components, func(i, j int) bool {
+		return result.Components[i].Name < result.Components[j].Name
+	})
+	return result
+}
+
+// Err names the failing dependencies of a provider that is not ready.
+func (r Readiness) Err() error {
+	if r.Ready {
+		return nil
+	}
+	if len(r.Components) < r.expected {
+		return fmt.Errorf("provider is not ready: dependencies not checked yet")
+	}
+	var failing []string
+	for _, component := range r.Components {
+		if component.Required && !component.Healthy {
+			failing = append(failing, component.Name+": "+component.LastError)
+		}
+	}
+	return fmt.Errorf("provider is not ready: %s", strings.Join(failing, "; "))
+}
+
+// checkReady returns an error when the provider is draining or names the failing dependencies when it is not ready.
+func checkReady() error {
+	if isDraining() {
+		return fmt.Errorf("provider is draining")
+	}
+	return readiness().Err()
+}
+
+func checkRedis(ctx context.Context) error {
+	if redisPool == nil {
+		return fmt.Errorf("redis pool is not initialized")
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	return err
+}
+
+func checkKubernetes(ctx context.Context) error {
+	k8sService := NewK8sService()
+	if clientSet == nil {
+		return fmt.Errorf("kubernetes client is not initialized")
+	}
+	return k8sService.Ping(ctx)
+}
+
+func checkDocker(ctx context.Context) error {
+	return docker.NewDockerService().Ping(ctx)
+}
+
+func checkStorage(ctx context.Context) error {
+	storage, err := jobStorage()
+	if err != nil {
+		return err
+	}
+	return storage.Ping(ctx)
+}
+
+func checkLad(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, conf.GetConfig().LAD.ServerUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := LadHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("lad server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Healthz reports that the process is alive.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, common.CreateSuccessResponse("ok"))
+}
+
+// Readyz reports the health of the dependencies, with 503 when the provider cannot take jobs.
+func Readyz(c *gin.Context) {
+	result := readiness()
+	status := http.StatusOK
+	if !result.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, result)
+}
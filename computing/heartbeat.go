@@ -0,0 +1,269 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/lad"
+)
+
+const (
+	defaultHeartbeatInterval = 5
+	defaultLadRequestTimeout = 10
+	defaultHeartbeatBackoff  = 300
+	minHeartbeatBackoff      = time.Second
+	providerStatusCacheTTL   = 30 * time.Second
+)
+
+// HeartbeatOptions configures a HeartbeatClient, zero durations fall back to the defaults.
+type HeartbeatOptions struct {
+	Client     *lad.Client
+	NodeId     string
+	Interval   time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Status returns the status reported with each heartbeat, Active when nil.
+	Status func() string
+	// Register registers the provider again after the server lost it or could not be reached.
+	Register func(ctx context.Context) error
+}
+
+// HeartbeatClient reports the status of the provider to the LAD server. Failures are retried with
+// exponential backoff and jitter, and the provider registers again once, after the outage.
+type HeartbeatClient struct {
+	opts     HeartbeatOptions
+	failures int
+	register bool
+	outage   bool
+}
+
+func NewHeartbeatClient(opts HeartbeatOptions) *HeartbeatClient {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultHeartbeatInterval * time.Second
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = minHeartbeatBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = defaultHeartbeatBackoff * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = newLadClient()
+	}
+	if opts.Status == nil {
+		opts.Status = func() string { return constants.StatusActive }
+	}
+	return &HeartbeatClient{opts: opts}
+}
+
+// Run sends heartbeats until the context is done.
+func (h *HeartbeatClient) Run(ctx context.Context) {
+	for {
+		err := h.Beat(ctx)
+		wait := h.opts.Interval
+		if err != nil {
+			wait = h.backoff()
+			logs.GetLogger().Warnf("Heartbeat failed %d times, retrying in %s, error: %v", h.failures, wait.Round(time.Millisecond), err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Beat registers the provider when needed and sends one heartbeat.
+func (h *HeartbeatClient) Beat(ctx context.Context) error {
+	err := h.beat(ctx)
+	RecordHeartbeat(err)
+	if err != nil {
+		h.failures++
+		return err
+	}
+	if h.failures > 0 {
+		logs.GetLogger().Infof("Heartbeat recovered after %d failures", h.failures)
+	}
+	h.failures = 0
+	return nil
+}
+
+func (h *HeartbeatClient) beat(ctx context.Context) error {
+	if h.register && h.opts.Register != nil {
+		// a failed registration is not retried with every backoff attempt, the next refused heartbeat asks again
+		h.register = false
+		if err := h.opts.Register(ctx); err != nil {
+			return fmt.Errorf("failed register provider: %w", err)
+		}
+	}
+
+	err := h.opts.Client.Heartbeat(ctx, &lad.HeartbeatReq{NodeId: h.opts.NodeId, Status: h.opts.Status()})
+	switch {
+	case lad.IsNotFound(err):
+		h.register = true
+	case lad.IsRetryable(err):
+		h.outage = true
+	case err == nil && h.outage:
+		// the server may have lost the node while it could not be reached
+		h.outage = false
+		if h.opts.Register != nil {
+			if err := h.opts.Register(ctx); err != nil {
+				logs.GetLogger().Warnf("Failed register provider after the outage, error: %v", err)
+			}
+		}
+	}
+	return err
+}
+
+// backoff returns a random delay between half of MinBackoff and MinBackoff doubled for each consecutive
+// failure, capped at MaxBackoff, so providers recovering from the same outage do not retry in lockstep.
+func (h *HeartbeatClient) backoff() time.Duration {
+	ceiling := h.opts.MinBackoff
+	for i := 1; i < h.failures && ceiling < h.opts.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > h.opts.MaxBackoff {
+		ceiling = h.opts.MaxBackoff
+	}
+	return h.opts.MinBackoff/2 + time.Duration(rand.Int63n(int64(ceiling-h.opts.MinBackoff/2)+1))
+}
+
+var provider = struct {
+	mu        sync.Mutex
+	draining  bool
+	status    string
+	checkedAt time.Time
+}{}
+
+// SetDraining stops the provider from taking new jobs while the running ones finish.
+func SetDraining(draining bool) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.draining = draining
+	provider.checkedAt = time.Time{}
+}
+
+func isDraining() bool {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	return provider.draining
+}
+
+// ProviderStatus is the status reported to the LAD server: Draining when set by the operator, Degraded
+// while a required dependency fails, Full when no node fits the smallest hardware profile and Active otherwise.
+// The cluster is read without holding the lock, SetDraining and isDraining never wait for it.
+func ProviderStatus() string {
+	provider.mu.Lock()
+	draining, status, checkedAt := provider.draining, provider.status, provider.checkedAt
+	provider.mu.Unlock()
+
+	switch {
+	case draining:
+		return constants.StatusDraining
+	case !readiness().Ready:
+		return constants.StatusDegraded
+	}
+	if time.Since(checkedAt) < providerStatusCacheTTL {
+		return status
+	}
+	status = constants.StatusActive
+	if clusterFull() {
+		status = constants.StatusFull
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.status, provider.checkedAt = status, time.Now()
+	return status
+}
+
+// clusterFull reports whether no node has the free CPU and memory of the smallest hardware profile.
+func clusterFull() bool {
+	var minCpu, minMemory int64
+	for _, res := range common.HardwareResource {
+		if minCpu == 0 || res.Cpu.Quantity < minCpu {
+			minCpu = res.Cpu.Quantity
+		}
+		if minMemory == 0 || res.Memory.Quantity < minMemory {
+			minMemory = res.Memory.Quantity
+		}
+	}
+
+	nodes, err := nodeResources.Get(context.TODO())
+	if err != nil {
+		logs.GetLogger().Errorf("Failed k8s statistical sources, error: %+v", err)
+		return false
+	}
+	for _, node := range nodes {
+		if node.Cpu.FreeValue >= minCpu*1000 && node.Memory.FreeValue >= minMemory*bytesPerGiB {
+			return false
+		}
+	}
+	return true
+}
+
+type DrainReq struct {
+	Draining bool `json:"draining"`
+}
+
+// Drain sets or clears the draining state of the provider.
+func Drain(c *gin.Context) {
+	var drainReq DrainReq
+	if err := c.ShouldBindJSON(&drainReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	SetDraining(drainReq.Draining)
+	logs.GetLogger().Infof("Provider draining set to %v", drainReq.Draining)
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(ProviderStatus()))
+}
+
+func ladRequestTimeout() time.Duration {
+	if timeout := conf.GetConfig().LAD.RequestTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultLadRequestTimeout * time.Second
+}
+
+// newLadClient returns a client of the LAD server of the config.
+func newLadClient() *lad.Client {
+	c := conf.GetConfig().LAD
+	return lad.NewClient(lad.Options{
+		ServerUrl:     c.ServerUrl,
+		AccessToken:   c.AccessToken,
+		Timeout:       ladRequestTimeout(),
+		Retries:       c.Retries,
+		HttpClient:    LadHttpClient(),
+		Signer:        providerIdentity(),
+		JobStatusPath: c.JobStatusPath,
+	})
+}
+
+// StartHeartbeat sends the heartbeats of the node with the LAD settings of the config.
+func StartHeartbeat(ctx context.Context, nodeId string) {
+	c := conf.GetConfig().LAD
+	client := NewHeartbeatClient(HeartbeatOptions{
+		Client:     newLadClient(),
+		NodeId:     nodeId,
+		Interval:   time.Duration(c.HeartbeatInterval) * time.Second,
+		MaxBackoff: time.Duration(c.MaxBackoff) * time.Second,
+		Status:     ProviderStatus,
+		Register: func(ctx context.Context) error {
+			_, peerId, address := generateNodeID()
+			return registerProvider(ctx, nodeId, peerId, address)
+		},
+	})
+	go client.Run(ctx)
+}
@@ -0,0 +1,183 @@
+package computing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/wallet"
+)
+
+const (
+	defaultKeystorePath   = ".swan_node/keystore.json"
+	legacyPrivateKeyPath  = ".swan_node/private_key"
+	KeystorePassphraseEnv = "CP_KEYSTORE_PASSPHRASE"
+)
+
+var (
+	identity         *wallet.Identity
+	identityOnce     sync.Once
+	insecureKeystore atomic.Bool
+)
+
+// SetInsecureKeystore allows an empty keystore passphrase, the provider key is then only protected by
+// the permissions of the keystore file.
+func SetInsecureKeystore(insecure bool) {
+	insecureKeystore.Store(insecure)
+}
+
+func identityConfig() conf.Identity {
+	var c conf.Identity
+	if cfg := conf.GetConfig(); cfg != nil {
+		c = cfg.Identity
+	}
+	if c.Keystore == "" {
+		c.Keystore = defaultKeystorePath
+	}
+	return c
+}
+
+// keystorePassphrase reads the passphrase of the keystore from the environment, or else from the configured file.
+func keystorePassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv(KeystorePassphraseEnv); ok {
+		return passphrase, nil
+	}
+	c := identityConfig()
+	if c.PassphraseFile == "" {
+		return "", nil
+	}
+	passphrase, err := os.ReadFile(c.PassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("failed read keystore passphrase, error: %w", err)
+	}
+	return strings.TrimRight(string(passphrase), "\r\n"), nil
+}
+
+// identityPassphrase returns the keystore passphrase, which may only be empty when the keystore is insecure.
+func identityPassphrase() (string, error) {
+	passphrase, err := keystorePassphrase()
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		if !insecureKeystore.Load() {
+			return "", fmt.Errorf("the keystore passphrase is empty, set %s or Identity.PassphraseFile, or pass --insecure to keep the key unencrypted", KeystorePassphraseEnv)
+		}
+		logs.GetLogger().Warnf("The keystore passphrase is empty, the provider key is not encrypted")
+	}
+	return passphrase, nil
+}
+
+// providerIdentity returns the identity of the provider, it is created on first use and the key
+// of older versions, kept unencrypted in .swan_node/private_key, is moved into the keystore.
+func providerIdentity() *wallet.Identity {
+	identityOnce.Do(func() {
+		var err error
+		if identity, err = LoadIdentity(); err != nil {
+			logs.GetLogger().Fatalf("Failed load provider identity, error: %v", err)
+		}
+		logs.GetLogger().Infof("Provider wallet address: %s", identity.Address())
+	})
+	return identity
+}
+
+// LoadIdentity decrypts the provider identity from the configured keystore, creating the keystore when missing.
+func LoadIdentity() (*wallet.Identity, error) {
+	passphrase, err := identityPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	ks := wallet.NewKeystore(identityConfig().Keystore)
+	if ks.Exists() {
+		return ks.Load(passphrase)
+	}
+
+	var id *wallet.Identity
+	legacyKey, err := os.ReadFile(legacyPrivateKeyPath)
+	switch {
+	case err == nil:
+		if id, err = wallet.IdentityFromBytes(legacyKey); err != nil {
+			return nil, fmt.Errorf("failed read %s, error: %w", legacyPrivateKeyPath, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if id, err = wallet.NewIdentity(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err = ks.Save(id, passphrase); err != nil {
+		return nil, fmt.Errorf("failed save keystore %s, error: %w", ks.Path, err)
+	}
+	if legacyKey != nil && passphrase == "" {
+		logs.GetLogger().Infof("Copied the key of %s into the keystore %s, it is kept since the keystore is not encrypted", legacyPrivateKeyPath, ks.Path)
+	} else if legacyKey != nil {
+		if err = os.Remove(legacyPrivateKeyPath); err != nil {
+			logs.GetLogger().Warnf("Failed remove the unencrypted key %s, error: %v", legacyPrivateKeyPath, err)
+		}
+		logs.GetLogger().Infof("Moved the key of %s into the keystore %s", legacyPrivateKeyPath, ks.Path)
+	} else {
+		logs.GetLogger().Infof("Created keystore %s", ks.Path)
+	}
+	return id, nil
+}
+
+// ShowIdentity decrypts the provider identity without creating or migrating the keystore.
+func ShowIdentity() (*wallet.Identity, error) {
+	ks := wallet.NewKeystore(identityConfig().Keystore)
+	if !ks.Exists() {
+		return nil, fmt.Errorf("keystore %s does not exist, it is created on the first start of the provider", ks.Path)
+	}
+	passphrase, err := identityPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	return ks.Read(passphrase)
+}
+
+// ImportIdentity sets the provider identity to the key of keystore JSON, or of a hex encoded private key
+// when passphrase is empty, and saves it encrypted with the configured passphrase. An existing keystore
+// is only replaced with force, since the node id and wallet address of the provider change with it.
+func ImportIdentity(key []byte, passphrase string, force bool) (string, error) {
+	ks := wallet.NewKeystore(identityConfig().Keystore)
+	if ks.Exists() && !force {
+		return "", fmt.Errorf("keystore %s already exists", ks.Path)
+	}
+
+	var id *wallet.Identity
+	var err error
+	if passphrase == "" && !strings.HasPrefix(strings.TrimSpace(string(key)), "{") {
+		id, err = wallet.IdentityFromHex(string(key))
+	} else {
+		id, err = wallet.Import(key, passphrase)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed read key, error: %w", err)
+	}
+
+	ksPassphrase, err := identityPassphrase()
+	if err != nil {
+		return "", err
+	}
+	if err = ks.Save(id, ksPassphrase); err != nil {
+		return "", err
+	}
+	return id.Address(), nil
+}
+
+// signData signs data with the provider identity, it returns the address and the signature.
+func signData(data []byte) (string, string, error) {
+	id := providerIdentity()
+	signature, err := id.Sign(data)
+	if err != nil {
+		return "", "", err
+	}
+	return id.Address(), signature, nil
+}
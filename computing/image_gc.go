@@ -0,0 +1,377 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/docker"
+)
+
+const (
+	defaultImageGCInterval      = 30
+	defaultImageGCHighThreshold = 85
+	defaultImageGCLowThreshold  = 70
+	defaultImageGCMaxAge        = 72
+	imageGCRecordLimit          = 200
+	// imageGCGracePeriod keeps images released or built a moment ago, e.g. between their build and deployment
+	imageGCGracePeriod = 10 * 60
+)
+
+var imageGCLock sync.Mutex
+
+// RemovedImage records an image deleted by the image garbage collector.
+type RemovedImage struct {
+	Image     string  `json:"image"`
+	Size      int64   `json:"size"`
+	Reason    string  `json:"reason"`
+	DiskUsage float64 `json:"disk_usage"`
+	RemovedAt int64   `json:"removed_at"`
+}
+
+// ImageGCCandidate is an image built by the provider that no job references any more.
+type ImageGCCandidate struct {
+	Image      string
+	ReleasedAt int64
+}
+
+func imageGCConfig() conf.ImageGC {
+	c := conf.GetConfig().ImageGC
+	if c.Interval <= 0 {
+		c.Interval = defaultImageGCInterval
+	}
+	if c.HighThreshold <= 0 {
+		c.HighThreshold = defaultImageGCHighThreshold
+	}
+	if c.LowThreshold <= 0 || c.LowThreshold > c.HighThreshold {
+		c.LowThreshold = defaultImageGCLowThreshold
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = defaultImageGCMaxAge
+	}
+	return c
+}
+
+// RecordBuiltImage tracks an image as soon as the provider built it, so that it is garbage collected
+// even when no deployment ever uses it. It counts as released until a deployment retains it.
+func RecordBuiltImage(conn redis.Conn, imageName string) error {
+	imageName = docker.NormalizeImageName(imageName)
+	conn.Send("MULTI")
+	conn.Send("SADD", constants.REDIS_IMAGE_BUILT, imageName)
+	conn.Send("HSETNX", constants.REDIS_IMAGE_RELEASED, imageName, time.Now().Unix())
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// RetainImage records that a deployment uses an image built by the provider.
+func RetainImage(conn redis.Conn, namespace, deployName, imageName string) error {
+	imageName = docker.NormalizeImageName(imageName)
+	conn.Send("MULTI")
+	conn.Send("SADD", constants.REDIS_IMAGE_BUILT, imageName)
+	conn.Send("SADD", constants.REDIS_IMAGE_REF_PREFIX+imageName, namespace+"/"+deployName)
+	conn.Send("HDEL", constants.REDIS_IMAGE_RELEASED, imageName)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// ReleaseImage drops the reference of a deployment to an image and reports whether it was the last one.
+// Images the provider did not build are ignored.
+func ReleaseImage(conn redis.Conn, namespace, deployName, imageName string) (bool, error) {
+	normalized := docker.NormalizeImageName(imageName)
+	built, err := redis.Bool(conn.Do("SISMEMBER", constants.REDIS_IMAGE_BUILT, normalized))
+	if err != nil || !built {
+		return false, err
+	}
+
+	refKey := constants.REDIS_IMAGE_REF_PREFIX + normalized
+	if _, err = conn.Do("SREM", refKey, namespace+"/"+deployName); err != nil {
+		return false, err
+	}
+	refs, err := redis.Int(conn.Do("SCARD", refKey))
+	if err != nil || refs > 0 {
+		return false, err
+	}
+	_, err = conn.Do("HSETNX", constants.REDIS_IMAGE_RELEASED, normalized, time.Now().Unix())
+	return err == nil, err
+}
+
+// RecordPushedImage tracks an image the provider pushed to its registry, only such tags are deleted from it.
+func RecordPushedImage(conn redis.Conn, imageName string) error {
+	_, err := conn.Do("SADD", constants.REDIS_IMAGE_PUSHED, docker.NormalizeImageName(imageName))
+	return err
+}
+
+// PushedImage reports whether the provider pushed an image to its registry itself.
+func PushedImage(conn redis.Conn, imageName string) (bool, error) {
+	return redis.Bool(conn.Do("SISMEMBER", constants.REDIS_IMAGE_PUSHED, docker.NormalizeImageName(imageName)))
+}
+
+func recordBuiltImage(imageName string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	if err := RecordBuiltImage(conn, imageName); err != nil {
+		logs.GetLogger().Errorf("Failed record built image, image: %s, error: %+v", imageName, err)
+	}
+}
+
+func recordPushedImage(imageName string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	if err := RecordPushedImage(conn, imageName); err != nil {
+		logs.GetLogger().Errorf("Failed record pushed image, image: %s, error: %+v", imageName, err)
+	}
+}
+
+// deletePushedTag deletes the tag of an image from the push registry, when the provider pushed it there.
+// Images of the same repository the provider did not push, e.g. named by a deploy.yaml, are left alone.
+func deletePushedTag(conn redis.Conn, imageName string) {
+	registry := docker.PushRegistry()
+	if registry == nil || !registry.Owns(imageName) {
+		return
+	}
+	pushed, err := PushedImage(conn, imageName)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed check pushed image, image: %s, error: %+v", imageName, err)
+		return
+	}
+	if !pushed {
+		return
+	}
+	if err = registry.DeleteTag(imageName); err != nil {
+		logs.GetLogger().Errorf("Failed delete pushed image tag, image: %s, error: %+v", imageName, err)
+		return
+	}
+	conn.Do("SREM", constants.REDIS_IMAGE_PUSHED, docker.NormalizeImageName(imageName))
+}
+
+// retainImage records that a deployment uses an image built by the provider.
+func retainImage(namespace, deployName, imageName string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	if err := RetainImage(conn, namespace, deployName, imageName); err != nil {
+		logs.GetLogger().Errorf("Failed retain image, image: %s, error: %+v", imageName, err)
+	}
+}
+
+// releaseImages drops the references of a deployment. When the last reference of an image goes away
+// its tag is deleted from the push registry, the local copy is kept as a cache until the garbage
+// collector removes it.
+func releaseImages(namespace, deployName string, imageNames []string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	for _, imageName := range imageNames {
+		released, err := ReleaseImage(conn, namespace, deployName, imageName)
+		if err != nil {
+			logs.GetLogger().Errorf("Failed release image, image: %s, error: %+v", imageName, err)
+			continue
+		}
+		if released {
+			deletePushedTag(conn, imageName)
+		}
+	}
+}
+
+func watchImageGC() {
+	interval := time.Duration(imageGCConfig().Interval) * time.Minute
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.GetLogger().Errorf("catch panic error: %+v", err)
+			}
+		}()
+
+		for range ticker.C {
+			removed, err := runImageGC()
+			if err != nil {
+				logs.GetLogger().Errorf("Failed run image gc, error: %+v", err)
+				continue
+			}
+			if len(removed) > 0 {
+				logs.GetLogger().Infof("Image gc removed %d images", len(removed))
+			}
+		}
+	}()
+}
+
+// runImageGC removes released images built by the provider. Images that no running pod uses are
+// removed when they have been released for longer than MaxAge, or oldest first while the disk usage
+// of the docker root stays above HighThreshold until it drops below LowThreshold.
+func runImageGC() ([]RemovedImage, error) {
+	imageGCLock.Lock()
+	defer imageGCLock.Unlock()
+
+	gcConfig := imageGCConfig()
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	usedImages, err := NewK8sService().ListUsedImage(context.TODO(), "")
+	if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool)
+	for _, image := range usedImages {
+		inUse[docker.NormalizeImageName(image)] = true
+	}
+
+	now := time.Now().Unix()
+	candidates, err := ImageGCCandidates(conn, inUse, now)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerService := docker.NewDockerService()
+	diskUsage, err := dockerService.DiskUsage()
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get docker disk usage, error: %+v", err)
+	}
+	pressure := diskUsage >= float64(gcConfig.HighThreshold)
+	if pressure {
+		// only images built by the provider are removed, the other images and containers of the host are left alone
+		logs.GetLogger().Warnf("Docker disk usage %.2f%% is above %d%%, start removing cached images", diskUsage, gcConfig.HighThreshold)
+	}
+
+	maxAge := int64(gcConfig.MaxAge) * 3600
+	var removed []RemovedImage
+	for _, candidate := range candidates {
+		reason := ImageGCReason(candidate, now, maxAge, pressure)
+		if reason == "" {
+			continue
+		}
+
+		size, _ := dockerService.ImageSize(candidate.Image)
+		if err = dockerService.RemoveImage(candidate.Image); err != nil {
+			logs.GetLogger().Errorf("Failed delete unused image, image: %s, error: %+v", candidate.Image, err)
+			continue
+		}
+		forgetImage(conn, candidate.Image)
+
+		record := RemovedImage{
+			Image:     candidate.Image,
+			Size:      size,
+			Reason:    reason,
+			DiskUsage: diskUsage,
+			RemovedAt: time.Now().Unix(),
+		}
+		removed = append(removed, record)
+		if data, err := json.Marshal(record); err == nil {
+			conn.Do("LPUSH", constants.REDIS_IMAGE_REMOVED, data)
+		}
+		logs.GetLogger().Infof("Image gc removed image: %s, reason: %s", candidate.Image, reason)
+
+		if pressure {
+			if diskUsage, err = dockerService.DiskUsage(); err == nil && diskUsage < float64(gcConfig.LowThreshold) {
+				pressure = false
+			}
+		}
+	}
+	conn.Do("LTRIM", constants.REDIS_IMAGE_REMOVED, 0, imageGCRecordLimit-1)
+	return removed, nil
+}
+
+// ImageGCCandidates returns the built images without job references that no running pod uses, the
+// longest released first.
+func ImageGCCandidates(conn redis.Conn, inUse map[string]bool, now int64) ([]ImageGCCandidate, error) {
+	builtImages, err := redis.Strings(conn.Do("SMEMBERS", constants.REDIS_IMAGE_BUILT))
+	if err != nil {
+		return nil, err
+	}
+	released, err := redis.Int64Map(conn.Do("HGETALL", constants.REDIS_IMAGE_RELEASED))
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []ImageGCCandidate
+	for _, image := range builtImages {
+		refs, err := redis.Int(conn.Do("SCARD", constants.REDIS_IMAGE_REF_PREFIX+image))
+		if err != nil || refs > 0 {
+			continue
+		}
+		if inUse[image] {
+			logs.GetLogger().Warnf("Image %s has no job reference but is used by a running pod, skip it", image)
+			continue
+		}
+		releasedAt, ok := released[image]
+		if !ok {
+			releasedAt = now
+			conn.Do("HSET", constants.REDIS_IMAGE_RELEASED, image, releasedAt)
+		}
+		candidates = append(candidates, ImageGCCandidate{Image: image, ReleasedAt: releasedAt})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ReleasedAt < candidates[j].ReleasedAt
+	})
+	return candidates, nil
+}
+
+// ImageGCReason returns why a candidate is removed, empty when it is kept. Images released longer than
+// maxAge seconds ago expire, under disk pressure any image past the grace period is removed.
+func ImageGCReason(candidate ImageGCCandidate, now, maxAge int64, pressure bool) string {
+	age := now - candidate.ReleasedAt
+	switch {
+	case age < imageGCGracePeriod:
+		return ""
+	case age > maxAge:
+		return "expired"
+	case pressure:
+		return "disk_pressure"
+	default:
+		return ""
+	}
+}
+
+// forgetImage drops the records of a removed image.
+func forgetImage(conn redis.Conn, image string) {
+	conn.Do("SREM", constants.REDIS_IMAGE_BUILT, image)
+	conn.Do("HDEL", constants.REDIS_IMAGE_RELEASED, image)
+	conn.Do("DEL", constants.REDIS_IMAGE_REF_PREFIX+image)
+}
+
+// ImageGCRecords returns the images most recently removed by the image garbage collector.
+func ImageGCRecords(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > imageGCRecordLimit {
+		limit = imageGCRecordLimit
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	values, err := redis.ByteSlices(conn.Do("LRANGE", constants.REDIS_IMAGE_REMOVED, 0, limit-1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+
+	records := make([]RemovedImage, 0, len(values))
+	for _, value := range values {
+		var record RemovedImage
+		if err := json.Unmarshal(value, &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(records))
+}
+
+// RunImageGC triggers an image garbage collection and returns the removed images.
+func RunImageGC(c *gin.Context) {
+	removed, err := runImageGC()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+	if removed == nil {
+		removed = []RemovedImage{}
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(removed))
+}
@@ -0,0 +1,317 @@
+package computing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/docker"
+)
+
+const (
+	defaultScannerPath    = "trivy"
+	defaultScanSeverity   = "HIGH,CRITICAL"
+	defaultScanTimeout    = 10
+	imagePolicyRecordTTL  = 30 * 24 * 3600
+	imagePolicyModeOpen   = "fail-open"
+	imagePolicyModeClosed = "fail-closed"
+	lowPortBoundary       = 1024
+)
+
+// ImagePolicyVerdict is the result of the image policy stage recorded for a job.
+type ImagePolicyVerdict struct {
+	JobUuid   string       `json:"job_uuid"`
+	Allowed   bool         `json:"allowed"`
+	Mode      string       `json:"mode"`
+	Images    []ImageCheck `json:"images"`
+	CheckedAt int64        `json:"checked_at"`
+}
+
+// ImageCheck is the policy result of a single image.
+type ImageCheck struct {
+	Image           string         `json:"image"`
+	Allowed         bool           `json:"allowed"`
+	Violations      []string       `json:"violations,omitempty"`
+	Errors          []string       `json:"errors,omitempty"`
+	Vulnerabilities map[string]int `json:"vulnerabilities,omitempty"`
+}
+
+// ImagePolicyTarget is an image to check, registry lists apply to images the provider did not build.
+type ImagePolicyTarget struct {
+	Image         string
+	CheckRegistry bool
+	CheckRuntime  bool
+	Pull          bool
+}
+
+// ImageRuntime pulls and inspects the images the policy checks at runtime.
+type ImageRuntime interface {
+	PullImage(imageName string) error
+	InspectImage(imageName string) (string, []int, error)
+}
+
+func withImagePolicyDefaults(c conf.ImagePolicy) conf.ImagePolicy {
+	if c.ScannerPath == "" {
+		c.ScannerPath = defaultScannerPath
+	}
+	if c.ScanSeverity == "" {
+		c.ScanSeverity = defaultScanSeverity
+	}
+	if c.ScanTimeout <= 0 {
+		c.ScanTimeout = defaultScanTimeout
+	}
+	return c
+}
+
+// checkImagePolicy runs the image policy stage for the images of a job and records the verdict.
+func checkImagePolicy(jobUuid string, targets []ImagePolicyTarget) ImagePolicyVerdict {
+	verdict := evaluateImagePolicy(jobUuid, targets)
+	if err := saveImagePolicyVerdict(verdict); err != nil {
+		logs.GetLogger().Errorf("Failed save image policy verdict, job: %s, error: %+v", jobUuid, err)
+	}
+	return verdict
+}
+
+// evaluateImagePolicy checks the images of a job against the configured policy without recording the verdict.
+func evaluateImagePolicy(jobUuid string, targets []ImagePolicyTarget) ImagePolicyVerdict {
+	return EvaluateImagePolicy(conf.GetConfig().ImagePolicy, jobUuid, targets, docker.NewDockerService())
+}
+
+// EvaluateImagePolicy checks the images of a job against the policy c.
+// Errors while checking an image reject it only in fail-closed mode.
+func EvaluateImagePolicy(c conf.ImagePolicy, jobUuid string, targets []ImagePolicyTarget, runtime ImageRuntime) ImagePolicyVerdict {
+	c = withImagePolicyDefaults(c)
+	verdict := ImagePolicyVerdict{
+		JobUuid:   jobUuid,
+		Allowed:   true,
+		Mode:      imagePolicyModeOpen,
+		CheckedAt: time.Now().Unix(),
+	}
+	if c.FailClosed {
+		verdict.Mode = imagePolicyModeClosed
+	}
+	if !c.Enable {
+		return verdict
+	}
+
+	for _, target := range targets {
+		check := ImageCheck{Image: target.Image}
+
+		if target.CheckRegistry {
+			if violation := CheckImageRegistry(c, target.Image); violation != "" {
+				check.Violations = append(check.Violations, violation)
+			}
+		}
+
+		if target.CheckRuntime && len(check.Violations) == 0 {
+			if target.Pull {
+				if err := runtime.PullImage(target.Image); err != nil {
+					check.Errors = append(check.Errors, fmt.Sprintf("failed pull image: %v", err))
+				}
+			}
+			if len(check.Errors) == 0 {
+				violations, err := checkImageRuntime(c, runtime, target.Image)
+				check.Violations = append(check.Violations, violations...)
+				if err != nil {
+					check.Errors = append(check.Errors, err.Error())
+				}
+			}
+			if c.Scan && len(check.Errors) == 0 {
+				vulnerabilities, err := scanImage(c, target.Image)
+				if err != nil {
+					check.Errors = append(check.Errors, err.Error())
+				} else {
+					check.Vulnerabilities = vulnerabilities
+					var total int
+					for _, count := range vulnerabilities {
+						total += count
+					}
+					if total > c.MaxVulnerabilities {
+						check.Violations = append(check.Violations, fmt.Sprintf("%d vulnerabilities of severity %s exceed the limit %d", total, c.ScanSeverity, c.MaxVulnerabilities))
+					}
+				}
+			}
+		}
+
+		check.Allowed = len(check.Violations) == 0 && (len(check.Errors) == 0 || !c.FailClosed)
+		if !check.Allowed {
+			verdict.Allowed = false
+		}
+		verdict.Images = append(verdict.Images, check)
+	}
+	return verdict
+}
+
+// Merge adds the images checked in a later stage of the same job.
+func (v *ImagePolicyVerdict) Merge(other ImagePolicyVerdict) {
+	v.Allowed = v.Allowed && other.Allowed
+	v.Images = append(v.Images, other.Images...)
+	v.CheckedAt = other.CheckedAt
+}
+
+// removeDeniedImage deletes an image the provider built for a job the image policy rejected, from the
+// docker daemon and the push registry.
+func removeDeniedImage(imageName string) {
+	if err := docker.NewDockerService().RemoveImage(imageName); err != nil {
+		logs.GetLogger().Errorf("Failed delete denied image, image: %s, error: %+v", imageName, err)
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+	deletePushedTag(conn, imageName)
+	forgetImage(conn, docker.NormalizeImageName(imageName))
+}
+
+// CheckImageRegistry applies the deny list first, then the allow list when it is not empty.
+// Entries match a registry host (docker.io) or a repository prefix (docker.io/library).
+func CheckImageRegistry(c conf.ImagePolicy, imageName string) string {
+	normalized := docker.NormalizeImageName(imageName)
+	matches := func(entry string) bool {
+		entry = strings.ToLower(strings.Trim(strings.TrimSpace(entry), "/"))
+		if entry == "" {
+			return false
+		}
+		if !strings.Contains(entry, "/") {
+			entry = docker.RegistryHost(entry)
+		}
+		return strings.HasPrefix(normalized, entry+"/")
+	}
+
+	for _, entry := range c.DenyRegistries {
+		if matches(entry) {
+			return fmt.Sprintf("registry %s is denied", entry)
+		}
+	}
+	if len(c.AllowRegistries) == 0 {
+		return ""
+	}
+	for _, entry := range c.AllowRegistries {
+		if matches(entry) {
+			return ""
+		}
+	}
+	return "registry is not in the allow list"
+}
+
+func checkImageRuntime(c conf.ImagePolicy, runtime ImageRuntime, imageName string) ([]string, error) {
+	user, ports, err := runtime.InspectImage(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed inspect image: %w", err)
+	}
+	return RuntimeViolations(c, user, ports), nil
+}
+
+// RuntimeViolations checks the user and exposed ports of an image against RejectRoot and RejectLowPorts.
+func RuntimeViolations(c conf.ImagePolicy, user string, ports []int) []string {
+	var violations []string
+	if c.RejectRoot {
+		name := strings.SplitN(user, ":", 2)[0]
+		if name == "" || name == "root" || name == "0" {
+			violations = append(violations, "image runs as root")
+		}
+	}
+	if c.RejectLowPorts {
+		for _, port := range ports {
+			if port < lowPortBoundary {
+				violations = append(violations, fmt.Sprintf("image exposes port %d below %d", port, lowPortBoundary))
+			}
+		}
+	}
+	return violations
+}
+
+// scanImage runs the local trivy scanner against its database mirror and counts vulnerabilities by severity.
+func scanImage(c conf.ImagePolicy, imageName string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ScanTimeout)*time.Minute)
+	defer cancel()
+
+	args := []string{"image", "--quiet", "--format", "json", "--severity", c.ScanSeverity, "--scanners", "vuln"}
+	if c.TrivyDBRepository != "" {
+		args = append(args, "--db-repository", c.TrivyDBRepository)
+	}
+	args = append(args, imageName)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.ScannerPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed scan image: %v, %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				Severity string `json:"Severity"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		return nil, fmt.Errorf("failed parse scan report: %w", err)
+	}
+
+	vulnerabilities := make(map[string]int)
+	for _, result := range report.Results {
+		for _, vulnerability := range result.Vulnerabilities {
+			vulnerabilities[vulnerability.Severity]++
+		}
+	}
+	return vulnerabilities, nil
+}
+
+func saveImagePolicyVerdict(verdict ImagePolicyVerdict) error {
+	conn := redisPool.Get()
+	defer conn.Close()
+	return SaveImagePolicyVerdict(conn, verdict)
+}
+
+// SaveImagePolicyVerdict records the verdict of a job for GetImagePolicyVerdict.
+func SaveImagePolicyVerdict(conn redis.Conn, verdict ImagePolicyVerdict) error {
+	if verdict.JobUuid == "" {
+		return nil
+	}
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("SET", constants.REDIS_IMAGE_POLICY_PREFIX+verdict.JobUuid, data, "EX", imagePolicyRecordTTL)
+	return err
+}
+
+// GetImagePolicyVerdict returns the image policy verdict recorded for a job.
+func GetImagePolicyVerdict(c *gin.Context) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	ServeImagePolicyVerdict(c, conn)
+}
+
+// ServeImagePolicyVerdict answers with the verdict recorded in conn for the job of the uuid path parameter.
+func ServeImagePolicyVerdict(c *gin.Context, conn redis.Conn) {
+	data, err := redis.Bytes(conn.Do("GET", constants.REDIS_IMAGE_POLICY_PREFIX+c.Param("uuid")))
+	if err == redis.ErrNil {
+		c.JSON(http.StatusNotFound, common.CreateErrorResponse(strconv.Itoa(http.StatusNotFound), "no image policy verdict for the job"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+
+	var verdict ImagePolicyVerdict
+	if err = json.Unmarshal(data, &verdict); err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(verdict))
+}
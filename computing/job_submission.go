@@ -0,0 +1,215 @@
+package computing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/models"
+)
+
+// IdempotencyKeyHeader optionally identifies a submission in addition to the job uuid.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// jobSubmissionRetention is how long a submission is remembered after its job expires.
+const jobSubmissionRetention = 24 * time.Hour
+
+// JobSubmission is an accepted job submission, a retried submission is answered with its job
+// instead of deploying it again.
+type JobSubmission struct {
+	Fingerprint    string         `json:"fingerprint"`
+	IdempotencyKey string         `json:"idempotency_key,omitempty"`
+	Job            models.JobData `json:"job"`
+	CreatedAt      int64          `json:"created_at"`
+	// Run names the latest submission or redeployment of the job, a late upload only updates its own run.
+	Run string `json:"run,omitempty"`
+}
+
+// SubmissionConflictError is returned when a job uuid or idempotency key is submitted again with another payload.
+type SubmissionConflictError struct {
+	JobUuid string
+	Key     string
+}
+
+func (e *SubmissionConflictError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("idempotency key %s was already used for job %s with a different payload", e.Key, e.JobUuid)
+	}
+	return fmt.Sprintf("job %s was already submitted with a different payload", e.JobUuid)
+}
+
+// JobFingerprint hashes the fields of a submission set by its sender, the status, result and
+// timestamps are left out as they are set by the provider or may change between retries.
+func JobFingerprint(jobData models.JobData) string {
+	payload, _ := json.Marshal([]interface{}{
+		jobData.UUID,
+		jobData.Name,
+		jobData.Duration,
+		jobData.Hardware,
+		jobData.JobSourceURI,
+		jobData.StorageSource,
+		jobData.TaskUUID,
+		jobData.Price,
+		jobData.Type,
+		jobData.Schedule,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchJobSubmission checks a submission against the one recorded for its uuid or idempotency key.
+// It returns nil when the submission is a retry of the recorded one.
+func MatchJobSubmission(recorded *JobSubmission, jobData models.JobData) error {
+	if recorded.Job.UUID != jobData.UUID || recorded.Fingerprint != JobFingerprint(jobData) {
+		return &SubmissionConflictError{JobUuid: recorded.Job.UUID, Key: recorded.IdempotencyKey}
+	}
+	return nil
+}
+
+// replayJobSubmission answers a submission recorded before with the job it created, or rejects it when it conflicts.
+func replayJobSubmission(c *gin.Context, recorded *JobSubmission, jobData models.JobData) {
+	if err := MatchJobSubmission(recorded, jobData); err != nil {
+		logs.GetLogger().Warnf("Job %s rejected: %v", jobData.UUID, err)
+		jobsTotal.WithLabelValues(jobStateDeclined).Inc()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	logs.GetLogger().Infof("Job %s was already submitted, returning its record", jobData.UUID)
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(http.StatusOK, recorded.Job)
+}
+
+func jobSubmissionKeys(jobUuid, idempotencyKey string) []string {
+	var keys []string
+	if idempotencyKey != "" {
+		keys = append(keys, constants.REDIS_JOB_SUBMISSION_KEY_PREFIX+idempotencyKey)
+	}
+	if jobUuid != "" {
+		keys = append(keys, constants.REDIS_JOB_SUBMISSION_PREFIX+jobUuid)
+	}
+	return keys
+}
+
+func jobSubmissionTTL(duration int) int {
+	return duration + int(jobSubmissionRetention.Seconds())
+}
+
+// FindJobSubmission returns the submission recorded for the idempotency key or the uuid of a job, nil when there is none.
+func FindJobSubmission(conn redis.Conn, jobUuid, idempotencyKey string) (*JobSubmission, error) {
+	for _, key := range jobSubmissionKeys(jobUuid, idempotencyKey) {
+		value, err := redis.Bytes(conn.Do("GET", key))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var submission JobSubmission
+		if err = json.Unmarshal(value, &submission); err != nil {
+			return nil, err
+		}
+		return &submission, nil
+	}
+	return nil, nil
+}
+
+// ClaimJobSubmission records a submission unless one is already recorded for its uuid or idempotency key,
+// in which case the recorded one is returned.
+func ClaimJobSubmission(conn redis.Conn, jobData models.JobData, idempotencyKey string) (*JobSubmission, error) {
+	submission := JobSubmission{
+		Fingerprint:    JobFingerprint(jobData),
+		IdempotencyKey: idempotencyKey,
+		Job:            jobData,
+		CreatedAt:      time.Now().Unix(),
+	}
+	value, err := json.Marshal(submission)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := jobSubmissionTTL(jobData.Duration)
+	var claimed []string
+	for _, key := range jobSubmissionKeys(jobData.UUID, idempotencyKey) {
+		_, err := redis.String(conn.Do("SET", key, value, "NX", "EX", ttl))
+		if err == redis.ErrNil {
+			// a concurrent submission got there first
+			for _, claimedKey := range claimed {
+				conn.Do("DEL", claimedKey)
+			}
+			recorded, err := FindJobSubmission(conn, jobData.UUID, idempotencyKey)
+			if err != nil || recorded != nil {
+				return recorded, err
+			}
+			return nil, fmt.Errorf("submission of job %s was released while claiming it", jobData.UUID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, key)
+	}
+	return nil, nil
+}
+
+// UpdateJobSubmission stores the job of a recorded submission once it is submitted or redeployed as run.
+func UpdateJobSubmission(conn redis.Conn, jobData models.JobData, run string) error {
+	submission, err := FindJobSubmission(conn, jobData.UUID, "")
+	if err != nil || submission == nil {
+		return err
+	}
+	submission.Job = jobData
+	submission.Run = run
+	value, err := json.Marshal(submission)
+	if err != nil {
+		return err
+	}
+	for _, key := range jobSubmissionKeys(jobData.UUID, submission.IdempotencyKey) {
+		if _, err = conn.Do("SET", key, value, "XX", "EX", jobSubmissionTTL(jobData.Duration)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetJobSubmissionResult sets the job result of a recorded submission, unless the job was redeployed since
+// run. It reports whether the result was set.
+func SetJobSubmissionResult(conn redis.Conn, jobUuid, run, resultURI string) (bool, error) {
+	uuidKey := constants.REDIS_JOB_SUBMISSION_PREFIX + jobUuid
+	if _, err := conn.Do("WATCH", uuidKey); err != nil {
+		return false, err
+	}
+	defer conn.Do("UNWATCH")
+
+	submission, err := FindJobSubmission(conn, jobUuid, "")
+	if err != nil || submission == nil || submission.Run != run {
+		return false, err
+	}
+	submission.Job.JobResultURI = resultURI
+	value, err := json.Marshal(submission)
+	if err != nil {
+		return false, err
+	}
+	conn.Send("MULTI")
+	for _, key := range jobSubmissionKeys(jobUuid, submission.IdempotencyKey) {
+		conn.Send("SET", key, value, "XX", "EX", jobSubmissionTTL(submission.Job.Duration))
+	}
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return false, err
+	}
+	// a nil reply means the submission was updated concurrently, by a newer run
+	return reply != nil, nil
+}
+
+// ReleaseJobSubmission forgets a submission that could not be enqueued so that it can be retried.
+func ReleaseJobSubmission(conn redis.Conn, jobUuid, idempotencyKey string) {
+	for _, key := range jobSubmissionKeys(jobUuid, idempotencyKey) {
+		conn.Do("DEL", key)
+	}
+}
@@ -1,10 +1,12 @@
 package computing
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/lagrangedao/go-computing-provider/conf"
 	"github.com/lagrangedao/go-computing-provider/constants"
 	"github.com/lagrangedao/go-computing-provider/models"
 	"io"
@@ -15,23 +17,35 @@ import (
 	"sync"
 
 	appV1 "k8s.io/api/apps/v1"
+	batchV1 "k8s.io/api/batch/v1"
 	coreV1 "k8s.io/api/core/v1"
 
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/client-go/util/homedir"
 )
 
 var clientSet *kubernetes.Clientset
+var restConfig *rest.Config
 var k8sOnce sync.Once
 
 type K8sService struct {
-	k8sClient *kubernetes.Clientset
-	Version   string
+	k8sClient kubernetes.Interface
+	// config is needed to exec into pods, it is nil for a wrapped client.
+	config  *rest.Config
+	Version string
+}
+
+// NewK8sServiceWithClient wraps an existing client, such as the fake clientset in tests.
+func NewK8sServiceWithClient(client kubernetes.Interface) *K8sService {
+	return &K8sService{k8sClient: client}
 }
 
 func NewK8sService() *K8sService {
@@ -52,6 +66,8 @@ func NewK8sService() *K8sService {
 				return
 			}
 		}
+		wrapKubernetesTransport(config)
+		restConfig = config
 		clientSet, err = kubernetes.NewForConfig(config)
 		if err != nil {
 			logs.GetLogger().Errorf("Failed create k8s clientset, error: %v", err)
@@ -68,6 +84,7 @@ func NewK8sService() *K8sService {
 
 	return &K8sService{
 		k8sClient: clientSet,
+		config:    restConfig,
 		Version:   version,
 	}
 }
@@ -105,6 +122,149 @@ func (s *K8sService) GetDeploymentImages(ctx context.Context, namespace, deploym
 	return imageIds, nil
 }
 
+// GetPodImageDigests returns the digests of the images run by the pods of a space, keyed by image name.
+func (s *K8sService) GetPodImageDigests(ctx context.Context, namespace, spaceName string) (map[string]string, error) {
+	podList, err := s.k8sClient.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string)
+	for _, pod := range podList.Items {
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.ImageID == "" {
+				continue
+			}
+			// the runtime reports docker-pullable://repo@sha256:... or the bare image reference
+			imageId := status.ImageID
+			if i := strings.Index(imageId, "://"); i >= 0 {
+				imageId = imageId[i+3:]
+			}
+			digests[status.Image] = imageId
+		}
+	}
+	return digests, nil
+}
+
+func (s *K8sService) CreateJob(ctx context.Context, nameSpace string, job *batchV1.Job) (*batchV1.Job, error) {
+	return s.k8sClient.BatchV1().Jobs(nameSpace).Create(ctx, job, metaV1.CreateOptions{})
+}
+
+func (s *K8sService) GetJob(ctx context.Context, namespace, jobName string) (*batchV1.Job, error) {
+	return s.k8sClient.BatchV1().Jobs(namespace).Get(ctx, jobName, metaV1.GetOptions{})
+}
+
+func (s *K8sService) ListJobs(ctx context.Context, namespace string) ([]batchV1.Job, error) {
+	jobList, err := s.k8sClient.BatchV1().Jobs(namespace).List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return jobList.Items, nil
+}
+
+// DeleteJob deletes a job together with its pods.
+func (s *K8sService) DeleteJob(ctx context.Context, namespace, jobName string) error {
+	propagation := metaV1.DeletePropagationBackground
+	return s.k8sClient.BatchV1().Jobs(namespace).Delete(ctx, jobName, metaV1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+func (s *K8sService) CreateCronJob(ctx context.Context, nameSpace string, cronJob *batchV1.CronJob) (*batchV1.CronJob, error) {
+	return s.k8sClient.BatchV1().CronJobs(nameSpace).Create(ctx, cronJob, metaV1.CreateOptions{})
+}
+
+func (s *K8sService) GetCronJob(ctx context.Context, namespace, cronJobName string) (*batchV1.CronJob, error) {
+	return s.k8sClient.BatchV1().CronJobs(namespace).Get(ctx, cronJobName, metaV1.GetOptions{})
+}
+
+func (s *K8sService) ListCronJobs(ctx context.Context, namespace string) ([]batchV1.CronJob, error) {
+	cronJobList, err := s.k8sClient.BatchV1().CronJobs(namespace).List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return cronJobList.Items, nil
+}
+
+// DeleteCronJob deletes a cron job together with the jobs it started.
+func (s *K8sService) DeleteCronJob(ctx context.Context, namespace, cronJobName string) error {
+	propagation := metaV1.DeletePropagationBackground
+	return s.k8sClient.BatchV1().CronJobs(namespace).Delete(ctx, cronJobName, metaV1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+// DeleteSpaceJobs deletes the jobs of every service of a space together with their pods.
+func (s *K8sService) DeleteSpaceJobs(ctx context.Context, namespace, spaceName string) error {
+	propagation := metaV1.DeletePropagationBackground
+	return s.k8sClient.BatchV1().Jobs(namespace).DeleteCollection(ctx, metaV1.DeleteOptions{PropagationPolicy: &propagation}, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceName),
+	})
+}
+
+// DeleteSpaceCronJobs deletes the cron jobs of every service of a space together with their runs.
+func (s *K8sService) DeleteSpaceCronJobs(ctx context.Context, namespace, spaceName string) error {
+	propagation := metaV1.DeletePropagationBackground
+	return s.k8sClient.BatchV1().CronJobs(namespace).DeleteCollection(ctx, metaV1.DeleteOptions{PropagationPolicy: &propagation}, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceName),
+	})
+}
+
+func (s *K8sService) ListJobPods(ctx context.Context, namespace, jobName string) ([]coreV1.Pod, error) {
+	podList, err := s.k8sClient.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// GetPodLogs streams the logs of a container, tailLines limits them to the last lines when positive.
+func (s *K8sService) GetPodLogs(ctx context.Context, namespace, podName, container string, tailLines int64) (io.ReadCloser, error) {
+	opts := &coreV1.PodLogOptions{Container: container}
+	if tailLines > 0 {
+		opts.TailLines = &tailLines
+	}
+	return s.k8sClient.CoreV1().Pods(namespace).GetLogs(podName, opts).Stream(ctx)
+}
+
+// ExecPod runs a command in a container and copies its output to stdout.
+func (s *K8sService) ExecPod(ctx context.Context, namespace, podName, container string, command []string, stdout io.Writer) error {
+	if s.config == nil {
+		return fmt.Errorf("exec into pod %s needs a kubernetes config", podName)
+	}
+	req := s.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&coreV1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(s.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: &stderr})
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-done:
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
 func (s *K8sService) GetServiceByName(ctx context.Context, namespace, serviceName string, opts metaV1.GetOptions) (result *coreV1.Service, err error) {
 	return s.k8sClient.CoreV1().Services(namespace).Get(ctx, serviceName, opts)
 }
@@ -221,31 +381,110 @@ func (s *K8sService) GetPods(namespace, spaceName string) (bool, error) {
 	return false, nil
 }
 
-func (s *K8sService) CreateNetworkPolicy(ctx context.Context, namespace string) (*networkingv1.NetworkPolicy, error) {
-	networkPolicy := &networkingv1.NetworkPolicy{
+func (s *K8sService) ApplyNetworkPolicy(ctx context.Context, networkPolicy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	policies := s.k8sClient.NetworkingV1().NetworkPolicies(networkPolicy.Namespace)
+	existing, err := policies.Get(ctx, networkPolicy.Name, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return policies.Create(ctx, networkPolicy, metaV1.CreateOptions{})
+		}
+		return nil, err
+	}
+	existing.Spec = networkPolicy.Spec
+	return policies.Update(ctx, existing, metaV1.UpdateOptions{})
+}
+
+func (s *K8sService) ApplyLimitRange(ctx context.Context, limitRange *coreV1.LimitRange) (*coreV1.LimitRange, error) {
+	limitRanges := s.k8sClient.CoreV1().LimitRanges(limitRange.Namespace)
+	existing, err := limitRanges.Get(ctx, limitRange.Name, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return limitRanges.Create(ctx, limitRange, metaV1.CreateOptions{})
+		}
+		return nil, err
+	}
+	existing.Spec = limitRange.Spec
+	return limitRanges.Update(ctx, existing, metaV1.UpdateOptions{})
+}
+
+func (s *K8sService) ApplyResourceQuota(ctx context.Context, quota *coreV1.ResourceQuota) (*coreV1.ResourceQuota, error) {
+	quotas := s.k8sClient.CoreV1().ResourceQuotas(quota.Namespace)
+	existing, err := quotas.Get(ctx, quota.Name, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return quotas.Create(ctx, quota, metaV1.CreateOptions{})
+		}
+		return nil, err
+	}
+	existing.Spec = quota.Spec
+	return quotas.Update(ctx, existing, metaV1.UpdateOptions{})
+}
+
+func (s *K8sService) ListDeployments(ctx context.Context, namespace string) ([]appV1.Deployment, error) {
+	list, err := s.k8sClient.AppsV1().Deployments(namespace).List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// GetApiServerIPs returns the cluster IP of the kubernetes service and the addresses of its endpoints.
+func (s *K8sService) GetApiServerIPs(ctx context.Context) ([]string, error) {
+	service, err := s.k8sClient.CoreV1().Services(coreV1.NamespaceDefault).Get(ctx, "kubernetes", metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ips := append([]string{}, service.Spec.ClusterIPs...)
+	if len(ips) == 0 && service.Spec.ClusterIP != "" {
+		ips = append(ips, service.Spec.ClusterIP)
+	}
+
+	endpoints, err := s.k8sClient.CoreV1().Endpoints(coreV1.NamespaceDefault).Get(ctx, "kubernetes", metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			ips = append(ips, address.IP)
+		}
+	}
+	return ips, nil
+}
+
+func (s *K8sService) LabelNameSpace(ctx context.Context, nameSpace string, labels map[string]string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		namespace, err := s.k8sClient.CoreV1().Namespaces().Get(ctx, nameSpace, metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if namespace.Labels == nil {
+			namespace.Labels = make(map[string]string)
+		}
+		for key, value := range labels {
+			namespace.Labels[key] = value
+		}
+		_, err = s.k8sClient.CoreV1().Namespaces().Update(ctx, namespace, metaV1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *K8sService) ApplyImagePullSecret(ctx context.Context, namespace string, dockerConfigJson []byte) (*coreV1.Secret, error) {
+	secret := &coreV1.Secret{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      namespace + "-" + generateString(4),
+			Name:      constants.K8S_IMAGE_PULL_SECRET_NAME,
 			Namespace: namespace,
 		},
-		Spec: networkingv1.NetworkPolicySpec{
-			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
-			Ingress: []networkingv1.NetworkPolicyIngressRule{
-				{
-					From: []networkingv1.NetworkPolicyPeer{
-						{
-							NamespaceSelector: &metaV1.LabelSelector{
-								MatchLabels: map[string]string{
-									"kubernetes.io/metadata.name": "ingress-nginx",
-								},
-							},
-						},
-					},
-				},
-			},
+		Type: coreV1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			coreV1.DockerConfigJsonKey: dockerConfigJson,
 		},
 	}
 
-	return s.k8sClient.NetworkingV1().NetworkPolicies(namespace).Create(ctx, networkPolicy, metaV1.CreateOptions{})
+	created, err := s.k8sClient.CoreV1().Secrets(namespace).Create(ctx, secret, metaV1.CreateOptions{})
+	if err != nil && errors.IsAlreadyExists(err) {
+		return s.k8sClient.CoreV1().Secrets(namespace).Update(ctx, secret, metaV1.UpdateOptions{})
+	}
+	return created, err
 }
 
 func (s *K8sService) CreateNameSpace(ctx context.Context, nameSpace *coreV1.Namespace, opts metaV1.CreateOptions) (result *coreV1.Namespace, err error) {
@@ -268,6 +507,12 @@ func (s *K8sService) ListUsedImage(ctx context.Context, nameSpace string) ([]str
 
 	var usedImages []string
 	for _, item := range list.Items {
+		for _, container := range item.Spec.InitContainers {
+			usedImages = append(usedImages, container.Image)
+		}
+		for _, container := range item.Spec.Containers {
+			usedImages = append(usedImages, container.Image)
+		}
 		for _, status := range item.Status.ContainerStatuses {
 			usedImages = append(usedImages, status.Image)
 		}
@@ -275,6 +520,36 @@ func (s *K8sService) ListUsedImage(ctx context.Context, nameSpace string) ([]str
 	return usedImages, nil
 }
 
+// Ping checks that the api server answers.
+func (s *K8sService) Ping(ctx context.Context) error {
+	return s.k8sClient.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+func (s *K8sService) GetNodeList() ([]coreV1.Node, error) {
+	nodes, err := s.k8sClient.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+func (s *K8sService) ApplyDaemonSet(ctx context.Context, daemonSet *appV1.DaemonSet) (*appV1.DaemonSet, error) {
+	daemonSets := s.k8sClient.AppsV1().DaemonSets(daemonSet.Namespace)
+	existing, err := daemonSets.Get(ctx, daemonSet.Name, metaV1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return daemonSets.Create(ctx, daemonSet, metaV1.CreateOptions{})
+		}
+		return nil, err
+	}
+	existing.Spec = daemonSet.Spec
+	return daemonSets.Update(ctx, existing, metaV1.UpdateOptions{})
+}
+
+func (s *K8sService) DeleteDaemonSet(ctx context.Context, namespace, name string) error {
+	return s.k8sClient.AppsV1().DaemonSets(namespace).Delete(ctx, name, metaV1.DeleteOptions{})
+}
+
 func (s *K8sService) ListNamespace(ctx context.Context) ([]string, error) {
 	list, err := s.k8sClient.CoreV1().Namespaces().List(ctx, metaV1.ListOptions{})
 	if err != nil {
@@ -297,104 +572,128 @@ func (s *K8sService) StatisticalSources(ctx context.Context) ([]*models.NodeReso
 
 	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
 	if err != nil {
-		logs.GetLogger().Error(err)
+		logs.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
-	nodeGpuInfoMap, err := s.GetPodLog(ctx)
-	if err != nil {
-		logs.GetLogger().Error(err)
-		return nil, err
+	gpuInfoMap := s.GpuInventory(ctx, nodes.Items)
+
+	var warmImages []string
+	if cfg := conf.GetConfig(); cfg != nil && cfg.WarmCache.Enable {
+		if warmImages, err = warmCacheImages(); err != nil {
+			logs.FromContext(ctx).Errorf("Failed get warm cache images, error: %+v", err)
+		}
 	}
 
 	for _, node := range nodes.Items {
 		nodeResource, err := getNodeResource(activePods, &node)
 		if err != nil {
-			logs.GetLogger().Error(err)
+			logs.FromContext(ctx).Error(err)
 		}
+		nodeResource.CachedImages = nodeCachedImages(&node, warmImages)
 
-		if gpu, ok := nodeGpuInfoMap[node.Name]; ok {
-			var gpuInfo struct {
-				Gpu models.Gpu `json:"gpu"`
-			}
-			err := json.Unmarshal([]byte(gpu.String()), &gpuInfo)
-			if err != nil {
-				logs.GetLogger().Error(err)
-				return nil, err
-			}
-			nodeResource.Gpu = gpuInfo.Gpu
+		if gpu, ok := gpuInfoMap[node.Name]; ok {
+			nodeResource.Gpu = gpu
 		}
+		nodeResource.Gpu.Shares = NodeGpuShares(&node, getPodsFromNode(activePods, &node), nodeResource.Gpu.Details)
 		nodeList = append(nodeList, nodeResource)
 	}
 	return nodeList, nil
 }
 
-func (s *K8sService) GetPodLog(ctx context.Context) (map[string]*strings.Builder, error) {
-	var num int64 = 1
-	podLogOptions := coreV1.PodLogOptions{
-		Container:  "",
-		TailLines:  &num,
-		Timestamps: false,
+// PodMetrics returns the current CPU and memory usage of the pods from the metrics API, summed over containers.
+func (s *K8sService) PodMetrics(ctx context.Context, namespace, labelSelector string) (map[string]coreV1.ResourceList, error) {
+	data, err := s.k8sClient.CoreV1().RESTClient().Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", namespace, "pods").
+		Param("labelSelector", labelSelector).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	podList, err := s.k8sClient.CoreV1().Pods(coreV1.NamespaceDefault).List(ctx, metaV1.ListOptions{
-		LabelSelector: "app=hardware-collect",
-	})
-	if err != nil {
-		logs.GetLogger().Error(err)
+	var metricsList struct {
+		Items []struct {
+			Metadata   metaV1.ObjectMeta `json:"metadata"`
+			Containers []struct {
+				Usage coreV1.ResourceList `json:"usage"`
+			} `json:"containers"`
+		} `json:"items"`
+	}
+	if err = json.Unmarshal(data, &metricsList); err != nil {
 		return nil, err
 	}
 
-	result := make(map[string]*strings.Builder)
-	for _, pod := range podList.Items {
-		req := s.k8sClient.CoreV1().Pods(coreV1.NamespaceDefault).GetLogs(pod.Name, &podLogOptions)
-		buf, err := readLog(req)
-		if err != nil {
-			return nil, err
+	result := make(map[string]coreV1.ResourceList)
+	for _, item := range metricsList.Items {
+		usage := coreV1.ResourceList{}
+		for _, container := range item.Containers {
+			for name, quantity := range container.Usage {
+				sum := usage[name]
+				sum.Add(quantity)
+				usage[name] = sum
+			}
 		}
-		result[pod.Spec.NodeName] = buf
+		result[item.Metadata.Name] = usage
 	}
 	return result, nil
 }
 
-func (s *K8sService) AddNodeLabel(nodeName, key string) error {
-	key = strings.ReplaceAll(key, " ", "-")
-
-	node, err := s.k8sClient.CoreV1().Nodes().Get(context.Background(), nodeName, metaV1.GetOptions{})
+// NodeNetworkTx returns the bytes transmitted by each pod of a node, keyed by namespace/name, from the kubelet summary API.
+func (s *K8sService) NodeNetworkTx(ctx context.Context, nodeName string) (map[string]int64, error) {
+	data, err := s.k8sClient.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", nodeName, "proxy/stats/summary").
+		DoRaw(ctx)
 	if err != nil {
-		return err
-	}
-	node.Labels[key] = "true"
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		_, updateErr := s.k8sClient.CoreV1().Nodes().Update(context.Background(), node, metaV1.UpdateOptions{})
-		return updateErr
-	})
-	if retryErr != nil {
-		return fmt.Errorf("failed update node label: %w", retryErr)
+		return nil, err
 	}
-	return nil
-}
 
-func readLog(req *rest.Request) (*strings.Builder, error) {
-	podLogs, err := req.Stream(context.TODO())
-	if err != nil {
-		return nil, err
+	var summary struct {
+		Pods []struct {
+			PodRef struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"podRef"`
+			Network *struct {
+				TxBytes *int64 `json:"txBytes"`
+			} `json:"network"`
+		} `json:"pods"`
 	}
-	defer podLogs.Close()
-	buf := new(strings.Builder)
-	_, err = io.Copy(buf, podLogs)
-	if err != nil {
+	if err = json.Unmarshal(data, &summary); err != nil {
 		return nil, err
 	}
-	return buf, nil
+
+	result := make(map[string]int64)
+	for _, pod := range summary.Pods {
+		if pod.Network != nil && pod.Network.TxBytes != nil {
+			result[pod.PodRef.Namespace+"/"+pod.PodRef.Name] = *pod.Network.TxBytes
+		}
+	}
+	return result, nil
 }
 
-func generateLabel(name string) map[string]string {
-	var labels = make(map[string]string)
-	if strings.Contains(name, "NVIDIA") {
-		labels["nvidia.com/gpu.product"] = name
+// UpdateNodeLabels sets and removes labels of a node, retrying on conflicting updates.
+func (s *K8sService) UpdateNodeLabels(nodeName string, set map[string]string, remove []string) error {
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := s.k8sClient.CoreV1().Nodes().Get(context.Background(), nodeName, metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		for key, value := range set {
+			node.Labels[key] = value
+		}
+		for _, key := range remove {
+			delete(node.Labels, key)
+		}
+		_, err = s.k8sClient.CoreV1().Nodes().Update(context.Background(), node, metaV1.UpdateOptions{})
+		return err
+	})
+	if retryErr != nil {
+		return fmt.Errorf("failed update node label: %w", retryErr)
 	}
-	return labels
+	return nil
 }
 
 func IsKubernetesVersionGreaterThan(version string, targetVersion string) bool {
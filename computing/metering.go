@@ -0,0 +1,366 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/lad"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultMeteringInterval = 60
+	jobUsageRecordTTL       = 90 * 24 * 3600
+	bytesPerGiB             = 1024 * 1024 * 1024
+)
+
+var meteringLock sync.Mutex
+
+// JobUsage is the resource usage metered for a job. CPU seconds, memory GiB-hours and GPU-hours are
+// integrated over the sampling interval, egress bytes are the transmitted bytes reported by the kubelet.
+type JobUsage struct {
+	JobUuid        string           `json:"job_uuid"`
+	Namespace      string           `json:"namespace"`
+	SpaceName      string           `json:"space_name"`
+	StartedAt      int64            `json:"started_at"`
+	EndedAt        int64            `json:"ended_at,omitempty"`
+	LastSampleAt   int64            `json:"last_sample_at"`
+	Samples        int              `json:"samples"`
+	CpuSeconds     float64          `json:"cpu_seconds"`
+	MemoryGiBHours float64          `json:"memory_gib_hours"`
+	GpuHours       float64          `json:"gpu_hours"`
+	EgressBytes    int64            `json:"egress_bytes"`
+	EgressMeasured bool             `json:"egress_measured"`
+	Summary        *JobUsageSummary `json:"summary,omitempty"`
+}
+
+// JobUsageSummary is the final usage of a job signed by the provider. Payload holds the exact signed
+// bytes, the signature is an EIP-191 personal message signature recoverable to Provider.
+type JobUsageSummary struct {
+	Payload   string `json:"payload"`
+	Provider  string `json:"provider"`
+	Signature string `json:"signature"`
+}
+
+type jobUsagePayload struct {
+	JobUuid        string  `json:"job_uuid"`
+	SpaceName      string  `json:"space_name"`
+	StartedAt      int64   `json:"started_at"`
+	EndedAt        int64   `json:"ended_at"`
+	CpuSeconds     float64 `json:"cpu_seconds"`
+	MemoryGiBHours float64 `json:"memory_gib_hours"`
+	GpuHours       float64 `json:"gpu_hours"`
+	EgressBytes    int64   `json:"egress_bytes"`
+}
+
+// PodSample is the usage of a pod at sampling time, TxBytes is the transmit counter of the pod when HasTx.
+type PodSample struct {
+	CpuCores  float64
+	MemoryGiB float64
+	Gpus      int64
+	TxBytes   int64
+	HasTx     bool
+}
+
+func meteringConfig() conf.Metering {
+	c := conf.GetConfig().Metering
+	if c.Interval <= 0 {
+		c.Interval = defaultMeteringInterval
+	}
+	return c
+}
+
+func jobUsageKey(jobUuid string) string {
+	return constants.REDIS_JOB_USAGE_PREFIX + jobUuid
+}
+
+func jobUsageSpace(namespace, spaceName string) string {
+	return namespace + "/" + spaceName
+}
+
+// startJobUsage opens the usage record of a deployed job.
+func startJobUsage(jobUuid, namespace, spaceName string) {
+	if !meteringConfig().Enable || jobUuid == "" {
+		return
+	}
+	meteringLock.Lock()
+	defer meteringLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	now := time.Now().Unix()
+	usage := JobUsage{
+		JobUuid:      jobUuid,
+		Namespace:    namespace,
+		SpaceName:    spaceName,
+		StartedAt:    now,
+		LastSampleAt: now,
+	}
+	if err := saveJobUsage(conn, &usage); err != nil {
+		logs.GetLogger().Errorf("Failed save job usage, job: %s, error: %+v", jobUuid, err)
+		return
+	}
+	conn.Do("DEL", constants.REDIS_JOB_USAGE_TX_PREFIX+jobUuid)
+	conn.Do("HSET", constants.REDIS_JOB_USAGE_ACTIVE, jobUsageSpace(namespace, spaceName), jobUuid)
+}
+
+// finishJobUsage takes the last sample of the job running in a space, then closes and signs its usage record.
+func finishJobUsage(namespace, spaceName string) {
+	if !meteringConfig().Enable {
+		return
+	}
+	meteringLock.Lock()
+	defer meteringLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	jobUuid, err := redis.String(conn.Do("HGET", constants.REDIS_JOB_USAGE_ACTIVE, jobUsageSpace(namespace, spaceName)))
+	if err != nil {
+		return
+	}
+	conn.Do("HDEL", constants.REDIS_JOB_USAGE_ACTIVE, jobUsageSpace(namespace, spaceName))
+
+	usage, err := loadJobUsage(conn, jobUuid)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get job usage, job: %s, error: %+v", jobUuid, err)
+		return
+	}
+	nodeStats := make(map[string]map[string]int64)
+	if err = sampleJobUsage(context.TODO(), conn, usage, nodeStats); err != nil {
+		logs.GetLogger().Warnf("Failed take last usage sample, job: %s, error: %+v", jobUuid, err)
+	}
+
+	if err = CloseJobUsage(usage, time.Now().Unix(), providerIdentity()); err != nil {
+		logs.GetLogger().Errorf("Failed sign job usage, job: %s, error: %+v", jobUuid, err)
+	}
+	if err = saveJobUsage(conn, usage); err != nil {
+		logs.GetLogger().Errorf("Failed save job usage, job: %s, error: %+v", jobUuid, err)
+	}
+	conn.Do("DEL", constants.REDIS_JOB_USAGE_TX_PREFIX+jobUuid)
+	if usage.Summary != nil {
+		logs.GetLogger().Infof("Job %s usage closed: %s", jobUuid, usage.Summary.Payload)
+	}
+}
+
+// CloseJobUsage ends the usage record at endedAt and attaches the summary signed by signer.
+func CloseJobUsage(usage *JobUsage, endedAt int64, signer lad.Signer) error {
+	usage.EndedAt = endedAt
+	payload, err := json.Marshal(jobUsagePayload{
+		JobUuid:        usage.JobUuid,
+		SpaceName:      usage.SpaceName,
+		StartedAt:      usage.StartedAt,
+		EndedAt:        usage.EndedAt,
+		CpuSeconds:     usage.CpuSeconds,
+		MemoryGiBHours: usage.MemoryGiBHours,
+		GpuHours:       usage.GpuHours,
+		EgressBytes:    usage.EgressBytes,
+	})
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return err
+	}
+	usage.Summary = &JobUsageSummary{Payload: string(payload), Provider: signer.Address(), Signature: signature}
+	return nil
+}
+
+func watchMetering() {
+	c := meteringConfig()
+	if !c.Enable {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(c.Interval) * time.Second)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.GetLogger().Errorf("catch panic error: %+v", err)
+			}
+		}()
+
+		for range ticker.C {
+			meterActiveJobs()
+		}
+	}()
+}
+
+// meterActiveJobs samples every running job, the kubelet statistics of a node are fetched once per round.
+func meterActiveJobs() {
+	meteringLock.Lock()
+	defer meteringLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	active, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_JOB_USAGE_ACTIVE))
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get metered jobs, error: %+v", err)
+		return
+	}
+
+	nodeStats := make(map[string]map[string]int64)
+	for _, jobUuid := range active {
+		usage, err := loadJobUsage(conn, jobUuid)
+		if err != nil {
+			logs.GetLogger().Errorf("Failed get job usage, job: %s, error: %+v", jobUuid, err)
+			continue
+		}
+		if err = sampleJobUsage(context.TODO(), conn, usage, nodeStats); err != nil {
+			logs.GetLogger().Warnf("Failed sample job usage, job: %s, error: %+v", jobUuid, err)
+			continue
+		}
+		if err = saveJobUsage(conn, usage); err != nil {
+			logs.GetLogger().Errorf("Failed save job usage, job: %s, error: %+v", jobUuid, err)
+		}
+	}
+}
+
+// sampleJobUsage adds the usage of the job pods since the last sample.
+func sampleJobUsage(ctx context.Context, conn redis.Conn, usage *JobUsage, nodeStats map[string]map[string]int64) error {
+	now := time.Now().Unix()
+	if now <= usage.LastSampleAt {
+		return nil
+	}
+	samples, err := samplePods(ctx, usage.Namespace, usage.SpaceName, nodeStats)
+	if err != nil {
+		usage.LastSampleAt = now
+		return err
+	}
+	return AddJobUsageSample(conn, usage, samples, now, meteringConfig().Interval)
+}
+
+// AddJobUsageSample adds the usage of the pod samples taken at now. The elapsed time is capped at two
+// intervals, so a provider restart does not bill the downtime at the usage of the last sample.
+func AddJobUsageSample(conn redis.Conn, usage *JobUsage, samples map[string]PodSample, now int64, interval int) error {
+	elapsed := now - usage.LastSampleAt
+	if maxElapsed := int64(2 * interval); elapsed > maxElapsed {
+		elapsed = maxElapsed
+	}
+	usage.LastSampleAt = now
+	if elapsed <= 0 {
+		return nil
+	}
+
+	txKey := constants.REDIS_JOB_USAGE_TX_PREFIX + usage.JobUuid
+	lastTx, err := redis.Int64Map(conn.Do("HGETALL", txKey))
+	if err != nil {
+		return err
+	}
+	for podName, sample := range samples {
+		usage.CpuSeconds += sample.CpuCores * float64(elapsed)
+		usage.MemoryGiBHours += sample.MemoryGiB * float64(elapsed) / 3600
+		usage.GpuHours += float64(sample.Gpus) * float64(elapsed) / 3600
+		if sample.HasTx {
+			usage.EgressMeasured = true
+			// the counter restarts with the container, then all of it is new traffic
+			delta := sample.TxBytes - lastTx[podName]
+			if delta < 0 {
+				delta = sample.TxBytes
+			}
+			usage.EgressBytes += delta
+			conn.Do("HSET", txKey, podName, sample.TxBytes)
+		}
+	}
+	usage.Samples++
+	return nil
+}
+
+// samplePods reads the running pods of a space, their CPU and memory from the metrics API, their GPUs
+// from the pod spec and their transmitted bytes from the kubelet summary of their node.
+func samplePods(ctx context.Context, namespace, spaceName string, nodeStats map[string]map[string]int64) (map[string]PodSample, error) {
+	k8sService := NewK8sService()
+	selector := "lad_app=" + spaceName
+	pods, err := k8sService.k8sClient.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := k8sService.PodMetrics(ctx, namespace, selector)
+	if err != nil {
+		logs.GetLogger().Warnf("Failed get pod metrics of %s/%s, error: %+v", namespace, spaceName, err)
+	}
+
+	samples := make(map[string]PodSample)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != coreV1.PodRunning {
+			continue
+		}
+		var sample PodSample
+		if usage, ok := metrics[pod.Name]; ok {
+			sample.CpuCores = float64(usage.Cpu().MilliValue()) / 1000
+			sample.MemoryGiB = float64(usage.Memory().Value()) / bytesPerGiB
+		}
+		for _, container := range pod.Spec.Containers {
+			for name, quantity := range container.Resources.Limits {
+				if yaml.IsGpuResource(name) {
+					sample.Gpus += quantity.Value()
+				}
+			}
+		}
+
+		if _, ok := nodeStats[pod.Spec.NodeName]; !ok {
+			stats, err := k8sService.NodeNetworkTx(ctx, pod.Spec.NodeName)
+			if err != nil {
+				logs.GetLogger().Warnf("Failed get network stats of node %s, error: %+v", pod.Spec.NodeName, err)
+			}
+			nodeStats[pod.Spec.NodeName] = stats
+		}
+		if txBytes, ok := nodeStats[pod.Spec.NodeName][pod.Namespace+"/"+pod.Name]; ok {
+			sample.TxBytes, sample.HasTx = txBytes, true
+		}
+		samples[pod.Name] = sample
+	}
+	return samples, nil
+}
+
+func loadJobUsage(conn redis.Conn, jobUuid string) (*JobUsage, error) {
+	data, err := redis.Bytes(conn.Do("GET", jobUsageKey(jobUuid)))
+	if err != nil {
+		return nil, err
+	}
+	var usage JobUsage
+	if err = json.Unmarshal(data, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func saveJobUsage(conn redis.Conn, usage *JobUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("SET", jobUsageKey(usage.JobUuid), data, "EX", jobUsageRecordTTL)
+	return err
+}
+
+// GetJobUsage returns the usage metered for a job, with the signed summary once the job has ended.
+func GetJobUsage(c *gin.Context) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	usage, err := loadJobUsage(conn, c.Param("uuid"))
+	if err == redis.ErrNil {
+		c.JSON(http.StatusNotFound, common.CreateErrorResponse(strconv.Itoa(http.StatusNotFound), "no usage record for the job"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(usage))
+}
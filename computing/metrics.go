@@ -0,0 +1,292 @@
+package computing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	metricsNamespace = "lagrange_cp"
+
+	jobStateReceived     = "received"
+	jobStateDeclined     = "declined"
+	jobStateDeployed     = "deployed"
+	jobStateFailed       = "failed"
+	jobStateDeleted      = "deleted"
+	jobStateCompleted    = "completed"
+	jobStateRunCompleted = "run_completed"
+	jobStateRunFailed    = "run_failed"
+
+	deployKindDockerfile = "dockerfile"
+	deployKindYaml       = "yaml"
+
+	buildFailureDownload    = "download"
+	buildFailureBuild       = "build"
+	buildFailurePush        = "push"
+	buildFailureYaml        = "yaml"
+	buildFailureImagePolicy = "image_policy"
+
+	celeryQueueName       = "celery"
+	metricsCollectTimeout = 10 * time.Second
+	nodeResourcesInterval = 30 * time.Second
+)
+
+var (
+	jobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_total",
+		Help:      "Jobs that reached a state, by state.",
+	}, []string{"state"})
+	deployDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "deploy_duration_seconds",
+		Help:      "Time from picking a job off the queue to its Kubernetes resources being created.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800},
+	}, []string{"kind", "result"})
+	buildDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_duration_seconds",
+		Help:      "Time to build and push the image of a space.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 1200},
+	}, []string{"result"})
+	buildFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_failures_total",
+		Help:      "Jobs that failed before deploying, by reason.",
+	}, []string{"reason"})
+	heartbeatsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "heartbeats_total",
+		Help:      "Heartbeats sent to the LAD server, by result.",
+	}, []string{"result"})
+	ladRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "lad_request_duration_seconds",
+		Help:      "Latency of the requests to the LAD server, by path and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path", "code"})
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_errors_total",
+		Help:      "Failed Redis commands, by command.",
+	}, []string{"command"})
+	kubernetesErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_errors_total",
+		Help:      "Kubernetes API requests that failed or returned a server error, by verb and status code.",
+	}, []string{"verb", "code"})
+)
+
+// nodeResources caches the resources of the nodes between scrapes and autobid decisions.
+var nodeResources = NewNodeResourceCache(nodeResourcesInterval, func(ctx context.Context) ([]*models.NodeResource, error) {
+	return NewK8sService().StatisticalSources(ctx)
+})
+
+// NodeResourceCache keeps the resources of the nodes for an interval, so that frequent readers do not
+// list the nodes and pods of the cluster each time. Concurrent readers of an expired cache share one fetch.
+type NodeResourceCache struct {
+	interval  time.Duration
+	fetch     func(ctx context.Context) ([]*models.NodeResource, error)
+	mu        sync.Mutex
+	nodes     []*models.NodeResource
+	fetchedAt time.Time
+}
+
+func NewNodeResourceCache(interval time.Duration, fetch func(ctx context.Context) ([]*models.NodeResource, error)) *NodeResourceCache {
+	return &NodeResourceCache{interval: interval, fetch: fetch}
+}
+
+// Get returns the cached resources, fetching them when they are older than the interval. Errors are not cached.
+func (c *NodeResourceCache) Get(ctx context.Context) ([]*models.NodeResource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes != nil && time.Since(c.fetchedAt) < c.interval {
+		return c.nodes, nil
+	}
+	nodes, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.nodes, c.fetchedAt = nodes, time.Now()
+	return nodes, nil
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+func init() {
+	prometheus.MustRegister(new(providerCollector))
+}
+
+// RecordHeartbeat counts a heartbeat sent to the LAD server.
+func RecordHeartbeat(err error) {
+	heartbeatsTotal.WithLabelValues(metricResult(err)).Inc()
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+var ladHttpClient = &http.Client{Transport: &ladTransport{next: http.DefaultTransport}}
+
+// LadHttpClient returns the HTTP client shared by the requests to the LAD server, observing their latency.
+// It sets no timeout, the requests carry their own through the context.
+func LadHttpClient() *http.Client {
+	return ladHttpClient
+}
+
+type ladTransport struct {
+	next http.RoundTripper
+}
+
+func (t *ladTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	ladRequestDuration.WithLabelValues(req.URL.Path, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// metricsConn counts the failed commands of a Redis connection, a nil reply is not a failure.
+type metricsConn struct {
+	redis.Conn
+}
+
+func (c metricsConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(commandName, args...)
+	if err != nil && err != redis.ErrNil && commandName != "" {
+		redisErrors.WithLabelValues(strings.ToUpper(commandName)).Inc()
+	}
+	return reply, err
+}
+
+// wrapKubernetesTransport counts the Kubernetes API requests that failed or got a 5xx or 429 answer,
+// not found and conflict answers are part of the normal flow.
+func wrapKubernetesTransport(config *rest.Config) {
+	config.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return kubernetesTransport{next: next}
+	})
+}
+
+type kubernetesTransport struct {
+	next http.RoundTripper
+}
+
+func (t kubernetesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		kubernetesErrors.WithLabelValues(req.Method, "error").Inc()
+	} else if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		kubernetesErrors.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+var (
+	jobsDesc = prometheus.NewDesc(metricsNamespace+"_jobs",
+		"Deployments of jobs in the cluster, by state.", []string{"state"}, nil)
+	celeryQueueDesc = prometheus.NewDesc(metricsNamespace+"_celery_queue_depth",
+		"Deploy tasks waiting in the celery queue.", nil, nil)
+	nodeCpuDesc = prometheus.NewDesc(metricsNamespace+"_node_cpu_cores",
+		"Allocatable CPU cores of a node, by kind total, used or free.", []string{"node", "kind"}, nil)
+	nodeMemoryDesc = prometheus.NewDesc(metricsNamespace+"_node_memory_bytes",
+		"Allocatable memory of a node, by kind total, used or free.", []string{"node", "kind"}, nil)
+	nodeGpuDesc = prometheus.NewDesc(metricsNamespace+"_node_gpus",
+		"Allocatable GPUs of a node, by kind total, used or free.", []string{"node", "kind"}, nil)
+)
+
+// providerCollector reads the state of the cluster and of the queue when scraped.
+type providerCollector struct{}
+
+func (providerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{jobsDesc, celeryQueueDesc, nodeCpuDesc, nodeMemoryDesc, nodeGpuDesc} {
+		ch <- desc
+	}
+}
+
+func (providerCollector) Collect(ch chan<- prometheus.Metric) {
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("Failed collect metrics, error: %+v", err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+	defer cancel()
+
+	if redisPool != nil {
+		conn := redisPool.Get()
+		depth, err := redis.Int(conn.Do("LLEN", celeryQueueName))
+		conn.Close()
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(celeryQueueDesc, prometheus.GaugeValue, float64(depth))
+		}
+	}
+
+	k8sService := NewK8sService()
+	if clientSet == nil {
+		return
+	}
+	collectJobs(ctx, k8sService, ch)
+
+	nodes, err := nodeResources.Get(ctx)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed k8s statistical sources, error: %+v", err)
+		return
+	}
+	for _, node := range nodes {
+		for desc, values := range map[*prometheus.Desc][3]float64{
+			nodeCpuDesc:    {float64(node.Cpu.TotalValue) / 1000, float64(node.Cpu.UsedValue) / 1000, float64(node.Cpu.FreeValue) / 1000},
+			nodeMemoryDesc: {float64(node.Memory.TotalValue), float64(node.Memory.UsedValue), float64(node.Memory.FreeValue)},
+			nodeGpuDesc:    {float64(node.GpuAllocation.TotalValue), float64(node.GpuAllocation.UsedValue), float64(node.GpuAllocation.FreeValue)},
+		} {
+			for i, kind := range []string{"total", "used", "free"} {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, values[i], node.MachineId, kind)
+			}
+		}
+	}
+}
+
+// collectJobs counts the job deployments, running once all their replicas are available and pending before.
+func collectJobs(ctx context.Context, k8sService *K8sService, ch chan<- prometheus.Metric) {
+	deployments, err := k8sService.ListDeployments(ctx, "")
+	if err != nil {
+		logs.GetLogger().Errorf("Failed list deployments, error: %+v", err)
+		return
+	}
+	states := map[string]int{"running": 0, "pending": 0}
+	for _, deployment := range deployments {
+		if !strings.HasPrefix(deployment.Namespace, constants.K8S_NAMESPACE_NAME_PREFIX) {
+			continue
+		}
+		if deployment.Status.AvailableReplicas > 0 && deployment.Status.AvailableReplicas >= deployment.Status.Replicas {
+			states["running"]++
+		} else {
+			states["pending"]++
+		}
+	}
+	for state, count := range states {
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(count), state)
+	}
+}
@@ -0,0 +1,297 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultCurrency = "USD"
+	gpuItemPrefix   = "gpu:"
+)
+
+// QuoteReq asks the price of a hardware profile or of a deploy.yaml for a duration in seconds.
+type QuoteReq struct {
+	Hardware string `json:"hardware"`
+	Yaml     string `json:"yaml"`
+	Duration int    `json:"duration"`
+}
+
+// Quote is the price of a job, Hourly is the price of one hour and Total the price of the duration.
+type Quote struct {
+	Currency string      `json:"currency"`
+	Duration int         `json:"duration"`
+	Hourly   float64     `json:"hourly"`
+	Total    float64     `json:"total"`
+	Items    []QuoteItem `json:"items"`
+	Bid      BidDecision `json:"bid"`
+}
+
+// QuoteItem is the price of one resource of a job.
+type QuoteItem struct {
+	Resource string  `json:"resource"`
+	Quantity float64 `json:"quantity"`
+	Rate     float64 `json:"rate"`
+	Hourly   float64 `json:"hourly"`
+}
+
+// BidDecision tells whether the autobid policy accepts a job.
+type BidDecision struct {
+	Accept  bool     `json:"accept"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func pricingConfig() conf.Pricing {
+	var c conf.Pricing
+	if cfg := conf.GetConfig(); cfg != nil {
+		c = cfg.Pricing
+	}
+	if c.Currency == "" {
+		c.Currency = defaultCurrency
+	}
+	return c
+}
+
+// GpuHourRate returns the rate of the most specific GpuHour entry matching the model, so an
+// "A100 80GB" entry wins over an "A100" entry, and DefaultGpuHour when none matches.
+func GpuHourRate(c conf.Pricing, model string) float64 {
+	_, modelMemory := parseGpuModel(model)
+	rate, best := c.DefaultGpuHour, 0
+	for key, value := range c.GpuHour {
+		keyTokens, keyMemory := parseGpuModel(key)
+		if len(keyTokens) == 0 || !MatchGpuModel(key, model) {
+			continue
+		}
+		score := len(keyTokens)
+		if keyMemory > 0 {
+			if keyMemory != modelMemory {
+				continue
+			}
+			score++
+		}
+		if score > best {
+			rate, best = value, score
+		}
+	}
+	return rate
+}
+
+func newQuote(c conf.Pricing, duration int) *Quote {
+	return &Quote{Currency: c.Currency, Duration: duration, Items: make([]QuoteItem, 0)}
+}
+
+// Add prices a quantity of a resource at an hourly rate, resources the job does not use are left out.
+func (q *Quote) Add(resource string, quantity, rate float64) {
+	if quantity <= 0 {
+		return
+	}
+	item := QuoteItem{Resource: resource, Quantity: quantity, Rate: rate, Hourly: roundPrice(quantity * rate)}
+	q.Items = append(q.Items, item)
+	q.Hourly = roundPrice(q.Hourly + item.Hourly)
+	q.Total = roundPrice(q.Hourly * float64(q.Duration) / 3600)
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*1e6) / 1e6
+}
+
+// quoteHardware prices a hardware profile.
+func quoteHardware(hardware string, duration int) (*Quote, error) {
+	res, ok := common.HardwareResource[hardware]
+	if !ok {
+		return nil, fmt.Errorf("unknown hardware %q", hardware)
+	}
+	c := pricingConfig()
+	quote := newQuote(c, duration)
+	quote.Add("vcpu", float64(res.Cpu.Quantity), c.CpuHour)
+	quote.Add("memory_gib", float64(res.Memory.Quantity), c.MemoryHour)
+	if res.Gpu.Quantity > 0 {
+		quote.Add(gpuItemPrefix+res.Gpu.Description, float64(res.Gpu.Quantity), GpuHourRate(c, res.Gpu.Description))
+	}
+	return quote, nil
+}
+
+// quoteYaml prices the compute profiles of a deploy.yaml, the depending containers of a service
+// run with the limits of the service as they do when deployed.
+func quoteYaml(content string, duration int) (*Quote, error) {
+	containerResources, err := yaml.ParseYaml([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return quoteResources(containerResources, duration), nil
+}
+
+// quoteResources prices the parsed services of a deploy.yaml.
+func quoteResources(containerResources []yaml.ContainerResource, duration int) *Quote {
+	c := pricingConfig()
+	quote := newQuote(c, duration)
+	for _, resource := range containerResources {
+		containers := float64(1 + len(resource.Depends))
+		limits := resource.ResourceLimit
+		quote.Add("vcpu", containers*float64(quantityOf(limits, coreV1.ResourceCPU).MilliValue())/1000, c.CpuHour)
+		quote.Add("memory_gib", containers*float64(quantityOf(limits, coreV1.ResourceMemory).Value())/bytesPerGiB, c.MemoryHour)
+		for name, quantity := range limits {
+			if yaml.IsGpuResource(name) {
+				quote.Add(gpuItemPrefix+resource.GpuModel, containers*float64(quantity.Value()), GpuHourRate(c, resource.GpuModel+" "+resource.GpuSharing))
+			}
+		}
+	}
+	return quote
+}
+
+// clusterUtilization returns the percentage of allocatable CPU, memory and GPU requested by pods.
+func clusterUtilization() (cpu, memory, gpu float64, err error) {
+	nodes, err := nodeResources.Get(context.TODO())
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var used, total [3]int64
+	for _, node := range nodes {
+		for i, account := range []models.Common{node.Cpu, node.Memory, node.GpuAllocation} {
+			used[i] += account.UsedValue
+			total[i] += account.TotalValue
+		}
+	}
+	var percent [3]float64
+	for i := range percent {
+		if total[i] > 0 {
+			percent[i] = 100 * float64(used[i]) / float64(total[i])
+		}
+	}
+	return percent[0], percent[1], percent[2], nil
+}
+
+// decideBid applies the autobid policy of the configuration to a job.
+func decideBid(quote *Quote, offered float64, needsGpu bool) BidDecision {
+	return DecideBid(pricingConfig().Autobid, quote, offered, needsGpu, checkReady(), clusterUtilization)
+}
+
+// DecideBid applies an autobid policy: the provider has to be ready, the job has to pay at least MinJobPrice
+// and MinHourlyPrice, an offered price below the quote is declined, and the cluster must stay below the
+// utilization limits. utilization is only called when the policy limits it.
+func DecideBid(policy conf.Autobid, quote *Quote, offered float64, needsGpu bool, ready error, utilization func() (cpu, memory, gpu float64, err error)) BidDecision {
+	decision := BidDecision{Accept: true}
+	decline := func(format string, args ...interface{}) {
+		decision.Accept = false
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf(format, args...))
+	}
+	if ready != nil {
+		decline("%v", ready)
+	}
+	if !policy.Enable {
+		return decision
+	}
+
+	price := quote.Total
+	if offered > 0 {
+		if offered < quote.Total {
+			decline("offered price %.6f is below the quote %.6f", offered, quote.Total)
+		}
+		price = offered
+	}
+	if price < policy.MinJobPrice {
+		decline("job price %.6f is below the floor %.6f", price, policy.MinJobPrice)
+	}
+	if quote.Duration > 0 && price*3600/float64(quote.Duration) < policy.MinHourlyPrice {
+		decline("hourly price %.6f is below the floor %.6f", price*3600/float64(quote.Duration), policy.MinHourlyPrice)
+	}
+
+	if policy.MaxCpuUtilization > 0 || policy.MaxMemoryUtilization > 0 || (needsGpu && policy.MaxGpuUtilization > 0) {
+		cpu, memory, gpu, err := utilization()
+		if err != nil {
+			logs.GetLogger().Errorf("Failed get cluster utilization, error: %+v", err)
+			decline("cluster utilization is unknown")
+			return decision
+		}
+		if policy.MaxCpuUtilization > 0 && cpu >= policy.MaxCpuUtilization {
+			decline("cpu utilization %.1f%% reached the limit %.1f%%", cpu, policy.MaxCpuUtilization)
+		}
+		if policy.MaxMemoryUtilization > 0 && memory >= policy.MaxMemoryUtilization {
+			decline("memory utilization %.1f%% reached the limit %.1f%%", memory, policy.MaxMemoryUtilization)
+		}
+		if needsGpu && policy.MaxGpuUtilization > 0 && gpu >= policy.MaxGpuUtilization {
+			decline("gpu utilization %.1f%% reached the limit %.1f%%", gpu, policy.MaxGpuUtilization)
+		}
+	}
+	return decision
+}
+
+// autobidJob runs the autobid policy against an incoming hardware profile job. A job whose hardware is
+// not a profile runs a deploy.yaml, it is priced by autobidYaml once the deploy.yaml is fetched.
+func autobidJob(hardware string, duration int, offered float64) (BidDecision, error) {
+	if !pricingConfig().Autobid.Enable {
+		return BidDecision{Accept: true}, nil
+	}
+	if _, ok := common.HardwareResource[hardware]; !ok {
+		return BidDecision{Accept: true}, nil
+	}
+	quote, err := quoteHardware(hardware, duration)
+	if err != nil {
+		return BidDecision{}, err
+	}
+	return decideBid(quote, offered, common.HardwareResource[hardware].Gpu.Quantity > 0), nil
+}
+
+// autobidYaml runs the autobid policy against the services of a deploy.yaml, with the price offered
+// by the recorded submission of the job.
+func autobidYaml(jobUuid string, containerResources []yaml.ContainerResource, duration int) BidDecision {
+	if !pricingConfig().Autobid.Enable {
+		return BidDecision{Accept: true}
+	}
+	var offered float64
+	conn := redisPool.Get()
+	defer conn.Close()
+	if submission, err := FindJobSubmission(conn, jobUuid, ""); err != nil {
+		logs.GetLogger().Errorf("Failed get job submission, uuid: %s, error: %v", jobUuid, err)
+	} else if submission != nil {
+		offered = submission.Job.Price
+	}
+	return decideBid(quoteResources(containerResources, duration), offered, yaml.CountGpus(containerResources) > 0)
+}
+
+// QuoteJob prices a hardware profile or a deploy.yaml and tells whether the autobid policy would accept it.
+func QuoteJob(c *gin.Context) {
+	var quoteReq QuoteReq
+	if err := c.ShouldBindJSON(&quoteReq); err != nil {
+		c.JSON(http.StatusBadRequest, common.CreateErrorResponse(strconv.Itoa(http.StatusBadRequest), err.Error()))
+		return
+	}
+	if quoteReq.Duration <= 0 {
+		c.JSON(http.StatusBadRequest, common.CreateErrorResponse(strconv.Itoa(http.StatusBadRequest), "duration must be positive"))
+		return
+	}
+
+	var quote *Quote
+	var err error
+	switch {
+	case quoteReq.Yaml != "":
+		quote, err = quoteYaml(quoteReq.Yaml, quoteReq.Duration)
+	case quoteReq.Hardware != "":
+		quote, err = quoteHardware(quoteReq.Hardware, quoteReq.Duration)
+	default:
+		err = fmt.Errorf("hardware or yaml is required")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.CreateErrorResponse(strconv.Itoa(http.StatusBadRequest), err.Error()))
+		return
+	}
+
+	var needsGpu bool
+	for _, item := range quote.Items {
+		needsGpu = needsGpu || strings.HasPrefix(item.Resource, gpuItemPrefix)
+	}
+	quote.Bid = decideBid(quote, 0, needsGpu)
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(quote))
+}
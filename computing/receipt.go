@@ -0,0 +1,304 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/wallet"
+)
+
+// The reasons a job ends with.
+const (
+	TerminationDeleted    = "deleted"
+	TerminationExpired    = "expired"
+	TerminationRedeployed = "redeployed"
+	TerminationCompleted  = "completed"
+	TerminationFailed     = "failed"
+)
+
+const jobReceiptTTL = 365 * 24 * 3600
+
+// JobReceipt records what the provider delivered for a run of a job.
+type JobReceipt struct {
+	JobUuid           string            `json:"job_uuid"`
+	SpaceName         string            `json:"space_name"`
+	Wallet            string            `json:"wallet"`
+	Hardware          string            `json:"hardware"`
+	NodeId            string            `json:"node_id"`
+	StartedAt         int64             `json:"started_at"`
+	EndedAt           int64             `json:"ended_at"`
+	ImageDigests      map[string]string `json:"image_digests"`
+	ResultURI         string            `json:"result_uri"`
+	TerminationReason string            `json:"termination_reason"`
+}
+
+// SignedReceipt is a job receipt signed by the provider. Payload holds the exact signed bytes of the
+// receipt, the signature is an EIP-191 personal message signature recoverable to Provider.
+type SignedReceipt struct {
+	Payload   string `json:"payload"`
+	Provider  string `json:"provider"`
+	Signature string `json:"signature"`
+	// ReceiptURI is where the receipt was uploaded, it is not covered by the signature.
+	ReceiptURI string `json:"receipt_uri,omitempty"`
+}
+
+// ReceiptVerification is the result of checking a signed receipt.
+type ReceiptVerification struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+	// IssuedHere is set when the receipt was signed by the key of this provider.
+	IssuedHere bool        `json:"issued_here"`
+	Receipt    *JobReceipt `json:"receipt,omitempty"`
+}
+
+func jobReceiptKey(jobUuid string) string {
+	return constants.REDIS_JOB_RECEIPT_PREFIX + jobUuid
+}
+
+// openJobReceipt starts the receipt of a job deployed into a space, it is completed when the job is deleted.
+func openJobReceipt(jobUuid, creator, spaceName, hardware, resultURI string) {
+	if jobUuid == "" {
+		return
+	}
+	nodeId, _, _ := generateNodeID()
+	receipt := JobReceipt{
+		JobUuid:   jobUuid,
+		SpaceName: spaceName,
+		Wallet:    creator,
+		Hardware:  hardware,
+		NodeId:    nodeId,
+		StartedAt: time.Now().Unix(),
+		ResultURI: resultURI,
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(creator)
+	if _, err = conn.Do("HSET", constants.REDIS_JOB_RECEIPT_ACTIVE, jobUsageSpace(namespace, spaceName), data); err != nil {
+		logs.GetLogger().Errorf("Failed open job receipt, job: %s, error: %+v", jobUuid, err)
+	}
+}
+
+// setJobReceiptResult points the open receipt of the job running in a space to the result of the job.
+func setJobReceiptResult(namespace, spaceName, resultURI string) {
+	if resultURI == "" {
+		return
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	field := jobUsageSpace(namespace, spaceName)
+	data, err := redis.Bytes(conn.Do("HGET", constants.REDIS_JOB_RECEIPT_ACTIVE, field))
+	if err != nil {
+		return
+	}
+	var receipt JobReceipt
+	if err = json.Unmarshal(data, &receipt); err != nil {
+		return
+	}
+	receipt.ResultURI = resultURI
+	if data, err = json.Marshal(receipt); err == nil {
+		conn.Do("HSET", constants.REDIS_JOB_RECEIPT_ACTIVE, field, data)
+	}
+}
+
+// activeJobUuid returns the job running in a space, read from its open receipt, empty when it has none.
+func activeJobUuid(namespace, spaceName string) string {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", constants.REDIS_JOB_RECEIPT_ACTIVE, jobUsageSpace(namespace, spaceName)))
+	if err != nil {
+		return ""
+	}
+	var receipt JobReceipt
+	if err = json.Unmarshal(data, &receipt); err != nil {
+		return ""
+	}
+	return receipt.JobUuid
+}
+
+// closeJobReceipt completes, signs and uploads the receipt of the job running in a space. It runs before
+// the resources of the job are deleted, so the digests of the images that actually ran can be read.
+func closeJobReceipt(ctx context.Context, namespace, spaceName, reason string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	field := jobUsageSpace(namespace, spaceName)
+	data, err := redis.Bytes(conn.Do("HGET", constants.REDIS_JOB_RECEIPT_ACTIVE, field))
+	if err != nil {
+		return
+	}
+	conn.Do("HDEL", constants.REDIS_JOB_RECEIPT_ACTIVE, field)
+
+	var receipt JobReceipt
+	if err = json.Unmarshal(data, &receipt); err != nil {
+		logs.FromContext(ctx).Errorf("Failed read job receipt, error: %+v", err)
+		return
+	}
+	receipt.EndedAt = time.Now().Unix()
+	receipt.TerminationReason = reason
+	if receipt.ImageDigests, err = NewK8sService().GetPodImageDigests(ctx, namespace, spaceName); err != nil {
+		logs.FromContext(ctx).Warnf("Failed get image digests of job %s, error: %+v", receipt.JobUuid, err)
+	}
+
+	signed, err := signReceipt(&receipt)
+	if err != nil {
+		logs.FromContext(ctx).Errorf("Failed sign job receipt, job: %s, error: %+v", receipt.JobUuid, err)
+		return
+	}
+	if err = saveJobReceipt(conn, receipt.JobUuid, signed); err != nil {
+		logs.FromContext(ctx).Errorf("Failed save job receipt, job: %s, error: %+v", receipt.JobUuid, err)
+		return
+	}
+	logs.FromContext(ctx).Infof("Job %s receipt signed: %s", receipt.JobUuid, signed.Payload)
+
+	// the upload outlives the request deleting the job
+	go uploadJobReceipt(logs.WithJob(context.Background(), receipt.JobUuid, receipt.SpaceName, receipt.Wallet), &receipt, signed)
+}
+
+func signReceipt(receipt *JobReceipt) (*SignedReceipt, error) {
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	provider, signature, err := signData(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedReceipt{Payload: string(payload), Provider: provider, Signature: signature}, nil
+}
+
+// saveJobReceipt appends a receipt to the receipts of a job, a job redeployed under the same uuid has one per run.
+func saveJobReceipt(conn redis.Conn, jobUuid string, signed *SignedReceipt) error {
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return err
+	}
+	conn.Send("MULTI")
+	conn.Send("RPUSH", jobReceiptKey(jobUuid), data)
+	conn.Send("EXPIRE", jobReceiptKey(jobUuid), jobReceiptTTL)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func loadJobReceipts(conn redis.Conn, jobUuid string) ([]*SignedReceipt, error) {
+	values, err := redis.ByteSlices(conn.Do("LRANGE", jobReceiptKey(jobUuid), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	receipts := make([]*SignedReceipt, 0, len(values))
+	for _, value := range values {
+		var signed SignedReceipt
+		if err = json.Unmarshal(value, &signed); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, &signed)
+	}
+	return receipts, nil
+}
+
+// uploadJobReceipt uploads a signed receipt to the storage bucket and records where it was uploaded.
+func uploadJobReceipt(ctx context.Context, receipt *JobReceipt, signed *SignedReceipt) {
+	objectName := filepath.Join("receipts", fmt.Sprintf("%s-%d.json", receipt.JobUuid, receipt.StartedAt))
+	filePath := filepath.Join(conf.GetConfig().MCS.FileCachePath, objectName)
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		logs.FromContext(ctx).Errorf("Failed create receipt directory, error: %+v", err)
+		return
+	}
+	if err = os.WriteFile(filePath, data, 0644); err != nil {
+		logs.FromContext(ctx).Errorf("Failed write job receipt, error: %+v", err)
+		return
+	}
+
+	object, err := uploadFile(ctx, objectName, filePath)
+	if err != nil {
+		logs.FromContext(ctx).Errorf("Failed upload job receipt %s, error: %+v", objectName, err)
+		return
+	}
+	signed.ReceiptURI = object.URI
+	updateJobReceiptURI(receipt.JobUuid, signed)
+	logs.FromContext(ctx).Infof("Job %s receipt uploaded to %s", receipt.JobUuid, signed.ReceiptURI)
+}
+
+func updateJobReceiptURI(jobUuid string, signed *SignedReceipt) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	receipts, err := loadJobReceipts(conn, jobUuid)
+	if err != nil {
+		return
+	}
+	for i, stored := range receipts {
+		if stored.Signature != signed.Signature {
+			continue
+		}
+		data, _ := json.Marshal(signed)
+		if _, err = conn.Do("LSET", jobReceiptKey(jobUuid), i, data); err != nil {
+			logs.GetLogger().Errorf("Failed update job receipt, job: %s, error: %+v", jobUuid, err)
+		}
+		return
+	}
+}
+
+// VerifyReceipt checks that a receipt was signed by the provider it names.
+func VerifyReceipt(signed *SignedReceipt) ReceiptVerification {
+	if err := wallet.Verify(signed.Provider, []byte(signed.Payload), signed.Signature); err != nil {
+		return ReceiptVerification{Error: err.Error()}
+	}
+	var receipt JobReceipt
+	if err := json.Unmarshal([]byte(signed.Payload), &receipt); err != nil {
+		return ReceiptVerification{Error: "payload is not a job receipt: " + err.Error()}
+	}
+	return ReceiptVerification{Valid: true, Receipt: &receipt}
+}
+
+// GetJobReceipts returns the signed receipts of the runs of a job.
+func GetJobReceipts(c *gin.Context) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	receipts, err := loadJobReceipts(conn, c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+	if len(receipts) == 0 {
+		c.JSON(http.StatusNotFound, common.CreateErrorResponse(strconv.Itoa(http.StatusNotFound), "no receipt for the job"))
+		return
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(receipts))
+}
+
+// VerifyJobReceipt checks the signature of a receipt posted by anyone holding it.
+func VerifyJobReceipt(c *gin.Context) {
+	var signed SignedReceipt
+	if err := c.ShouldBindJSON(&signed); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	verification := VerifyReceipt(&signed)
+	verification.IssuedHere = verification.Valid && strings.EqualFold(signed.Provider, providerIdentity().Address())
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(verification))
+}
@@ -3,11 +3,14 @@ package computing
 import (
 	"context"
 	"fmt"
+	"strconv"
+
 	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/yaml"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"strconv"
 )
 
 const (
@@ -18,99 +21,170 @@ const (
 	Nvidia_Gpu_Num string = "nvidia.com/gpu"
 	Cpu_Model      string = "feature.node.kubernetes.io/cpu-model.vendor_id"
 	Arch_Model     string = "beta.kubernetes.io/arch"
+
+	unitMillicore = "millicore"
+	unitByte      = "byte"
+	unitGpu       = "gpu"
 )
 
-func allActivePods(clientSet *kubernetes.Clientset) ([]corev1.Pod, error) {
+// allActivePods returns the pods holding node resources, running pods and pending pods already bound to a node.
+func allActivePods(clientSet kubernetes.Interface) ([]corev1.Pod, error) {
 	allPods, err := clientSet.CoreV1().Pods("").List(context.TODO(), metaV1.ListOptions{
-		FieldSelector: "status.phase=Running",
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
 	})
 	if err != nil {
 		return nil, err
 	}
-	return allPods.Items, nil
+	var pods []corev1.Pod
+	for _, pod := range allPods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed || pod.Spec.NodeName == "" {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
 }
 
+// getNodeResource accounts the allocatable resources of a node against the requests of its pods.
+// CPU is counted in millicores, memory and storage in bytes and GPUs in whole devices.
 func getNodeResource(allPods []corev1.Pod, node *corev1.Node) (*models.NodeResource, error) {
-	var (
-		usedCpu     int64
-		usedMem     int64
-		usedStorage int64
-	)
-
 	var nodeResource = new(models.NodeResource)
 	nodeResource.MachineId = node.Status.NodeInfo.MachineID
 	nodeResource.Model = node.Labels[Arch_Model]
 
+	used := corev1.ResourceList{}
 	for _, pod := range getPodsFromNode(allPods, node) {
-		usedCpu += cpuInPod(&pod)
-		usedMem += memInPod(&pod)
-		usedStorage += storageInPod(&pod)
+		for name, quantity := range effectivePodRequests(&pod) {
+			sum := used[name]
+			sum.Add(quantity)
+			used[name] = sum
+		}
 	}
 
-	nodeResource.Cpu.Total = strconv.FormatInt(node.Status.Capacity.Cpu().Value(), 10)
-	nodeResource.Cpu.Used = strconv.FormatInt(usedCpu, 10)
-	nodeResource.Cpu.Free = strconv.FormatInt(node.Status.Capacity.Cpu().Value()-usedCpu, 10)
-
-	nodeResource.Vcpu.Total = nodeResource.Cpu.Total
-	nodeResource.Vcpu.Used = nodeResource.Cpu.Used
-	nodeResource.Vcpu.Free = nodeResource.Cpu.Free
-
-	nodeResource.Memory.Total = fmt.Sprintf("%.2f GiB", float64(node.Status.Allocatable.Memory().Value()/1024/1024/1024))
-	nodeResource.Memory.Used = fmt.Sprintf("%.2f GiB", float64(usedMem/1024/1024/1024))
-	freeMemory := node.Status.Capacity.Memory().Value() - usedMem
-	nodeResource.Memory.Free = fmt.Sprintf("%.2f GiB", float64(freeMemory/1024/1024/1024))
-
-	nodeResource.Storage.Total = fmt.Sprintf("%.2f GiB", float64(node.Status.Allocatable.StorageEphemeral().Value()/1024/1024/1024))
-	nodeResource.Storage.Used = fmt.Sprintf("%.2f GiB", float64(usedStorage/1024/1024/1024))
-	freeStorage := node.Status.Allocatable.Storage().Value() - usedStorage
-	nodeResource.Storage.Free = fmt.Sprintf("%.2f GiB", float64(freeStorage/1024/1024/1024))
+	allocatable := node.Status.Allocatable
+	nodeResource.Cpu = cpuAccount(allocatable.Cpu().MilliValue(), quantityOf(used, corev1.ResourceCPU).MilliValue())
+	nodeResource.Vcpu = nodeResource.Cpu
+	nodeResource.Memory = byteAccount(allocatable.Memory().Value(), quantityOf(used, corev1.ResourceMemory).Value())
+	nodeResource.Storage = byteAccount(allocatable.StorageEphemeral().Value(), quantityOf(used, corev1.ResourceEphemeralStorage).Value())
 
+	var totalGpu, usedGpu int64
+	for _, name := range []corev1.ResourceName{yaml.NvidiaGpuResource, yaml.AmdGpuResource} {
+		totalGpu += quantityOf(allocatable, name).Value()
+		usedGpu += quantityOf(used, name).Value()
+	}
+	nodeResource.GpuAllocation = gpuAccount(totalGpu, usedGpu)
 	return nodeResource, nil
 }
 
-func getPodsFromNode(allPods []corev1.Pod, node *corev1.Node) (pods []corev1.Pod) {
-	for _, pod := range allPods {
-		if pod.Spec.NodeName == node.Name {
-			pods = append(pods, pod)
+// effectivePodRequests returns what the scheduler reserves for a pod: per resource the larger of the
+// summed app containers and the largest init container, plus the pod overhead. Extended resources
+// such as GPUs may only set limits, which then act as requests.
+func effectivePodRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range containerRequests(container) {
+			sum := requests[name]
+			sum.Add(quantity)
+			requests[name] = sum
 		}
 	}
-	return pods
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range containerRequests(container) {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	for name, quantity := range pod.Spec.Overhead {
+		sum := requests[name]
+		sum.Add(quantity)
+		requests[name] = sum
+	}
+	return requests
 }
 
-func storageInPod(pod *corev1.Pod) (storageUsed int64) {
-	containers := pod.Spec.Containers
-	for _, container := range containers {
-		val, ok := container.Resources.Requests[corev1.ResourceEphemeralStorage]
-		if !ok {
-			continue
+func containerRequests(container corev1.Container) corev1.ResourceList {
+	requests := container.Resources.Requests.DeepCopy()
+	if requests == nil {
+		requests = corev1.ResourceList{}
+	}
+	for name, quantity := range container.Resources.Limits {
+		if _, ok := requests[name]; !ok {
+			requests[name] = quantity.DeepCopy()
 		}
-		storageUsed += val.Value()
 	}
-	return storageUsed
+	return requests
 }
 
-func cpuInPod(pod *corev1.Pod) (cpuCount int64) {
-	containers := pod.Spec.Containers
-	for _, container := range containers {
-		val, ok := container.Resources.Requests[corev1.ResourceCPU]
-		if !ok {
-			continue
-		}
-		cpuCount += val.Value()
+func quantityOf(list corev1.ResourceList, name corev1.ResourceName) *resource.Quantity {
+	if quantity, ok := list[name]; ok {
+		return &quantity
 	}
-	return cpuCount
+	return resource.NewQuantity(0, resource.DecimalSI)
 }
 
-func memInPod(pod *corev1.Pod) (memCount int64) {
-	containers := pod.Spec.Containers
-	for _, container := range containers {
-		val, ok := container.Resources.Requests[corev1.ResourceMemory]
-		if !ok {
-			continue
+func freeOf(total, used int64) int64 {
+	if used > total {
+		return 0
+	}
+	return total - used
+}
+
+func cpuAccount(total, used int64) models.Common {
+	free := freeOf(total, used)
+	return models.Common{
+		Total:      formatCores(total),
+		Used:       formatCores(used),
+		Free:       formatCores(free),
+		Unit:       unitMillicore,
+		TotalValue: total,
+		UsedValue:  used,
+		FreeValue:  free,
+	}
+}
+
+func byteAccount(total, used int64) models.Common {
+	free := freeOf(total, used)
+	return models.Common{
+		Total:      formatGiB(total),
+		Used:       formatGiB(used),
+		Free:       formatGiB(free),
+		Unit:       unitByte,
+		TotalValue: total,
+		UsedValue:  used,
+		FreeValue:  free,
+	}
+}
+
+func gpuAccount(total, used int64) models.Common {
+	free := freeOf(total, used)
+	return models.Common{
+		Total:      strconv.FormatInt(total, 10),
+		Used:       strconv.FormatInt(used, 10),
+		Free:       strconv.FormatInt(free, 10),
+		Unit:       unitGpu,
+		TotalValue: total,
+		UsedValue:  used,
+		FreeValue:  free,
+	}
+}
+
+// formatCores formats millicores as cores, 500 becomes "0.5" and 4000 becomes "4".
+func formatCores(milli int64) string {
+	return strconv.FormatFloat(float64(milli)/1000, 'f', -1, 64)
+}
+
+func formatGiB(bytes int64) string {
+	return fmt.Sprintf("%.2f GiB", float64(bytes)/1024/1024/1024)
+}
+
+func getPodsFromNode(allPods []corev1.Pod, node *corev1.Node) (pods []corev1.Pod) {
+	for _, pod := range allPods {
+		if pod.Spec.NodeName == node.Name {
+			pods = append(pods, pod)
 		}
-		memCount += val.Value()
 	}
-	return memCount
+	return pods
 }
 
 func GetNodeRole(node *corev1.Node) string {
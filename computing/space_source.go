@@ -0,0 +1,368 @@
+package computing
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+const defaultIpfsGateway = "https://ipfs.io"
+
+var (
+	commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+	// cidPattern matches CIDv0 and CIDv1 in their base58 and base32 forms
+	cidPattern = regexp.MustCompile(`^[A-Za-z0-9]{32,128}$`)
+	// namePattern restricts creators, space names and ipfs path segments to names usable as a directory
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+)
+
+// SpaceSource fetches the files of a space from the location named by a job source URI.
+type SpaceSource interface {
+	// SpaceName returns the creator wallet and the space name of the job.
+	SpaceName() (string, string, error)
+	// Fetch downloads the space files under buildFolder and returns the directory holding them.
+	Fetch(ctx context.Context, buildFolder string) (string, error)
+}
+
+// NewSpaceSource selects the source implementation by the scheme of jobSourceURI:
+//   - http(s)://.../spaces/<creator>/<name>         Lagrange Space API
+//   - ipfs://<cid>?creator=<wallet>&space=<name>     IPFS through the configured gateway
+//   - git+https://host/repo.git?creator=<wallet>&space=<name>#<commit>
+func NewSpaceSource(jobSourceURI string) (SpaceSource, error) {
+	parsedURL, err := url.Parse(jobSourceURI)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(parsedURL.Scheme) {
+	case "http", "https":
+		return &lagrangeSpaceSource{uri: jobSourceURI}, nil
+	case "ipfs":
+		gateway := defaultIpfsGateway
+		if conf.GetConfig() != nil && conf.GetConfig().Source.IpfsGateway != "" {
+			gateway = conf.GetConfig().Source.IpfsGateway
+		}
+		return &ipfsSpaceSource{uri: parsedURL, gateway: strings.TrimSuffix(gateway, "/")}, nil
+	case "git+https", "git+http", "git+ssh":
+		return &gitSpaceSource{uri: parsedURL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported job source scheme: %q", parsedURL.Scheme)
+	}
+}
+
+// resolveSpaceName returns the creator wallet and space name for any supported job source URI.
+func resolveSpaceName(jobSourceURI string) (string, string, error) {
+	source, err := NewSpaceSource(jobSourceURI)
+	if err != nil {
+		return "", "", err
+	}
+	return source.SpaceName()
+}
+
+// querySpaceName reads the creator and space query parameters used by content-addressed sources.
+func querySpaceName(u *url.URL, defaultName string) (string, string, error) {
+	creator := u.Query().Get("creator")
+	if creator == "" {
+		return "", "", errors.New("the creator query parameter is required")
+	}
+	spaceName := u.Query().Get("space")
+	if spaceName == "" {
+		spaceName = defaultName
+	}
+	if spaceName == "" {
+		return "", "", errors.New("the space query parameter is required")
+	}
+	if !namePattern.MatchString(creator) {
+		return "", "", fmt.Errorf("invalid creator: %q", creator)
+	}
+	if !namePattern.MatchString(spaceName) {
+		return "", "", fmt.Errorf("invalid space name: %q", spaceName)
+	}
+	return creator, spaceName, nil
+}
+
+// inDir reports whether target is a path strictly inside dir.
+func inDir(dir, target string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(target))
+	if err != nil || rel == "." || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+type lagrangeSpaceSource struct {
+	uri string
+}
+
+func (s *lagrangeSpaceSource) SpaceName() (string, string, error) {
+	return getSpaceName(s.uri)
+}
+
+func (s *lagrangeSpaceSource) Fetch(ctx context.Context, buildFolder string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.uri, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error making request to Space API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	logs.GetLogger().Infof("Space API response received. Response: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("space API response not OK. Status Code: %d", resp.StatusCode)
+	}
+
+	var spaceJSON struct {
+		Data struct {
+			Files []struct {
+				Name string `json:"name"`
+				URL  string `json:"url"`
+			} `json:"files"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spaceJSON); err != nil {
+		return "", fmt.Errorf("error decoding Space API response JSON: %v", err)
+	}
+
+	files := spaceJSON.Data.Files
+	if len(files) == 0 {
+		return "", NotFoundError
+	}
+
+	// the names come from the server, none is downloaded unless all stay in the build folder
+	for _, file := range files {
+		name := filepath.FromSlash(file.Name)
+		if filepath.IsAbs(name) || !inDir(buildFolder, filepath.Join(buildFolder, name)) {
+			return "", fmt.Errorf("illegal file path in space: %s", file.Name)
+		}
+	}
+
+	downloadSpacePath := filepath.FromSlash(files[0].Name)
+	for _, file := range files {
+		target := filepath.Join(buildFolder, filepath.FromSlash(file.Name))
+		if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return "", err
+		}
+		if err = downloadFile(target, file.URL); err != nil {
+			return "", fmt.Errorf("error downloading file: %w", err)
+		}
+	}
+	return filepath.Join(buildFolder, filepath.Dir(downloadSpacePath)), nil
+}
+
+type ipfsSpaceSource struct {
+	uri     *url.URL
+	gateway string
+}
+
+// cidPath returns "<cid>[/sub/path]" from ipfs://<cid>/sub/path, rejecting anything but a cid followed by plain names.
+func (s *ipfsSpaceSource) cidPath() (string, error) {
+	if s.uri.Host == "" {
+		return "", errors.New("invalid ipfs URI, missing cid")
+	}
+	if !cidPattern.MatchString(s.uri.Host) {
+		return "", fmt.Errorf("invalid ipfs URI, malformed cid: %q", s.uri.Host)
+	}
+	segments := []string{s.uri.Host}
+	for _, segment := range strings.Split(s.uri.Path, "/") {
+		if segment == "" {
+			continue
+		}
+		if !namePattern.MatchString(segment) {
+			return "", fmt.Errorf("invalid ipfs URI, illegal path segment: %q", segment)
+		}
+		segments = append(segments, segment)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+func (s *ipfsSpaceSource) SpaceName() (string, string, error) {
+	if _, err := s.cidPath(); err != nil {
+		return "", "", err
+	}
+	return querySpaceName(s.uri, s.uri.Host)
+}
+
+func (s *ipfsSpaceSource) Fetch(ctx context.Context, buildFolder string) (string, error) {
+	cidPath, err := s.cidPath()
+	if err != nil {
+		return "", err
+	}
+	destDir := filepath.Join(buildFolder, "ipfs", filepath.FromSlash(cidPath))
+	if !inDir(buildFolder, destDir) {
+		return "", fmt.Errorf("ipfs path %s escapes the build folder", cidPath)
+	}
+
+	gatewayURL := fmt.Sprintf("%s/ipfs/%s?format=tar", s.gateway, cidPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gatewayURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/x-tar")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error making request to ipfs gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", NotFoundError
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ipfs gateway response not OK. url: %s, status code: %d", gatewayURL, resp.StatusCode)
+	}
+
+	if err = os.RemoveAll(destDir); err != nil {
+		return "", err
+	}
+	if err = Untar(resp.Body, filepath.Dir(destDir)); err != nil {
+		return "", fmt.Errorf("error extracting ipfs archive: %w", err)
+	}
+
+	// the gateway names the archive root after the last path segment
+	if _, err = os.Stat(destDir); err != nil {
+		return "", fmt.Errorf("ipfs archive does not contain %s: %w", cidPath, err)
+	}
+	return destDir, nil
+}
+
+type gitSpaceSource struct {
+	uri *url.URL
+}
+
+// repoURL strips the git+ prefix, the query and the fragment so the URL can be handed to git.
+func (s *gitSpaceSource) repoURL() string {
+	repo := *s.uri
+	repo.Scheme = strings.TrimPrefix(strings.ToLower(repo.Scheme), "git+")
+	repo.RawQuery = ""
+	repo.Fragment = ""
+	return repo.String()
+}
+
+func (s *gitSpaceSource) commit() (string, error) {
+	commit := s.uri.Fragment
+	if !commitPattern.MatchString(commit) {
+		return "", fmt.Errorf("git source must be pinned to a commit hash, got: %q", commit)
+	}
+	return strings.ToLower(commit), nil
+}
+
+func (s *gitSpaceSource) SpaceName() (string, string, error) {
+	repoName := strings.TrimSuffix(path.Base(s.uri.Path), ".git")
+	if repoName == "." || repoName == "/" {
+		repoName = ""
+	}
+	return querySpaceName(s.uri, repoName)
+}
+
+func (s *gitSpaceSource) Fetch(ctx context.Context, buildFolder string) (string, error) {
+	commit, err := s.commit()
+	if err != nil {
+		return "", err
+	}
+	creator, spaceName, err := s.SpaceName()
+	if err != nil {
+		return "", err
+	}
+
+	destDir := filepath.Join(buildFolder, "git", creator, spaceName, commit)
+	if !inDir(buildFolder, destDir) {
+		return "", fmt.Errorf("git source %s/%s escapes the build folder", creator, spaceName)
+	}
+	if err = os.RemoveAll(destDir); err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	repoURL := s.repoURL()
+	if err = runGit(ctx, destDir, "init", "-q"); err != nil {
+		return "", err
+	}
+	if err = runGit(ctx, destDir, "remote", "add", "origin", repoURL); err != nil {
+		return "", err
+	}
+	// fetching a single commit needs uploadpack.allowReachableSHA1InWant on the server, fall back to a full fetch
+	if err = runGit(ctx, destDir, "fetch", "-q", "--depth", "1", "origin", commit); err != nil {
+		logs.GetLogger().Warnf("Shallow fetch of commit %s failed, fetching the whole repository: %v", commit, err)
+		if err = runGit(ctx, destDir, "fetch", "-q", "origin"); err != nil {
+			return "", err
+		}
+	}
+	if err = runGit(ctx, destDir, "checkout", "-q", commit); err != nil {
+		return "", err
+	}
+	if err = os.RemoveAll(filepath.Join(destDir, ".git")); err != nil {
+		return "", err
+	}
+	return destDir, nil
+}
+
+func runGit(ctx context.Context, dir string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %v, %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Untar extracts a tar stream under destDir, rejecting entries that would be written outside of it.
+func Untar(rd io.Reader, destDir string) error {
+	tr := tar.NewReader(rd)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if !inDir(destDir, target) {
+			return fmt.Errorf("illegal file path in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode)|0600)
+			if err != nil {
+				return err
+			}
+			if _, err = io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			out.Close()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+const (
+	StorageMcs   = "mcs"
+	StorageS3    = "s3"
+	StorageLocal = "local"
+
+	defaultStorageRetries = 3
+	storageRetryWait      = time.Second
+	// jobDetailUploadWait is how long a job submission waits for the upload of the job detail.
+	jobDetailUploadWait = 5 * time.Second
+)
+
+// Storage keeps the files the provider publishes, job details, receipts and job results.
+type Storage interface {
+	// Upload stores the file under objectName, replacing an object of the same name.
+	Upload(ctx context.Context, objectName, filePath string) (*StoredObject, error)
+	// Ping checks that the storage can be reached with the configured credentials.
+	Ping(ctx context.Context) error
+}
+
+// StoredObject is a file kept by a storage, URI is where it can be fetched from.
+type StoredObject struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+	// Cid is the IPFS content id, set by the storages backed by IPFS.
+	Cid  string `json:"cid,omitempty"`
+	Size int64  `json:"size"`
+}
+
+var (
+	jobStorageOnce sync.Once
+	jobStorageImpl Storage
+	jobStorageErr  error
+)
+
+func storageConfig() conf.Storage {
+	c := conf.GetConfig().Storage
+	if c.Backend == "" {
+		c.Backend = StorageMcs
+	}
+	if c.Retries <= 0 {
+		c.Retries = defaultStorageRetries
+	}
+	return c
+}
+
+// jobStorage returns the storage chosen by the config, its uploads are retried.
+func jobStorage() (Storage, error) {
+	jobStorageOnce.Do(func() {
+		c := storageConfig()
+		var storage Storage
+		switch c.Backend {
+		case StorageMcs:
+			storage = NewStorageService()
+		case StorageS3:
+			storage, jobStorageErr = NewS3Storage(c.S3)
+		case StorageLocal:
+			storage, jobStorageErr = NewLocalStorage(c.Local.Dir, c.Local.PublicUrl)
+		default:
+			jobStorageErr = fmt.Errorf("unknown storage backend %q, use %s, %s or %s", c.Backend, StorageMcs, StorageS3, StorageLocal)
+		}
+		if jobStorageErr == nil {
+			jobStorageImpl = NewRetryStorage(storage, c.Retries, storageRetryWait)
+		}
+	})
+	return jobStorageImpl, jobStorageErr
+}
+
+// uploadFile uploads a file to the configured storage.
+func uploadFile(ctx context.Context, objectName, filePath string) (*StoredObject, error) {
+	storage, err := jobStorage()
+	if err != nil {
+		return nil, err
+	}
+	return storage.Upload(ctx, objectName, filePath)
+}
+
+type retryStorage struct {
+	Storage
+	retries int
+	wait    time.Duration
+}
+
+// NewRetryStorage retries the failed uploads of storage, waiting wait before the first retry and
+// doubling it for each following one.
+func NewRetryStorage(storage Storage, retries int, wait time.Duration) Storage {
+	return &retryStorage{Storage: storage, retries: retries, wait: wait}
+}
+
+func (s *retryStorage) Upload(ctx context.Context, objectName, filePath string) (*StoredObject, error) {
+	wait := s.wait
+	for i := 0; ; i++ {
+		object, err := s.Storage.Upload(ctx, objectName, filePath)
+		if err == nil || i >= s.retries {
+			return object, err
+		}
+		logs.FromContext(ctx).Warnf("Failed upload %s, retrying in %s, error: %v", objectName, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
@@ -0,0 +1,83 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps the files in a directory, for providers serving them with their own web server.
+type LocalStorage struct {
+	dir       string
+	publicUrl string
+}
+
+// NewLocalStorage returns a storage keeping the files under dir. The URI of a file is publicUrl joined
+// with its object name, or a file URI when publicUrl is empty.
+func NewLocalStorage(dir, publicUrl string) (*LocalStorage, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("the directory of the local storage is not set")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStorage{dir: dir, publicUrl: strings.TrimSuffix(publicUrl, "/")}, nil
+}
+
+func (s *LocalStorage) Upload(ctx context.Context, objectName, filePath string) (*StoredObject, error) {
+	objectName = path.Clean("/" + filepath.ToSlash(objectName))[1:]
+	target := filepath.Join(s.dir, filepath.FromSlash(objectName))
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+
+	// write aside and rename, so a reader never sees a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), target); err != nil {
+		return nil, err
+	}
+
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(target)}).String()
+	if s.publicUrl != "" {
+		uri = s.publicUrl + "/" + objectName
+	}
+	return &StoredObject{Name: objectName, URI: uri, Size: size}, nil
+}
+
+func (s *LocalStorage) Ping(ctx context.Context) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(s.dir, ".ping")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
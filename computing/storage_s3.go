@@ -0,0 +1,79 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Storage keeps the files in a bucket of an S3 compatible object store, AWS S3, MinIO or others.
+type S3Storage struct {
+	client    *minio.Client
+	bucket    string
+	publicUrl string
+}
+
+// NewS3Storage returns the storage of the configured bucket. The URI of a file is PublicUrl joined with
+// its object name, or the path style URL of the object on the endpoint when PublicUrl is empty.
+func NewS3Storage(c conf.S3Storage) (*S3Storage, error) {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return nil, fmt.Errorf("the endpoint and bucket of the s3 storage are required")
+	}
+	client, err := minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure: c.UseSSL,
+		Region: c.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicUrl := strings.TrimSuffix(c.PublicUrl, "/")
+	if publicUrl == "" {
+		endpoint := *client.EndpointURL()
+		endpoint.Path = "/" + c.Bucket
+		publicUrl = endpoint.String()
+	}
+	return &S3Storage{client: client, bucket: c.Bucket, publicUrl: publicUrl}, nil
+}
+
+func (s *S3Storage) Upload(ctx context.Context, objectName, filePath string) (*StoredObject, error) {
+	objectName = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(objectName, "\\", "/")), "/")
+	info, err := s.client.FPutObject(ctx, s.bucket, objectName, filePath, minio.PutObjectOptions{
+		ContentType: contentType(objectName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	escaped := (&url.URL{Path: objectName}).EscapedPath()
+	return &StoredObject{Name: objectName, URI: s.publicUrl + "/" + escaped, Size: info.Size}, nil
+}
+
+func (s *S3Storage) Ping(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", s.bucket)
+	}
+	return nil
+}
+
+func contentType(objectName string) string {
+	switch path.Ext(objectName) {
+	case ".json":
+		return "application/json"
+	case ".tar":
+		return "application/x-tar"
+	case ".tgz", ".gz":
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
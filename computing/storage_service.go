@@ -1,24 +1,35 @@
 package computing
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/filswan/go-mcs-sdk/mcs/api/bucket"
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/filswan/go-mcs-sdk/mcs/api/user"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	"github.com/lagrangedao/go-computing-provider/conf"
 )
 
+// mcsLoginTTL is how long a login is reused, the MCS tokens outlive it.
+const mcsLoginTTL = 30 * time.Minute
+
 var storage *StorageService
 var storageOnce sync.Once
 
+// StorageService keeps the files in a bucket of MCS, they are pinned to IPFS.
 type StorageService struct {
 	McsApiKey      string `json:"mcs_api_key"`
 	McsAccessToken string `json:"mcs_access_token"`
 	NetWork        string `json:"net_work"`
 	BucketName     string `json:"bucket_name"`
-	mcsClient      *user.McsClient
+
+	mu         sync.Mutex
+	mcsClient  *user.McsClient
+	loggedInAt time.Time
+	gatewayUrl string
 }
 
 func NewStorageService() *StorageService {
@@ -30,21 +41,50 @@ func NewStorageService() *StorageService {
 			BucketName:     conf.GetConfig().MCS.BucketName,
 		}
 	})
+	return storage
+}
+
+// client returns the logged in MCS client, logging in again once the login is older than mcsLoginTTL.
+func (storage *StorageService) client() (*user.McsClient, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	if storage.mcsClient != nil && time.Since(storage.loggedInAt) < mcsLoginTTL {
+		return storage.mcsClient, nil
+	}
 	mcsClient, err := user.LoginByApikey(storage.McsApiKey, storage.McsAccessToken, storage.NetWork)
 	if err != nil {
-		logs.GetLogger().Errorf("Failed creating mcsClient, error: %v", err)
-		return nil
+		return nil, fmt.Errorf("failed login to mcs: %w", err)
 	}
-	storage.mcsClient = mcsClient
-	return storage
+	storage.mcsClient, storage.loggedInAt = mcsClient, time.Now()
+	return mcsClient, nil
+}
+
+// logout drops the login after a failed request, the token may have been revoked.
+func (storage *StorageService) logout() {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.mcsClient = nil
+}
+
+func (storage *StorageService) bucketClient() (*bucket.BucketClient, error) {
+	mcsClient, err := storage.client()
+	if err != nil {
+		return nil, err
+	}
+	return bucket.GetBucketClient(*mcsClient), nil
 }
 
 func (storage *StorageService) UploadFileToBucket(objectName, filePath string, replace bool) (*bucket.OssFile, error) {
 	logs.GetLogger().Infof("uploading file to bucket, objectName: %s, filePath: %s", objectName, filePath)
-	buketClient := bucket.GetBucketClient(*storage.mcsClient)
+	buketClient, err := storage.bucketClient()
+	if err != nil {
+		return nil, err
+	}
 
 	file, err := buketClient.GetFile(storage.BucketName, objectName)
 	if err != nil && !strings.Contains(err.Error(), "record not found") {
+		storage.logout()
 		logs.GetLogger().Errorf("Failed get file form bucket, error: %v", err)
 		return nil, err
 	}
@@ -57,6 +97,7 @@ func (storage *StorageService) UploadFileToBucket(objectName, filePath string, r
 	}
 
 	if err := buketClient.UploadFile(storage.BucketName, objectName, filePath, replace); err != nil {
+		storage.logout()
 		logs.GetLogger().Errorf("Failed upload file to bucket, error: %v", err)
 		return nil, err
 	}
@@ -69,26 +110,85 @@ func (storage *StorageService) UploadFileToBucket(objectName, filePath string, r
 	return mcsOssFile, nil
 }
 
+// Upload uploads the file to the bucket, its URI is the IPFS gateway URL of its content.
+func (storage *StorageService) Upload(ctx context.Context, objectName, filePath string) (*StoredObject, error) {
+	ossFile, err := storage.UploadFileToBucket(objectName, filePath, true)
+	if err != nil {
+		return nil, err
+	}
+	gatewayUrl, err := storage.gateway()
+	if err != nil {
+		return nil, fmt.Errorf("failed get mcs ipfs gatewayUrl: %w", err)
+	}
+	return &StoredObject{
+		Name: objectName,
+		URI:  gatewayUrl + "/ipfs/" + ossFile.PayloadCid,
+		Cid:  ossFile.PayloadCid,
+		Size: ossFile.Size,
+	}, nil
+}
+
+// Ping logs in to MCS.
+func (storage *StorageService) Ping(ctx context.Context) error {
+	_, err := storage.client()
+	return err
+}
+
+// gateway returns the IPFS gateway of the bucket, it is looked up once.
+func (storage *StorageService) gateway() (string, error) {
+	storage.mu.Lock()
+	gatewayUrl := storage.gatewayUrl
+	storage.mu.Unlock()
+	if gatewayUrl != "" {
+		return gatewayUrl, nil
+	}
+
+	url, err := storage.GetGatewayUrl()
+	if err != nil {
+		return "", err
+	}
+	storage.mu.Lock()
+	storage.gatewayUrl = *url
+	storage.mu.Unlock()
+	return *url, nil
+}
+
 func (storage *StorageService) DeleteBucket(bucketName string) error {
-	return bucket.GetBucketClient(*storage.mcsClient).DeleteBucket(bucketName)
+	buketClient, err := storage.bucketClient()
+	if err != nil {
+		return err
+	}
+	return buketClient.DeleteBucket(bucketName)
 }
 
 func (storage *StorageService) CreateBucket(bucketName string) {
-	_, err := bucket.GetBucketClient(*storage.mcsClient).CreateBucket(bucketName)
+	buketClient, err := storage.bucketClient()
 	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+	if _, err = buketClient.CreateBucket(bucketName); err != nil {
 		logs.GetLogger().Errorf("Failed create bucket, error: %v", err)
 		return
 	}
 }
 
 func (storage *StorageService) CreateFolder(folderName string) {
-	_, err := bucket.GetBucketClient(*storage.mcsClient).CreateFolder(storage.BucketName, folderName, "")
+	buketClient, err := storage.bucketClient()
 	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+	if _, err = buketClient.CreateFolder(storage.BucketName, folderName, ""); err != nil {
 		logs.GetLogger().Errorf("Failed create folder, error: %v", err)
 		return
 	}
 }
 
 func (storage *StorageService) GetGatewayUrl() (*string, error) {
-	return bucket.GetBucketClient(*storage.mcsClient).GetGateway()
+	buketClient, err := storage.bucketClient()
+	if err != nil {
+		return nil, err
+	}
+	return buketClient.GetGateway()
 }
@@ -1,54 +1,20 @@
 package computing
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/gomodule/redigo/redis"
-	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/lad"
 	"github.com/lagrangedao/go-computing-provider/models"
-	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
 func RunSyncTask() {
-	go func() {
-		k8sService := NewK8sService()
-		nodes, err := k8sService.k8sClient.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{})
-		if err != nil {
-			logs.GetLogger().Error(err)
-			return
-		}
-
-		nodeGpuInfoMap, err := k8sService.GetPodLog(context.TODO())
-		if err != nil {
-			logs.GetLogger().Error(err)
-			return
-		}
-
-		for _, node := range nodes.Items {
-			cpNode := node
-			if gpu, ok := nodeGpuInfoMap[cpNode.Name]; ok {
-				var gpuInfo struct {
-					Gpu models.Gpu `json:"gpu"`
-				}
-				if err = json.Unmarshal([]byte(gpu.String()), &gpuInfo); err != nil {
-					logs.GetLogger().Error(err)
-					return
-				}
-				for _, detail := range gpuInfo.Gpu.Details {
-					if err = k8sService.AddNodeLabel(cpNode.Name, detail.ProductName); err != nil {
-						logs.GetLogger().Error(err)
-					}
-				}
-			}
-		}
-	}()
+	watchHealth()
+	go labelGpuNodes()
 
 	go func() {
 		defer func() {
@@ -73,6 +39,10 @@ func RunSyncTask() {
 
 	watchExpiredTask()
 	watchNameSpaceForDeleted()
+	watchImageGC()
+	watchWarmCache()
+	watchMetering()
+	watchBatchJobs()
 }
 
 func reportClusterResource(location, nodeId string) {
@@ -88,31 +58,10 @@ func reportClusterResource(location, nodeId string) {
 		ClusterInfo: statisticalSources,
 	}
 
-	payload, err := json.Marshal(clusterSource)
-	if err != nil {
-		logs.GetLogger().Errorf("Failed convert to json, error: %+v", err)
-		return
-	}
-
-	client := &http.Client{}
-	url := conf.GetConfig().LAD.ServerUrl + "/cp/summary"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		logs.GetLogger().Errorf("Error creating request: %v", err)
-		return
-	}
-	req.Header.Set("Authorization", "Bearer "+conf.GetConfig().LAD.AccessToken)
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		logs.GetLogger().Errorf("Failed send a request, error: %+v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		logs.GetLogger().Errorf("The request url: %s, returns a non-200 status code: %d", url, resp.StatusCode)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err = newLadClient().ReportSummary(ctx, &clusterSource); err != nil {
+		logs.GetLogger().Errorf("Failed report cluster node resources, error: %+v", err)
 		return
 	}
 	logs.GetLogger().Info("report cluster node resources successfully")
@@ -160,7 +109,9 @@ func watchExpiredTask() {
 					}
 					if time.Now().Unix() > expireTime {
 						logs.GetLogger().Infof("The namespace: %s, spacename: %s, job has reached its runtime and will stop running.", namespace, spaceName)
-						deleteJob(namespace, spaceName)
+						jobUuid := strings.TrimPrefix(key, constants.REDIS_FULL_PREFIX)
+						ctx := logs.WithJob(context.Background(), jobUuid, spaceName, "")
+						deleteJob(ctx, namespace, spaceName, TerminationExpired)
 						deleteKey = append(deleteKey, key)
 					}
 				}
@@ -210,3 +161,23 @@ func watchNameSpaceForDeleted() {
 		}
 	}()
 }
+
+// reportJobStatus tells the LAD server the state a job reached, err is the reason of a failed job.
+func reportJobStatus(ctx context.Context, jobUuid, state string, err error) {
+	reportJobResult(ctx, jobUuid, state, "", err)
+}
+
+// reportJobResult reports the state of a job together with where its result was published.
+func reportJobResult(ctx context.Context, jobUuid, state, resultURI string, err error) {
+	if jobUuid == "" {
+		return
+	}
+	nodeId, _, _ := generateNodeID()
+	req := &lad.JobStatusReq{NodeId: nodeId, JobUuid: jobUuid, Status: state, JobResultURI: resultURI}
+	if err != nil {
+		req.Message = err.Error()
+	}
+	if err = newLadClient().UpdateJobStatus(ctx, req); err != nil {
+		logs.FromContext(ctx).Errorf("Failed report job status %s, error: %+v", state, err)
+	}
+}
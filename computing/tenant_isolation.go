@@ -0,0 +1,385 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	coreV1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	TenantPolicyIngress     = "ingress"
+	TenantPolicyEgress      = "egress"
+	TenantPolicyQuota       = "quota"
+	TenantPolicyPodSecurity = "pod-security"
+
+	tenantIngressPolicyName = "default-deny-ingress"
+	tenantEgressPolicyName  = "restrict-egress"
+	tenantQuotaName         = "tenant-quota"
+	tenantLimitRangeName    = "tenant-limits"
+
+	defaultIngressNamespace = "ingress-nginx"
+	defaultPodSecurityLevel = "restricted"
+	defaultContainerCpu     = "500m"
+	defaultContainerMemory  = "512Mi"
+	cloudMetadataCIDR       = "169.254.169.254/32"
+)
+
+var allTenantPolicies = []string{TenantPolicyIngress, TenantPolicyEgress, TenantPolicyQuota, TenantPolicyPodSecurity}
+
+// tenantIsolationConfig returns the isolation config, every policy is opt-in so none applies when Policies is empty.
+func tenantIsolationConfig() conf.TenantIsolation {
+	var c conf.TenantIsolation
+	if conf.GetConfig() != nil {
+		c = conf.GetConfig().TenantIsolation
+	}
+	return withTenantDefaults(c)
+}
+
+func withTenantDefaults(c conf.TenantIsolation) conf.TenantIsolation {
+	if c.IngressNamespace == "" {
+		c.IngressNamespace = defaultIngressNamespace
+	}
+	if c.PodSecurityLevel == "" {
+		c.PodSecurityLevel = defaultPodSecurityLevel
+	}
+	if c.DefaultCpu == "" {
+		c.DefaultCpu = defaultContainerCpu
+	}
+	if c.DefaultMemory == "" {
+		c.DefaultMemory = defaultContainerMemory
+	}
+	return c
+}
+
+// CheckTenantIsolation validates a tenant isolation config, it runs at startup so that a typo in
+// config.toml is reported once instead of failing every deployment.
+func CheckTenantIsolation(c conf.TenantIsolation) error {
+	c = withTenantDefaults(c)
+	for _, policy := range c.Policies {
+		known := false
+		for _, p := range allTenantPolicies {
+			known = known || strings.EqualFold(strings.TrimSpace(policy), p)
+		}
+		if !known {
+			return fmt.Errorf("unknown tenant policy %q, supported: %s", policy, strings.Join(allTenantPolicies, ", "))
+		}
+	}
+	if _, err := tenantDefaultRequests(c); err != nil {
+		return err
+	}
+	if _, err := TenantEgressPolicy("", c, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// tenantDefaultRequests parses the requests given to containers that do not set one.
+func tenantDefaultRequests(c conf.TenantIsolation) (coreV1.ResourceList, error) {
+	cpu, err := resource.ParseQuantity(c.DefaultCpu)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DefaultCpu %q: %w", c.DefaultCpu, err)
+	}
+	memory, err := resource.ParseQuantity(c.DefaultMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DefaultMemory %q: %w", c.DefaultMemory, err)
+	}
+	return coreV1.ResourceList{coreV1.ResourceCPU: cpu, coreV1.ResourceMemory: memory}, nil
+}
+
+func tenantPolicyEnabled(c conf.TenantIsolation, policy string) bool {
+	for _, p := range c.Policies {
+		if strings.EqualFold(strings.TrimSpace(p), policy) {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionTenantNamespace applies the configured isolation policies to a tenant namespace,
+// it is idempotent and runs on every deployment so configuration changes reach existing tenants.
+func provisionTenantNamespace(k8sNameSpace string) error {
+	c := tenantIsolationConfig()
+	k8sService := NewK8sService()
+
+	if tenantPolicyEnabled(c, TenantPolicyPodSecurity) {
+		level := c.PodSecurityLevel
+		err := k8sService.LabelNameSpace(context.TODO(), k8sNameSpace, map[string]string{
+			"pod-security.kubernetes.io/enforce": level,
+			"pod-security.kubernetes.io/audit":   level,
+			"pod-security.kubernetes.io/warn":    level,
+		})
+		if err != nil {
+			return fmt.Errorf("failed label namespace for pod security, error: %w", err)
+		}
+	}
+
+	if tenantPolicyEnabled(c, TenantPolicyIngress) {
+		if _, err := k8sService.ApplyNetworkPolicy(context.TODO(), tenantIngressPolicy(k8sNameSpace, c)); err != nil {
+			return fmt.Errorf("failed create ingress networkPolicy, error: %w", err)
+		}
+	}
+
+	if tenantPolicyEnabled(c, TenantPolicyEgress) {
+		apiServerIPs, err := k8sService.GetApiServerIPs(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed get kubernetes api server addresses, error: %w", err)
+		}
+		policy, err := TenantEgressPolicy(k8sNameSpace, c, apiServerIPs)
+		if err != nil {
+			return err
+		}
+		if _, err = k8sService.ApplyNetworkPolicy(context.TODO(), policy); err != nil {
+			return fmt.Errorf("failed create egress networkPolicy, error: %w", err)
+		}
+	}
+
+	if tenantPolicyEnabled(c, TenantPolicyQuota) {
+		defaults, err := tenantDefaultRequests(c)
+		if err != nil {
+			return err
+		}
+		if _, err = k8sService.ApplyLimitRange(context.TODO(), tenantLimitRange(k8sNameSpace, defaults)); err != nil {
+			return fmt.Errorf("failed create limitRange, error: %w", err)
+		}
+	}
+	return nil
+}
+
+// tenantIngressPolicy denies all ingress except from the ingress controller and from pods of the same tenant.
+func tenantIngressPolicy(namespace string, c conf.TenantIsolation) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      tenantIngressPolicyName,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metaV1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metaV1.LabelSelector{
+								MatchLabels: map[string]string{
+									"kubernetes.io/metadata.name": c.IngressNamespace,
+								},
+							},
+						},
+						{
+							PodSelector: &metaV1.LabelSelector{},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// TenantEgressPolicy allows egress anywhere but to the kubernetes api server, the cloud metadata
+// endpoint and the configured extra CIDRs.
+func TenantEgressPolicy(namespace string, c conf.TenantIsolation, apiServerIPs []string) (*networkingv1.NetworkPolicy, error) {
+	var v4Except, v6Except []string
+	blocked := append([]string{cloudMetadataCIDR}, c.DenyEgressCIDRs...)
+	for _, ip := range apiServerIPs {
+		if strings.Contains(ip, ":") {
+			blocked = append(blocked, ip+"/128")
+		} else {
+			blocked = append(blocked, ip+"/32")
+		}
+	}
+	for _, cidr := range blocked {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress cidr %q: %w", cidr, err)
+		}
+		if ipNet.IP.To4() != nil {
+			v4Except = append(v4Except, ipNet.String())
+		} else {
+			v6Except = append(v6Except, ipNet.String())
+		}
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      tenantEgressPolicyName,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metaV1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					To: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: v4Except}},
+						{IPBlock: &networkingv1.IPBlock{CIDR: "::/0", Except: v6Except}},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// tenantLimitRange gives containers without requests the default requests, so that the quota can count them.
+func tenantLimitRange(namespace string, defaults coreV1.ResourceList) *coreV1.LimitRange {
+	return &coreV1.LimitRange{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      tenantLimitRangeName,
+			Namespace: namespace,
+		},
+		Spec: coreV1.LimitRangeSpec{
+			Limits: []coreV1.LimitRangeItem{{
+				Type:           coreV1.LimitTypeContainer,
+				DefaultRequest: defaults,
+			}},
+		},
+	}
+}
+
+// syncTenantQuota sets the namespace quota to the resources requested by the deployments, unfinished batch
+// jobs and cron jobs of the wallet's active jobs plus the pod about to be created, so a tenant cannot start
+// pods beyond its jobs.
+func syncTenantQuota(k8sNameSpace string, pending *coreV1.PodSpec) error {
+	c := tenantIsolationConfig()
+	if !tenantPolicyEnabled(c, TenantPolicyQuota) {
+		return nil
+	}
+	defaults, err := tenantDefaultRequests(c)
+	if err != nil {
+		return err
+	}
+
+	k8sService := NewK8sService()
+	deployments, err := k8sService.ListDeployments(context.TODO(), k8sNameSpace)
+	if err != nil {
+		return fmt.Errorf("failed list deployments, error: %w", err)
+	}
+	jobs, err := k8sService.ListJobs(context.TODO(), k8sNameSpace)
+	if err != nil {
+		return fmt.Errorf("failed list jobs, error: %w", err)
+	}
+	cronJobs, err := k8sService.ListCronJobs(context.TODO(), k8sNameSpace)
+	if err != nil {
+		return fmt.Errorf("failed list cronJobs, error: %w", err)
+	}
+
+	var pods int64
+	hard := coreV1.ResourceList{}
+	addPods := func(spec *coreV1.PodSpec, replicas int64) {
+		pods += replicas
+		for name, quantity := range PodRequests(spec, defaults) {
+			key := coreV1.ResourceName("requests." + string(name))
+			total := hard[key]
+			for i := int64(0); i < replicas; i++ {
+				total.Add(quantity)
+			}
+			hard[key] = total
+		}
+	}
+	for _, deployment := range deployments {
+		replicas := int64(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = int64(*deployment.Spec.Replicas)
+		}
+		addPods(&deployment.Spec.Template.Spec, replicas)
+	}
+	for _, job := range jobs {
+		// the runs of a cron job are counted with the cron job
+		if !jobFinished(&job) && metaV1.GetControllerOf(&job) == nil {
+			addPods(&job.Spec.Template.Spec, 1)
+		}
+	}
+	for _, cronJob := range cronJobs {
+		addPods(&cronJob.Spec.JobTemplate.Spec.Template.Spec, 1)
+	}
+	if pending != nil {
+		addPods(pending, 1)
+	}
+	hard[coreV1.ResourcePods] = *resource.NewQuantity(pods, resource.DecimalSI)
+
+	quota := &coreV1.ResourceQuota{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      tenantQuotaName,
+			Namespace: k8sNameSpace,
+		},
+		Spec: coreV1.ResourceQuotaSpec{Hard: hard},
+	}
+	if _, err = k8sService.ApplyResourceQuota(context.TODO(), quota); err != nil {
+		return fmt.Errorf("failed apply resourceQuota, error: %w", err)
+	}
+	logs.GetLogger().Infof("Resource quota of namespace %s updated: %v", k8sNameSpace, hard)
+	return nil
+}
+
+// PodRequests sums the requests of the containers in a pod, filling in the LimitRange defaults. An init
+// container runs alone, the pod requests the most of it and the sum of the containers.
+func PodRequests(spec *coreV1.PodSpec, defaults coreV1.ResourceList) coreV1.ResourceList {
+	total := coreV1.ResourceList{}
+	for _, container := range spec.Containers {
+		for name, quantity := range defaultedRequests(container, defaults) {
+			sum := total[name]
+			sum.Add(quantity)
+			total[name] = sum
+		}
+	}
+	for _, container := range spec.InitContainers {
+		for name, quantity := range defaultedRequests(container, defaults) {
+			if current, ok := total[name]; !ok || quantity.Cmp(current) > 0 {
+				total[name] = quantity
+			}
+		}
+	}
+	return total
+}
+
+// defaultedRequests returns the requests of a container, the LimitRange defaults fill in the missing ones.
+func defaultedRequests(container coreV1.Container, defaults coreV1.ResourceList) coreV1.ResourceList {
+	requests := container.Resources.Requests.DeepCopy()
+	if requests == nil {
+		requests = coreV1.ResourceList{}
+	}
+	for name, quantity := range container.Resources.Limits {
+		if _, ok := requests[name]; !ok && name != coreV1.ResourceCPU && name != coreV1.ResourceMemory {
+			requests[name] = quantity
+		}
+	}
+	for name, quantity := range defaults {
+		if _, ok := requests[name]; !ok {
+			requests[name] = quantity
+		}
+	}
+	delete(requests, coreV1.ResourceStorage)
+	return requests
+}
+
+// applyPodSecurity makes a pod spec comply with the restricted Pod Security Standard.
+func applyPodSecurity(spec *coreV1.PodSpec) {
+	c := tenantIsolationConfig()
+	if !tenantPolicyEnabled(c, TenantPolicyPodSecurity) || c.PodSecurityLevel != defaultPodSecurityLevel {
+		return
+	}
+
+	runAsNonRoot := true
+	allowPrivilegeEscalation := false
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &coreV1.PodSecurityContext{}
+	}
+	spec.SecurityContext.RunAsNonRoot = &runAsNonRoot
+	spec.SecurityContext.SeccompProfile = &coreV1.SeccompProfile{Type: coreV1.SeccompProfileTypeRuntimeDefault}
+
+	for _, containers := range [][]coreV1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			containers[i].SecurityContext = &coreV1.SecurityContext{
+				AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+				Capabilities:             &coreV1.Capabilities{Drop: []coreV1.Capability{"ALL"}},
+			}
+		}
+	}
+}
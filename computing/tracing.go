@@ -0,0 +1,151 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName                = "github.com/lagrangedao/go-computing-provider/computing"
+	defaultTracingServiceName = "computing-provider"
+	defaultTracingSampleRatio = 1.0
+)
+
+// tracer resolves the global tracer provider on every span, it is a no-op until InitTracing installs one.
+var tracer = otel.Tracer(tracerName)
+
+func init() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+func tracingConfig() conf.Tracing {
+	var c conf.Tracing
+	if cfg := conf.GetConfig(); cfg != nil {
+		c = cfg.Tracing
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = defaultTracingServiceName
+	}
+	return c
+}
+
+// tracingSampleRatio returns the share of the traces kept, all of them when SampleRatio is not set.
+func tracingSampleRatio(c conf.Tracing) float64 {
+	if c.SampleRatio == nil {
+		return defaultTracingSampleRatio
+	}
+	return *c.SampleRatio
+}
+
+// InitTracing exports the spans to the configured OTLP/HTTP endpoint, the returned function flushes
+// the pending spans on shutdown. Nothing is exported when tracing is disabled.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	c := tracingConfig()
+	if !c.Enable {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(c.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingSampleRatio(c)))),
+	)
+	otel.SetTracerProvider(provider)
+	logs.GetLogger().Infof("Tracing enabled, exporting spans to %s", c.Endpoint)
+	return provider.Shutdown, nil
+}
+
+// startSpan starts a span of a step of the job pipeline.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, marking it failed when the step returned an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceCarrier serializes the trace context so it can travel as a celery task argument.
+func TraceCarrier(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(carrier)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ContextFromCarrier restores the trace context serialized by TraceCarrier.
+func ContextFromCarrier(ctx context.Context, data string) context.Context {
+	if data == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal([]byte(data), &carrier); err != nil {
+		logs.GetLogger().Warnf("Failed decode trace context %q, error: %v", data, err)
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// TracingMiddleware starts a server span for every request, continuing the trace of the caller.
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(c.Request.Method),
+				semconv.HTTPRoute(route),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
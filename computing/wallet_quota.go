@@ -0,0 +1,396 @@
+package computing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+)
+
+var walletQuotaLock sync.Mutex
+
+// walletReplacedTTL is how long the jobs replaced by an admitted job are kept to be restored, its deployment
+// either tears them down or fails well before.
+const walletReplacedTTL = 24 * time.Hour
+
+// QuotaExceededError is returned when a submission would exceed the limits of a wallet.
+type QuotaExceededError struct {
+	Wallet string
+	Limit  string
+	Value  int
+	Max    int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("wallet %s exceeds its %s limit: %d > %d", e.Wallet, e.Limit, e.Value, e.Max)
+}
+
+// walletQuotaStatus is the status of a rejected submission, only an exceeded limit is a 429.
+func walletQuotaStatus(err error) int {
+	if _, ok := err.(*QuotaExceededError); ok {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// WalletJob is an active job counted against the limits of its creator wallet.
+type WalletJob struct {
+	JobUuid    string `json:"job_uuid"`
+	SpaceName  string `json:"space_name"`
+	Gpus       int    `json:"gpus"`
+	Duration   int    `json:"duration"`
+	ExpireTime int64  `json:"expire_time"`
+}
+
+// WalletQuotaUsage shows the usage of a wallet against its limits, a limit of 0 means unlimited.
+type WalletQuotaUsage struct {
+	Wallet string           `json:"wallet"`
+	Limits conf.WalletLimit `json:"limits"`
+	Usage  struct {
+		ConcurrentJobs       int `json:"concurrent_jobs"`
+		Gpus                 int `json:"gpus"`
+		TotalDuration        int `json:"total_duration"`
+		SubmissionsPerMinute int `json:"submissions_per_minute"`
+	} `json:"usage"`
+	Jobs []WalletJob `json:"jobs"`
+}
+
+// walletLimit returns the override of a wallet, or the default policy when it has none.
+func walletLimit(wallet string) conf.WalletLimit {
+	c := conf.GetConfig().WalletQuota
+	for key, limit := range c.Overrides {
+		if strings.EqualFold(key, wallet) {
+			return limit
+		}
+	}
+	return c.Default
+}
+
+func walletJobsKey(wallet string) string {
+	return constants.REDIS_WALLET_JOBS_PREFIX + strings.ToLower(wallet)
+}
+
+func walletRateKey(wallet string) string {
+	return fmt.Sprintf("%s%s:%d", constants.REDIS_WALLET_RATE_PREFIX, strings.ToLower(wallet), time.Now().Unix()/60)
+}
+
+// activeWalletJobs returns the jobs of a wallet that have not expired yet and drops the expired ones.
+func activeWalletJobs(conn redis.Conn, wallet string) ([]WalletJob, error) {
+	values, err := redis.StringMap(conn.Do("HGETALL", walletJobsKey(wallet)))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	var jobs []WalletJob
+	for jobUuid, value := range values {
+		var job WalletJob
+		if err := json.Unmarshal([]byte(value), &job); err != nil || job.ExpireTime < now {
+			conn.Do("HDEL", walletJobsKey(wallet), jobUuid)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func hardwareGpus(hardware string) int {
+	if r, ok := common.HardwareResource[hardware]; ok {
+		return int(r.Gpu.Quantity)
+	}
+	return 0
+}
+
+// admitWalletJob enforces the limits of the creator wallet and records the job when it is accepted.
+// rollbackWalletJob restores the jobs it replaced when the job is not deployed.
+func admitWalletJob(wallet, spaceName, jobUuid, hardware string, duration int) error {
+	walletQuotaLock.Lock()
+	defer walletQuotaLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	_, err := AdmitWalletJob(conn, wallet, walletLimit(wallet), WalletJob{
+		JobUuid:    jobUuid,
+		SpaceName:  spaceName,
+		Gpus:       hardwareGpus(hardware),
+		Duration:   duration,
+		ExpireTime: time.Now().Unix() + int64(duration),
+	})
+	return err
+}
+
+// AdmitWalletJob checks a new job against the limits of the wallet and records it when it is accepted.
+// An active job of the same space is replaced by the new one and does not count against the limits,
+// the replaced jobs are returned and kept until the space is torn down, RollbackWalletJob restores them.
+func AdmitWalletJob(conn redis.Conn, wallet string, limit conf.WalletLimit, newJob WalletJob) ([]WalletJob, error) {
+	submissions, err := redis.Int(conn.Do("INCR", walletRateKey(wallet)))
+	if err != nil {
+		return nil, err
+	}
+	conn.Do("EXPIRE", walletRateKey(wallet), 120)
+	if limit.SubmissionsPerMinute > 0 && submissions > limit.SubmissionsPerMinute {
+		return nil, &QuotaExceededError{Wallet: wallet, Limit: "submissions per minute", Value: submissions, Max: limit.SubmissionsPerMinute}
+	}
+
+	jobs, err := activeWalletJobs(conn, wallet)
+	if err != nil {
+		return nil, err
+	}
+	newJob.SpaceName = strings.ToLower(newJob.SpaceName)
+
+	var replaced []WalletJob
+	concurrent, gpus, totalDuration := 1, newJob.Gpus, newJob.Duration
+	for _, job := range jobs {
+		if job.SpaceName == newJob.SpaceName || job.JobUuid == newJob.JobUuid {
+			replaced = append(replaced, job)
+			continue
+		}
+		concurrent++
+		gpus += job.Gpus
+		totalDuration += job.Duration
+	}
+
+	if limit.MaxConcurrentJobs > 0 && concurrent > limit.MaxConcurrentJobs {
+		return nil, &QuotaExceededError{Wallet: wallet, Limit: "concurrent jobs", Value: concurrent, Max: limit.MaxConcurrentJobs}
+	}
+	if limit.MaxGpus > 0 && gpus > limit.MaxGpus {
+		return nil, &QuotaExceededError{Wallet: wallet, Limit: "gpus", Value: gpus, Max: limit.MaxGpus}
+	}
+	if limit.MaxTotalDuration > 0 && totalDuration > limit.MaxTotalDuration {
+		return nil, &QuotaExceededError{Wallet: wallet, Limit: "total duration", Value: totalDuration, Max: limit.MaxTotalDuration}
+	}
+
+	data, err := json.Marshal(newJob)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range replaced {
+		conn.Do("HDEL", walletJobsKey(wallet), job.JobUuid)
+	}
+	if _, err = conn.Do("HSET", walletJobsKey(wallet), newJob.JobUuid, data); err != nil {
+		return nil, err
+	}
+	if len(replaced) > 0 {
+		if data, err = json.Marshal(replaced); err != nil {
+			return nil, err
+		}
+		if _, err = conn.Do("SET", constants.REDIS_WALLET_REPLACED_PREFIX+newJob.JobUuid, data, "EX", int(walletReplacedTTL.Seconds())); err != nil {
+			return nil, err
+		}
+	}
+	return replaced, nil
+}
+
+// rollbackWalletJob stops counting a job that was admitted but not deployed.
+func rollbackWalletJob(wallet, jobUuid string) {
+	walletQuotaLock.Lock()
+	defer walletQuotaLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	if err := RollbackWalletJob(conn, wallet, jobUuid); err != nil {
+		logs.GetLogger().Errorf("Failed rollback wallet job, wallet: %s, job_uuid: %s, error: %+v", wallet, jobUuid, err)
+	}
+}
+
+// RollbackWalletJob removes an admitted job from the wallet and restores the jobs it replaced, unless
+// their space was torn down or another job replaced them since.
+func RollbackWalletJob(conn redis.Conn, wallet, jobUuid string) error {
+	replacedKey := constants.REDIS_WALLET_REPLACED_PREFIX + jobUuid
+	var replaced []WalletJob
+	value, err := redis.Bytes(conn.Do("GET", replacedKey))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(value, &replaced); err != nil {
+			return err
+		}
+	}
+
+	if _, err = conn.Do("HDEL", walletJobsKey(wallet), jobUuid); err != nil {
+		return err
+	}
+	jobs, err := activeWalletJobs(conn, wallet)
+	if err != nil {
+		return err
+	}
+	spaces := make(map[string]bool)
+	for _, job := range jobs {
+		spaces[job.SpaceName] = true
+	}
+	for _, job := range replaced {
+		if job.JobUuid == jobUuid || spaces[job.SpaceName] || job.ExpireTime < time.Now().Unix() {
+			continue
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if _, err = conn.Do("HSET", walletJobsKey(wallet), job.JobUuid, data); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Do("DEL", replacedKey)
+	return err
+}
+
+// dropReplacedWalletJobs forgets the jobs replaced by a job once their space is torn down for it.
+func dropReplacedWalletJobs(jobUuid string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	if err := DropReplacedWalletJobs(conn, jobUuid); err != nil {
+		logs.GetLogger().Errorf("Failed drop replaced wallet jobs, job_uuid: %s, error: %+v", jobUuid, err)
+	}
+}
+
+// DropReplacedWalletJobs forgets the jobs replaced by a job, a rollback of the job does not restore them anymore.
+func DropReplacedWalletJobs(conn redis.Conn, jobUuid string) error {
+	_, err := conn.Do("DEL", constants.REDIS_WALLET_REPLACED_PREFIX+jobUuid)
+	return err
+}
+
+// claimWalletGpus counts the gpus of the deploy.yaml of a job, which are not known at admission.
+func claimWalletGpus(wallet, jobUuid string, gpus int) error {
+	walletQuotaLock.Lock()
+	defer walletQuotaLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	return ClaimWalletGpus(conn, wallet, walletLimit(wallet), jobUuid, gpus)
+}
+
+// ClaimWalletGpus sets the gpus of an admitted job and checks them against the gpu limit of the wallet.
+// The gpus of the job are only raised, the hardware profile already counted at admission stays a floor.
+func ClaimWalletGpus(conn redis.Conn, wallet string, limit conf.WalletLimit, jobUuid string, gpus int) error {
+	jobs, err := activeWalletJobs(conn, wallet)
+	if err != nil {
+		return err
+	}
+
+	var total int
+	var claimed *WalletJob
+	for i, job := range jobs {
+		if job.JobUuid == jobUuid {
+			claimed = &jobs[i]
+			continue
+		}
+		total += job.Gpus
+	}
+	if claimed == nil || gpus <= claimed.Gpus {
+		return nil
+	}
+	if limit.MaxGpus > 0 && total+gpus > limit.MaxGpus {
+		return &QuotaExceededError{Wallet: wallet, Limit: "gpus", Value: total + gpus, Max: limit.MaxGpus}
+	}
+
+	claimed.Gpus = gpus
+	data, err := json.Marshal(claimed)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", walletJobsKey(wallet), jobUuid, data)
+	return err
+}
+
+// renewWalletJob extends a job of the wallet, the extension counts against the total duration limit.
+func renewWalletJob(wallet, jobUuid string, duration int, expireTime int64) error {
+	walletQuotaLock.Lock()
+	defer walletQuotaLock.Unlock()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	jobs, err := activeWalletJobs(conn, wallet)
+	if err != nil {
+		return err
+	}
+
+	limit := walletLimit(wallet)
+	var totalDuration int
+	var renewed *WalletJob
+	for i, job := range jobs {
+		totalDuration += job.Duration
+		if job.JobUuid == jobUuid {
+			renewed = &jobs[i]
+		}
+	}
+	if renewed == nil {
+		return nil
+	}
+	if limit.MaxTotalDuration > 0 && totalDuration+duration > limit.MaxTotalDuration {
+		return &QuotaExceededError{Wallet: wallet, Limit: "total duration", Value: totalDuration + duration, Max: limit.MaxTotalDuration}
+	}
+
+	renewed.Duration += duration
+	renewed.ExpireTime = expireTime
+	data, err := json.Marshal(renewed)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", walletJobsKey(wallet), jobUuid, data)
+	return err
+}
+
+// releaseWalletSpace stops counting the jobs of a deleted space.
+func releaseWalletSpace(wallet, spaceName string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	jobs, err := activeWalletJobs(conn, wallet)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get wallet jobs, wallet: %s, error: %+v", wallet, err)
+		return
+	}
+	for _, job := range jobs {
+		if job.SpaceName == strings.ToLower(spaceName) {
+			conn.Do("HDEL", walletJobsKey(wallet), job.JobUuid)
+		}
+	}
+}
+
+// GetWalletQuota shows the usage of a wallet against its limits.
+func GetWalletQuota(c *gin.Context) {
+	wallet := strings.ToLower(c.Param("wallet"))
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	jobs, err := activeWalletJobs(conn, wallet)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+	submissions, err := redis.Int(conn.Do("GET", walletRateKey(wallet)))
+	if err != nil && err != redis.ErrNil {
+		c.JSON(http.StatusInternalServerError, common.CreateErrorResponse(strconv.Itoa(http.StatusInternalServerError), err.Error()))
+		return
+	}
+
+	usage := WalletQuotaUsage{
+		Wallet: wallet,
+		Limits: walletLimit(wallet),
+		Jobs:   make([]WalletJob, 0, len(jobs)),
+	}
+	usage.Usage.SubmissionsPerMinute = submissions
+	for _, job := range jobs {
+		usage.Usage.ConcurrentJobs++
+		usage.Usage.Gpus += job.Gpus
+		usage.Usage.TotalDuration += job.Duration
+		usage.Jobs = append(usage.Jobs, job)
+	}
+	c.JSON(http.StatusOK, common.CreateSuccessResponse(usage))
+}
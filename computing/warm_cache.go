@@ -0,0 +1,287 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/docker"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultWarmCacheTopN       = 5
+	defaultWarmCacheRecentDays = 7
+	defaultWarmCacheInterval   = 60
+
+	warmCacheDaemonSetName = "lad-image-warm-cache"
+	warmCacheToolImage     = "busybox:1.36"
+	warmCacheToolPath      = "/warm-cache"
+)
+
+func warmCacheConfig() conf.WarmCache {
+	c := conf.GetConfig().WarmCache
+	if c.TopN <= 0 {
+		c.TopN = defaultWarmCacheTopN
+	}
+	if c.RecentDays <= 0 {
+		c.RecentDays = defaultWarmCacheRecentDays
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultWarmCacheInterval
+	}
+	return c
+}
+
+// recordBaseImages counts the base images used by a job so the most popular ones can be pre-pulled.
+func recordBaseImages(images []string) {
+	if len(images) == 0 {
+		return
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	now := time.Now().Unix()
+	conn.Send("MULTI")
+	for _, image := range images {
+		conn.Send("ZINCRBY", constants.REDIS_WARM_CACHE_COUNT, 1, image)
+		conn.Send("HSET", constants.REDIS_WARM_CACHE_LAST_USED, image, now)
+	}
+	if _, err := conn.Do("EXEC"); err != nil {
+		logs.GetLogger().Errorf("Failed record base images, error: %+v", err)
+	}
+}
+
+// warmCacheImages returns the top-N base images used by jobs within the last RecentDays,
+// images that were not used recently are dropped from the statistics.
+func warmCacheImages() ([]string, error) {
+	c := warmCacheConfig()
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	counts, err := redis.Int64Map(conn.Do("ZREVRANGE", constants.REDIS_WARM_CACHE_COUNT, 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+	lastUsed, err := redis.Int64Map(conn.Do("HGETALL", constants.REDIS_WARM_CACHE_LAST_USED))
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().AddDate(0, 0, -c.RecentDays).Unix()
+	var images []string
+	for image := range counts {
+		if lastUsed[image] < deadline {
+			conn.Do("ZREM", constants.REDIS_WARM_CACHE_COUNT, image)
+			conn.Do("HDEL", constants.REDIS_WARM_CACHE_LAST_USED, image)
+			continue
+		}
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if counts[images[i]] != counts[images[j]] {
+			return counts[images[i]] > counts[images[j]]
+		}
+		return images[i] < images[j]
+	})
+	if len(images) > c.TopN {
+		images = images[:c.TopN]
+	}
+	return images, nil
+}
+
+func watchWarmCache() {
+	c := warmCacheConfig()
+	if !c.Enable {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(c.Interval) * time.Minute)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.GetLogger().Errorf("catch panic error: %+v", err)
+			}
+		}()
+
+		syncWarmCache()
+		for range ticker.C {
+			syncWarmCache()
+		}
+	}()
+}
+
+// syncWarmCache rolls out a DaemonSet that pulls the popular base images on every node.
+func syncWarmCache() {
+	images, err := warmCacheImages()
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get warm cache images, error: %+v", err)
+		return
+	}
+
+	k8sService := NewK8sService()
+	if err = ensureSystemNamespace(constants.K8S_SYSTEM_NAMESPACE); err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+	if len(images) == 0 {
+		if err = k8sService.DeleteDaemonSet(context.TODO(), constants.K8S_SYSTEM_NAMESPACE, warmCacheDaemonSetName); err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed delete warm cache daemonset, error: %+v", err)
+		}
+		return
+	}
+
+	imagePullSecrets, err := deployImagePullSecret(context.TODO(), constants.K8S_SYSTEM_NAMESPACE)
+	if err != nil {
+		logs.GetLogger().Error(err)
+		return
+	}
+	if _, err = k8sService.ApplyDaemonSet(context.TODO(), WarmCacheDaemonSet(images, imagePullSecrets)); err != nil {
+		logs.GetLogger().Errorf("Failed apply warm cache daemonset, error: %+v", err)
+		return
+	}
+	logs.GetLogger().Infof("Warm cache daemonset updated, images: %v", images)
+}
+
+// WarmCacheDaemonSet runs one idle container per image, the kubelet pulls each of them on its own so an
+// image that cannot be pulled does not keep the others from being cached.
+func WarmCacheDaemonSet(images []string, imagePullSecrets []coreV1.LocalObjectReference) *appV1.DaemonSet {
+	labels := map[string]string{"app": warmCacheDaemonSetName}
+	toolMount := []coreV1.VolumeMount{{Name: "warm-cache-tool", MountPath: warmCacheToolPath}}
+	smallResource := coreV1.ResourceRequirements{
+		Limits: coreV1.ResourceList{
+			coreV1.ResourceCPU:    resource.MustParse("50m"),
+			coreV1.ResourceMemory: resource.MustParse("32Mi"),
+		},
+		Requests: coreV1.ResourceList{
+			coreV1.ResourceCPU:    resource.MustParse("10m"),
+			coreV1.ResourceMemory: resource.MustParse("8Mi"),
+		},
+	}
+
+	// the base images may have no shell, a static busybox copied into a shared volume stands in for the entrypoint
+	initContainers := []coreV1.Container{{
+		Name:            "install-tool",
+		Image:           warmCacheToolImage,
+		Command:         []string{"cp", "/bin/busybox", warmCacheToolPath + "/busybox"},
+		ImagePullPolicy: coreV1.PullIfNotPresent,
+		Resources:       smallResource,
+		VolumeMounts:    toolMount,
+	}}
+	var containers []coreV1.Container
+	for i, image := range images {
+		containers = append(containers, coreV1.Container{
+			Name:            "pull-" + strconv.Itoa(i),
+			Image:           image,
+			Command:         []string{warmCacheToolPath + "/busybox", "sleep", "2147483647"},
+			ImagePullPolicy: coreV1.PullIfNotPresent,
+			Resources:       smallResource,
+			VolumeMounts:    toolMount,
+		})
+	}
+
+	return &appV1.DaemonSet{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      warmCacheDaemonSetName,
+			Namespace: constants.K8S_SYSTEM_NAMESPACE,
+			Labels:    labels,
+		},
+		Spec: appV1.DaemonSetSpec{
+			Selector: &metaV1.LabelSelector{MatchLabels: labels},
+			Template: coreV1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{Labels: labels},
+				Spec: coreV1.PodSpec{
+					ImagePullSecrets: imagePullSecrets,
+					InitContainers:   initContainers,
+					Containers:       containers,
+					Volumes: []coreV1.Volume{{
+						Name:         "warm-cache-tool",
+						VolumeSource: coreV1.VolumeSource{EmptyDir: &coreV1.EmptyDirVolumeSource{}},
+					}},
+					Tolerations: []coreV1.Toleration{{Operator: coreV1.TolerationOpExists}},
+				},
+			},
+		},
+	}
+}
+
+// nodeCachedImages returns the warm cache images present on the node.
+func nodeCachedImages(node *coreV1.Node, warmImages []string) []string {
+	present := make(map[string]bool)
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			present[docker.NormalizeImageName(name)] = true
+		}
+	}
+
+	cached := make([]string, 0)
+	for _, image := range warmImages {
+		if present[docker.NormalizeImageName(image)] {
+			cached = append(cached, image)
+		}
+	}
+	return cached
+}
+
+// imageLocalityAffinity prefers the nodes that already hold the images of a job.
+func imageLocalityAffinity(images []string) *coreV1.Affinity {
+	if len(images) == 0 {
+		return nil
+	}
+	nodes, err := NewK8sService().GetNodeList()
+	if err != nil {
+		logs.GetLogger().Errorf("Failed list nodes, error: %+v", err)
+		return nil
+	}
+
+	var preferred []coreV1.PreferredSchedulingTerm
+	for _, node := range nodes {
+		holds := nodeCachedImages(&node, images)
+		if len(holds) == 0 {
+			continue
+		}
+		preferred = append(preferred, coreV1.PreferredSchedulingTerm{
+			Weight: int32(100 * len(holds) / len(images)),
+			Preference: coreV1.NodeSelectorTerm{
+				MatchExpressions: []coreV1.NodeSelectorRequirement{{
+					Key:      coreV1.LabelHostname,
+					Operator: coreV1.NodeSelectorOpIn,
+					Values:   []string{node.Labels[coreV1.LabelHostname]},
+				}},
+			},
+		})
+	}
+	if len(preferred) == 0 {
+		return nil
+	}
+	return &coreV1.Affinity{
+		NodeAffinity: &coreV1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: preferred,
+		},
+	}
+}
+
+func ensureSystemNamespace(namespace string) error {
+	k8sService := NewK8sService()
+	if _, err := k8sService.GetNameSpace(context.TODO(), namespace, metaV1.GetOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = k8sService.CreateNameSpace(context.TODO(), &coreV1.Namespace{
+			ObjectMeta: metaV1.ObjectMeta{Name: namespace},
+		}, metaV1.CreateOptions{})
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed create namespace, error: %w", err)
+		}
+	}
+	return nil
+}
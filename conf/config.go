@@ -12,10 +12,26 @@ var config *ComputeNode
 
 // ComputeNode is a compute node config
 type ComputeNode struct {
-	API      API
-	LAD      LAD
-	MCS      MCS
-	Registry Registry
+	API             API
+	Log             Log
+	LAD             LAD
+	Identity        Identity
+	MCS             MCS
+	Storage         Storage
+	Registry        Registry
+	Registries      []Registry
+	Source          Source
+	ImageGC         ImageGC
+	WarmCache       WarmCache
+	ImagePolicy     ImagePolicy
+	TenantIsolation TenantIsolation
+	WalletQuota     WalletQuota
+	GpuInventory    GpuInventory
+	Metering        Metering
+	Batch           Batch
+	Pricing         Pricing
+	Tracing         Tracing
+	Health          Health
 }
 
 type API struct {
@@ -24,11 +40,28 @@ type API struct {
 	RedisUrl      string
 	RedisPassword string
 	Domain        string
+	AdminToken    string
+}
+
+type Log struct {
+	Level  string
+	Format string
+	Dir    string
 }
 
 type LAD struct {
-	ServerUrl   string
-	AccessToken string
+	ServerUrl         string
+	AccessToken       string
+	HeartbeatInterval int
+	RequestTimeout    int
+	MaxBackoff        int
+	Retries           int
+	JobStatusPath     string
+}
+
+type Identity struct {
+	Keystore       string
+	PassphraseFile string
 }
 
 type MCS struct {
@@ -39,10 +72,135 @@ type MCS struct {
 	FileCachePath string
 }
 
+type Storage struct {
+	Backend string
+	Retries int
+	S3      S3Storage
+	Local   LocalStorage
+}
+
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	PublicUrl string
+}
+
+type LocalStorage struct {
+	Dir       string
+	PublicUrl string
+}
+
 type Registry struct {
 	ServerAddress string
 	UserName      string
 	Password      string
+	Repository    string
+	Local         bool
+}
+
+type Source struct {
+	IpfsGateway string
+}
+
+type ImageGC struct {
+	Interval      int
+	HighThreshold int
+	LowThreshold  int
+	MaxAge        int
+}
+
+type WarmCache struct {
+	Enable     bool
+	TopN       int
+	RecentDays int
+	Interval   int
+}
+
+type ImagePolicy struct {
+	Enable             bool
+	FailClosed         bool
+	AllowRegistries    []string
+	DenyRegistries     []string
+	RejectRoot         bool
+	RejectLowPorts     bool
+	Scan               bool
+	ScannerPath        string
+	TrivyDBRepository  string
+	ScanSeverity       string
+	ScanTimeout        int
+	MaxVulnerabilities int
+}
+
+type TenantIsolation struct {
+	Policies         []string
+	IngressNamespace string
+	DenyEgressCIDRs  []string
+	PodSecurityLevel string
+	DefaultCpu       string
+	DefaultMemory    string
+}
+
+type WalletQuota struct {
+	Default   WalletLimit
+	Overrides map[string]WalletLimit
+}
+
+type WalletLimit struct {
+	MaxConcurrentJobs    int `json:"max_concurrent_jobs"`
+	MaxGpus              int `json:"max_gpus"`
+	MaxTotalDuration     int `json:"max_total_duration"`
+	SubmissionsPerMinute int `json:"submissions_per_minute"`
+}
+
+type GpuInventory struct {
+	CollectorNamespace string
+	CollectorSelector  string
+	CollectorPort      int
+	CollectorPath      string
+	CacheTTL           int
+	StaleAfter         int
+}
+
+type Metering struct {
+	Enable   bool
+	Interval int
+}
+
+type Batch struct {
+	CollectorImage string
+	Interval       int
+	LogTailLines   int
+	BackoffLimit   int
+}
+
+type Pricing struct {
+	Currency       string
+	CpuHour        float64
+	MemoryHour     float64
+	DefaultGpuHour float64
+	GpuHour        map[string]float64
+	Autobid        Autobid
+}
+
+type Autobid struct {
+	Enable               bool
+	MinJobPrice          float64
+	MinHourlyPrice       float64
+	MaxCpuUtilization    float64
+	MaxMemoryUtilization float64
+	MaxGpuUtilization    float64
+}
+
+type Tracing struct {
+	Enable      bool
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio *float64
 }
 
 func InitConfig() error {
@@ -59,3 +217,9 @@ func InitConfig() error {
 func GetConfig() *ComputeNode {
 	return config
 }
+
+type Health struct {
+	Interval int
+	Timeout  int
+	Optional []string
+}
@@ -2,6 +2,14 @@ package constants
 
 const StatusActive = "Active"
 const StatusOffline = "Offline"
+const StatusDraining = "Draining"
+const StatusDegraded = "Degraded"
+const StatusFull = "Full"
+
+// job types
+const JobTypeService = "service"
+const JobTypeBatch = "batch"
+const JobTypeCron = "cron"
 
 // bidding status
 const BiddingCreated string = "created"
@@ -12,10 +20,35 @@ const BiddingCompleted string = "completed"
 const BiddingCancelled string = "cancelled"
 
 const TASK_DEPLOY string = "worker.deploy"
+const TASK_DEPLOY_V2 string = "worker.deploy.v2"
 
 const K8S_NAMESPACE_NAME_PREFIX = "ns-"
 const K8S_CONTAINER_NAME_PREFIX = "pod-"
 const K8S_INGRESS_NAME_PREFIX = "ing-"
 const K8S_SERVICE_NAME_PREFIX = "svc-"
 const K8S_DEPLOY_NAME_PREFIX = "deploy-"
+const K8S_JOB_NAME_PREFIX = "job-"
+const K8S_CRONJOB_NAME_PREFIX = "cron-"
+const K8S_IMAGE_PULL_SECRET_NAME = "lad-registry-secret"
+const K8S_SYSTEM_NAMESPACE = "lad-system"
 const REDIS_FULL_PREFIX = "FULL:"
+const REDIS_IMAGE_BUILT = "IMAGE:BUILT"
+const REDIS_IMAGE_REF_PREFIX = "IMAGE:REF:"
+const REDIS_IMAGE_RELEASED = "IMAGE:RELEASED"
+const REDIS_IMAGE_REMOVED = "IMAGE:REMOVED"
+const REDIS_IMAGE_PUSHED = "IMAGE:PUSHED"
+const REDIS_WARM_CACHE_COUNT = "WARM_CACHE:COUNT"
+const REDIS_WARM_CACHE_LAST_USED = "WARM_CACHE:LAST_USED"
+const REDIS_IMAGE_POLICY_PREFIX = "IMAGE_POLICY:"
+const REDIS_WALLET_JOBS_PREFIX = "WALLET:JOBS:"
+const REDIS_WALLET_RATE_PREFIX = "WALLET:RATE:"
+const REDIS_WALLET_REPLACED_PREFIX = "WALLET:REPLACED:"
+const REDIS_JOB_USAGE_PREFIX = "JOB:USAGE:"
+const REDIS_JOB_USAGE_TX_PREFIX = "JOB:USAGE_TX:"
+const REDIS_JOB_USAGE_ACTIVE = "JOB:USAGE_ACTIVE"
+const REDIS_JOB_RECEIPT_PREFIX = "JOB:RECEIPT:"
+const REDIS_JOB_RECEIPT_ACTIVE = "JOB:RECEIPT_ACTIVE"
+const REDIS_JOB_RESULT_PREFIX = "JOB:RESULT:"
+const REDIS_BATCH_ACTIVE = "JOB:BATCH_ACTIVE"
+const REDIS_JOB_SUBMISSION_PREFIX = "JOB:SUBMISSION:"
+const REDIS_JOB_SUBMISSION_KEY_PREFIX = "JOB:SUBMISSION_KEY:"
@@ -5,19 +5,18 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/lagrangedao/go-computing-provider/conf"
@@ -40,6 +39,9 @@ func NewDockerService() *DockerService {
 	}
 }
 
+// ErrNoExposedPort is returned for a Dockerfile without an EXPOSE instruction.
+var ErrNoExposedPort = errors.New("no exposed port found in Dockerfile")
+
 func ExtractExposedPort(dockerfilePath string) (string, error) {
 	file, err := os.Open(dockerfilePath)
 	if err != nil {
@@ -59,22 +61,59 @@ func ExtractExposedPort(dockerfilePath string) (string, error) {
 	}
 
 	if exposedPort == "" {
-		return "", fmt.Errorf("no exposed port found in Dockerfile")
+		return "", ErrNoExposedPort
 	}
 
 	return exposedPort, nil
 }
+
+// ExtractBaseImages returns the external images named by the FROM instructions of a Dockerfile,
+// build stages, scratch and images built from ARG values are skipped.
+func ExtractBaseImages(dockerfilePath string) ([]string, error) {
+	file, err := os.Open(dockerfilePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open Dockerfile: %v", err)
+	}
+	defer file.Close()
+
+	var images []string
+	stages := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+
+		var image string
+		for i := 1; i < len(fields); i++ {
+			if !strings.HasPrefix(fields[i], "--") {
+				image = fields[i]
+				if i+2 < len(fields) && strings.EqualFold(fields[i+1], "AS") {
+					stages[strings.ToLower(fields[i+2])] = true
+				}
+				break
+			}
+		}
+		if image == "" || strings.EqualFold(image, "scratch") || strings.Contains(image, "$") || stages[strings.ToLower(image)] {
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, scanner.Err()
+}
+
 func RunContainer(imageName, dockerfilePath string) string {
 	exposedPort, err := ExtractExposedPort(dockerfilePath)
 	if err != nil {
-		log.Printf("Failed to extract exposed port: %v", err)
+		logs.GetLogger().Errorf("Failed to extract exposed port: %v", err)
 		return ""
 	}
 
 	portMapping := exposedPort + ":" + exposedPort
 	err = RemoveContainerIfExists(imageName)
 	if err != nil {
-		log.Printf("Failed to remove existing container: %v", err)
+		logs.GetLogger().Errorf("Failed to remove existing container: %v", err)
 		return ""
 	}
 	var stdout bytes.Buffer
@@ -85,7 +124,7 @@ func RunContainer(imageName, dockerfilePath string) string {
 
 	err = cmd.Run()
 	if err != nil {
-		log.Printf("run container error: %v\n%s", err, stderr.String())
+		logs.GetLogger().Errorf("run container error: %v\n%s", err, stderr.String())
 		return ""
 	}
 
@@ -100,17 +139,17 @@ func RunContainer(imageName, dockerfilePath string) string {
 
 	err = cmd.Run()
 	if err != nil {
-		log.Printf("get container port error: %v\n%s", err, stderr.String())
+		logs.GetLogger().Errorf("get container port error: %v\n%s", err, stderr.String())
 		return ""
 	}
 
 	portMapping = strings.TrimSpace(stdout.String())
-	fmt.Printf("Port mapping: %s\n", portMapping)
+	logs.GetLogger().Infof("Port mapping: %s", portMapping)
 
 	re := regexp.MustCompile(`0\.0\.0\.0:(\d+)`)
 	match := re.FindStringSubmatch(portMapping)
 	if len(match) < 2 {
-		log.Printf("unexpected port mapping format: %s", portMapping)
+		logs.GetLogger().Errorf("unexpected port mapping format: %s", portMapping)
 		return ""
 	}
 
@@ -133,13 +172,13 @@ func RemoveContainerIfExists(imageName string) error {
 
 	err := cmd.Run()
 	if err != nil {
-		log.Printf("list containers error: %v\n%s", err, stderr.String())
+		logs.GetLogger().Errorf("list containers error: %v\n%s", err, stderr.String())
 		return err
 	}
 
 	containerIDs := strings.Split(strings.TrimSpace(stdout.String()), "\n")
 	if len(containerIDs) == 0 || containerIDs[0] == "" {
-		log.Printf("No container with image %s found.", imageName)
+		logs.GetLogger().Infof("No container with image %s found.", imageName)
 		return nil
 	}
 
@@ -153,23 +192,23 @@ func RemoveContainerIfExists(imageName string) error {
 
 		err = cmd.Run()
 		if err != nil {
-			log.Printf("remove container error: %v\n%s", err, stderr.String())
+			logs.GetLogger().Errorf("remove container error: %v\n%s", err, stderr.String())
 			return err
 		}
 
-		log.Printf("Removed container with ID %s", containerID)
+		logs.GetLogger().Infof("Removed container with ID %s", containerID)
 	}
 
 	return nil
 }
 
-func (ds *DockerService) BuildImage(buildPath, imageName string) error {
+func (ds *DockerService) BuildImage(ctx context.Context, buildPath, imageName string) error {
 	// Create a buffer
 	buf := new(bytes.Buffer)
 	tw := tar.NewWriter(buf)
 	defer tw.Close()
 
-	filepath.Walk(buildPath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(buildPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -197,9 +236,14 @@ func (ds *DockerService) BuildImage(buildPath, imageName string) error {
 		}
 		return nil
 	})
+	if err != nil {
+		logs.FromContext(ctx).Errorf("Failed pack build context %s, error: %v", buildPath, err)
+		return err
+	}
 
+	logs.FromContext(ctx).Infof("Building image %s from %s", imageName, buildPath)
 	dockerFileTarReader := bytes.NewReader(buf.Bytes())
-	buildResponse, err := ds.c.ImageBuild(context.Background(), dockerFileTarReader, types.ImageBuildOptions{
+	buildResponse, err := ds.c.ImageBuild(ctx, dockerFileTarReader, types.ImageBuildOptions{
 		Context: dockerFileTarReader,
 		Tags:    []string{imageName},
 	})
@@ -207,7 +251,7 @@ func (ds *DockerService) BuildImage(buildPath, imageName string) error {
 		return err
 	}
 	defer buildResponse.Body.Close()
-	return printOut(buildResponse.Body)
+	return printOut(ctx, buildResponse.Body)
 }
 
 type ErrorLine struct {
@@ -217,37 +261,35 @@ type ErrorLine struct {
 	} `json:"errorDetail"`
 }
 
-func (ds *DockerService) PushImage(imagesName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*600)
+func (ds *DockerService) PushImage(ctx context.Context, imagesName string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*600)
 	defer cancel()
 
-	var authConfig = types.AuthConfig{
-		ServerAddress: conf.GetConfig().Registry.ServerAddress,
-		Username:      conf.GetConfig().Registry.UserName,
-		Password:      conf.GetConfig().Registry.Password,
+	registry := FindRegistry(imagesName)
+	if registry == nil {
+		registry = newRegistry(conf.GetConfig().Registry)
 	}
-	authConfigBytes, _ := json.Marshal(authConfig)
-	authConfigEncoded := base64.URLEncoding.EncodeToString(authConfigBytes)
 
-	opts := types.ImagePushOptions{RegistryAuth: authConfigEncoded}
+	logs.FromContext(ctx).Infof("Pushing image %s", imagesName)
+	opts := types.ImagePushOptions{RegistryAuth: registry.encodedAuth()}
 	rd, err := ds.c.ImagePush(ctx, imagesName, opts)
 	if err != nil {
 		return err
 	}
 	defer rd.Close()
 
-	if err = printOut(rd); err != nil {
+	if err = printOut(ctx, rd); err != nil {
 		return err
 	}
 	return nil
 }
 
-func printOut(rd io.Reader) error {
+func printOut(ctx context.Context, rd io.Reader) error {
 	var lastLine string
 	scanner := bufio.NewScanner(rd)
 	for scanner.Scan() {
 		lastLine = scanner.Text()
-		println(scanner.Text())
+		logs.FromContext(ctx).Info(lastLine)
 	}
 	errLine := &ErrorLine{}
 	json.Unmarshal([]byte(lastLine), errLine)
@@ -265,34 +307,102 @@ func (ds *DockerService) ListImages() (map[string]string, error) {
 	imageList, err := ds.c.ImageList(ctx, types.ImageListOptions{})
 	if err != nil {
 		logs.GetLogger().Errorf("Unable to list image, error: %+v", err)
+		return nil, err
 	}
 
 	var images = make(map[string]string)
 	for _, image := range imageList {
-		if len(image.RepoTags) == 0 || strings.EqualFold(image.RepoTags[0], "<none>:<none>") {
-			if err := ds.RemoveImage(image.ID); err != nil {
-				logs.GetLogger().Errorf("Failed delete unused image, error: %+v", err)
+		for _, tag := range image.RepoTags {
+			if strings.EqualFold(tag, "<none>:<none>") {
 				continue
 			}
-
-		} else {
-			for _, tag := range image.RepoTags {
-				images[tag] = image.ID
-			}
+			images[tag] = image.ID
 		}
 	}
 	return images, nil
 }
 
+// RemoveImage untags and removes an image, it fails when a container still uses the image.
 func (ds *DockerService) RemoveImage(imageId string) error {
 	ctx := context.Background()
 	_, err := ds.c.ImageRemove(ctx, imageId, types.ImageRemoveOptions{
-		Force:         true,
+		Force:         false,
 		PruneChildren: true,
 	})
+	if client.IsErrNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// PullImage pulls an image with the credentials of the configured registry hosting it.
+func (ds *DockerService) PullImage(imageName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*600)
+	defer cancel()
+
+	var opts types.ImagePullOptions
+	if registry := FindRegistry(imageName); registry != nil {
+		opts.RegistryAuth = registry.encodedAuth()
+	}
+	rd, err := ds.c.ImagePull(ctx, imageName, opts)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	return printOut(ctx, rd)
+}
+
+// InspectImage returns the user and exposed ports from the configuration of a local image.
+func (ds *DockerService) InspectImage(imageName string) (string, []int, error) {
+	inspect, _, err := ds.c.ImageInspectWithRaw(context.Background(), imageName)
+	if err != nil {
+		return "", nil, err
+	}
+	if inspect.Config == nil {
+		return "", nil, nil
+	}
+
+	var ports []int
+	for port := range inspect.Config.ExposedPorts {
+		ports = append(ports, port.Int())
+	}
+	return inspect.Config.User, ports, nil
+}
+
+// Ping checks that the docker daemon answers.
+func (ds *DockerService) Ping(ctx context.Context) error {
+	_, err := ds.c.Ping(ctx)
 	return err
 }
 
+// DiskUsage returns the used percentage of the filesystem holding the docker root directory.
+func (ds *DockerService) DiskUsage() (float64, error) {
+	info, err := ds.c.Info(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(info.DockerRootDir, &stat); err != nil {
+		return 0, err
+	}
+	total := stat.Blocks * uint64(stat.Bsize)
+	if total == 0 {
+		return 0, nil
+	}
+	free := stat.Bavail * uint64(stat.Bsize)
+	return float64(total-free) * 100 / float64(total), nil
+}
+
+// ImageSize returns the size in bytes of a local image.
+func (ds *DockerService) ImageSize(imageName string) (int64, error) {
+	inspect, _, err := ds.c.ImageInspectWithRaw(context.Background(), imageName)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.Size, nil
+}
+
 func (ds *DockerService) CleanResource() {
 	ctx := context.Background()
 	danglingFilters := filters.NewArgs()
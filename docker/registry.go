@@ -0,0 +1,354 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+const dockerHubHost = "docker.io"
+
+var dockerHubAliases = map[string]bool{
+	"":                     true,
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"hub.docker.com":       true,
+	"registry-1.docker.io": true,
+}
+
+var registryClient = &http.Client{Timeout: 60 * time.Second}
+
+// Registry is a container image registry the provider pushes to or pulls from.
+type Registry struct {
+	conf.Registry
+	Host string
+}
+
+func newRegistry(c conf.Registry) *Registry {
+	return &Registry{
+		Registry: c,
+		Host:     RegistryHost(c.ServerAddress),
+	}
+}
+
+// PushRegistry returns the registry that images built by the provider are pushed to,
+// or nil when images are only kept in the local docker daemon.
+func PushRegistry() *Registry {
+	c := conf.GetConfig().Registry
+	if c.UserName == "" && !c.Local {
+		return nil
+	}
+	return newRegistry(c)
+}
+
+// Registries returns every configured registry, the push registry first.
+func Registries() []*Registry {
+	var registries []*Registry
+	if r := PushRegistry(); r != nil {
+		registries = append(registries, r)
+	}
+	for _, c := range conf.GetConfig().Registries {
+		registries = append(registries, newRegistry(c))
+	}
+	return registries
+}
+
+// FindRegistry returns the configured registry hosting imageName.
+func FindRegistry(imageName string) *Registry {
+	host, _, _ := splitImageName(imageName)
+	for _, r := range Registries() {
+		if r.Host == host {
+			return r
+		}
+	}
+	return nil
+}
+
+// IsDockerHub reports whether the registry is Docker Hub.
+func (r *Registry) IsDockerHub() bool {
+	return r.Host == dockerHubHost
+}
+
+// repository is the path images are pushed under, it defaults to the user name like on Docker Hub.
+func (r *Registry) repository() string {
+	repository := strings.Trim(strings.TrimSpace(r.Repository), "/")
+	if repository == "" {
+		repository = strings.TrimSpace(r.UserName)
+	}
+	return repository
+}
+
+// ImageName returns the fully qualified name used to push a space image.
+func (r *Registry) ImageName(spaceName, tag string) string {
+	var parts []string
+	if !r.IsDockerHub() {
+		parts = append(parts, r.Host)
+	}
+	if repository := r.repository(); repository != "" {
+		parts = append(parts, repository)
+	}
+	parts = append(parts, spaceName)
+	return strings.ToLower(strings.Join(parts, "/") + ":" + tag)
+}
+
+// Owns reports whether imageName is under the path the provider pushes to in this registry. Other images
+// may share the path, only the images the provider recorded pushing are its own.
+func (r *Registry) Owns(imageName string) bool {
+	host, repo, _ := splitImageName(imageName)
+	if host != r.Host {
+		return false
+	}
+	repository := strings.ToLower(r.repository())
+	return repository == "" || strings.HasPrefix(repo, repository+"/")
+}
+
+// HasCredentials reports whether the registry needs a pull secret.
+func (r *Registry) HasCredentials() bool {
+	return !r.Local && r.UserName != ""
+}
+
+// AuthConfig returns the credentials for the docker daemon.
+func (r *Registry) AuthConfig() types.AuthConfig {
+	if r.Local {
+		return types.AuthConfig{ServerAddress: r.Host}
+	}
+	return types.AuthConfig{
+		ServerAddress: r.ServerAddress,
+		Username:      r.UserName,
+		Password:      r.Password,
+	}
+}
+
+func (r *Registry) encodedAuth() string {
+	authConfigBytes, _ := json.Marshal(r.AuthConfig())
+	return base64.URLEncoding.EncodeToString(authConfigBytes)
+}
+
+// DockerConfigJson builds the content of a kubernetes.io/dockerconfigjson secret holding the
+// credentials of every registry that needs authentication, it returns nil when there is none.
+func DockerConfigJson(registries []*Registry) ([]byte, error) {
+	type authEntry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	auths := make(map[string]authEntry)
+	for _, r := range registries {
+		if !r.HasCredentials() {
+			continue
+		}
+		server := r.Host
+		if r.IsDockerHub() {
+			server = "https://index.docker.io/v1/"
+		}
+		auths[server] = authEntry{
+			Username: r.UserName,
+			Password: r.Password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(r.UserName + ":" + r.Password)),
+		}
+	}
+	if len(auths) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]interface{}{"auths": auths})
+}
+
+// DeleteTag removes a tag pushed by the provider from its registry.
+func (r *Registry) DeleteTag(imageName string) error {
+	_, repo, tag := splitImageName(imageName)
+	if r.IsDockerHub() {
+		return r.deleteDockerHubTag(repo, tag)
+	}
+
+	scheme := "https"
+	if r.Local || strings.HasPrefix(r.ServerAddress, "http://") {
+		scheme = "http"
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, r.Host, repo, tag)
+
+	req, _ := http.NewRequest(http.MethodHead, manifestURL, nil)
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}, ","))
+	resp, err := r.do(req, repo)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if resp.StatusCode != http.StatusOK || digest == "" {
+		return fmt.Errorf("failed resolve manifest digest of %s, status code: %d", imageName, resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, r.Host, repo, digest), nil)
+	resp, err = r.do(req, repo)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed delete manifest of %s, status code: %d", imageName, resp.StatusCode)
+	}
+	return nil
+}
+
+// do sends a registry API request, answering a bearer token challenge when the registry asks for one.
+func (r *Registry) do(req *http.Request, repo string) (*http.Response, error) {
+	if r.HasCredentials() {
+		req.SetBasicAuth(r.UserName, r.Password)
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	challenge := resp.Header.Get("Www-Authenticate")
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return nil, fmt.Errorf("registry %s rejected the credentials", r.Host)
+	}
+	token, err := r.fetchToken(challenge, repo)
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return registryClient.Do(retry)
+}
+
+func (r *Registry) fetchToken(challenge, repo string) (string, error) {
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	realm, ok := params["realm"]
+	if !ok {
+		return "", errors.New("bearer challenge without realm")
+	}
+	query := url.Values{}
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull,push,delete", repo))
+
+	req, err := http.NewRequest(http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if r.HasCredentials() {
+		req.SetBasicAuth(r.UserName, r.Password)
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed get registry token, status code: %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// deleteDockerHubTag uses the Docker Hub API, the registry API does not allow deleting manifests there.
+func (r *Registry) deleteDockerHubTag(repo, tag string) error {
+	loginBody, _ := json.Marshal(map[string]string{"username": r.UserName, "password": r.Password})
+	resp, err := registryClient.Post("https://hub.docker.com/v2/users/login/", "application/json", bytes.NewReader(loginBody))
+	if err != nil {
+		return err
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+	if err != nil || login.Token == "" {
+		return fmt.Errorf("failed login to docker hub, status code: %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("https://hub.docker.com/v2/repositories/%s/tags/%s/", repo, tag), nil)
+	req.Header.Set("Authorization", "JWT "+login.Token)
+	resp, err = registryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed delete docker hub tag %s:%s, status code: %d, %s", repo, tag, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// NormalizeImageName returns the fully qualified host/repository:tag form of an image reference,
+// so that references written differently by users and by the container runtime can be compared.
+func NormalizeImageName(imageName string) string {
+	host, repo, tag := splitImageName(imageName)
+	return host + "/" + repo + ":" + tag
+}
+
+// RegistryHost normalizes a configured server address to the host used in image names.
+func RegistryHost(serverAddress string) string {
+	host := strings.TrimSpace(serverAddress)
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	host = strings.ToLower(host)
+	if dockerHubAliases[host] {
+		return dockerHubHost
+	}
+	return host
+}
+
+// splitImageName splits an image reference into registry host, repository and tag.
+func splitImageName(imageName string) (string, string, string) {
+	name := strings.ToLower(imageName)
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	tag := "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		tag = name[i+1:]
+		name = name[:i]
+	}
+
+	host := dockerHubHost
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		host = RegistryHost(parts[0])
+		name = parts[1]
+	}
+	if host == dockerHubHost && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return host, name, tag
+}
@@ -0,0 +1,98 @@
+package initializer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+// The passphrases of the keystore files written by export and read by import.
+const (
+	exportPassphraseEnv = "CP_EXPORT_PASSPHRASE"
+	importPassphraseEnv = "CP_IMPORT_PASSPHRASE"
+)
+
+const identityUsage = `usage: computing-provider identity [-insecure] <command>
+
+  -insecure             allow an empty keystore passphrase
+
+commands:
+  show                  print the node id and wallet address of the provider
+  export <file>         write the provider key as keystore JSON encrypted with $` + exportPassphraseEnv + `
+  import [-force] <file>
+                        replace the provider key with a keystore JSON file encrypted with $` + importPassphraseEnv + `,
+                        or with a file holding a hex encoded private key when $` + importPassphraseEnv + ` is not set`
+
+// IdentityCommand manages the provider identity kept in the keystore.
+func IdentityCommand(args []string) error {
+	if err := conf.InitConfig(); err != nil {
+		return err
+	}
+	global := flag.NewFlagSet("identity", flag.ContinueOnError)
+	insecure := global.Bool("insecure", false, "allow an empty keystore passphrase")
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+	computing.SetInsecureKeystore(*insecure)
+	args = global.Args()
+	if len(args) == 0 {
+		return errors.New(identityUsage)
+	}
+
+	switch args[0] {
+	case "show":
+		id, err := computing.ShowIdentity()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("node id: %s\nwallet address: %s\n", id.NodeId(), id.Address())
+		return nil
+
+	case "export":
+		if len(args) != 2 {
+			return errors.New(identityUsage)
+		}
+		passphrase, ok := os.LookupEnv(exportPassphraseEnv)
+		if !ok || passphrase == "" {
+			return fmt.Errorf("set %s to the passphrase of the exported keystore", exportPassphraseEnv)
+		}
+		id, err := computing.LoadIdentity()
+		if err != nil {
+			return err
+		}
+		keyJson, err := id.Export(passphrase)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(args[1], keyJson, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("exported %s to %s\n", id.Address(), args[1])
+		return nil
+
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ContinueOnError)
+		force := flags.Bool("force", false, "replace the existing keystore")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New(identityUsage)
+		}
+		key, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		address, err := computing.ImportIdentity(key, os.Getenv(importPassphraseEnv), *force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %s\n", address)
+		return nil
+	}
+	return errors.New(identityUsage)
+}
@@ -1,70 +1,38 @@
 package initializer
 
 import (
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strings"
-	"time"
+	"context"
 
-	"github.com/filswan/go-swan-lib/logs"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
 	"github.com/lagrangedao/go-computing-provider/computing"
 	"github.com/lagrangedao/go-computing-provider/conf"
 	"github.com/lagrangedao/go-computing-provider/constants"
 )
 
-func sendHeartbeat(nodeId string) {
-	// Replace the following URL with your Flask application's heartbeat endpoint URL
-	heartbeatURL := conf.GetConfig().LAD.ServerUrl + "/cp/heartbeat"
-	payload := strings.NewReader(fmt.Sprintf(`{
-    "node_id": "%s",
-    "status": "Active"
-}`, nodeId))
-
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", heartbeatURL, payload)
-	if err != nil {
-		logs.GetLogger().Errorf("Error creating request: %v", err)
-		return
+// ProjectInit starts the provider, the returned function flushes the pending spans on shutdown.
+func ProjectInit() func(context.Context) error {
+	if err := conf.InitConfig(); err != nil {
+		logs.GetLogger().Fatal(err)
 	}
-	// Set the API token in the request header (replace "your_api_token" with the actual token)
-	req.Header.Set("Authorization", "Bearer "+conf.GetConfig().LAD.AccessToken)
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := client.Do(req)
-	if err != nil {
-		logs.GetLogger().Errorf("Error sending heartbeat, retrying to connect to the LAD server: %v", err)
-		computing.Reconnect(nodeId)
-	} else {
-		body, err := ioutil.ReadAll(resp.Body)
-		logs.GetLogger().Infof("Heartbeat sent. Status code: %d\n %s", resp.StatusCode, string(body))
-		if resp.StatusCode != http.StatusOK {
-			logs.GetLogger().Warningln("Retrying to connect to the LAD server")
-			computing.Reconnect(nodeId)
-		}
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	logConfig := conf.GetConfig().Log
+	if err := logs.Init(logConfig.Level, logConfig.Format, logConfig.Dir); err != nil {
+		logs.GetLogger().Fatal(err)
 	}
-}
-
-func sendHeartbeats(nodeId string) {
-	ticker := time.NewTicker(5 * time.Second)
-	for range ticker.C {
-		sendHeartbeat(nodeId)
+	if err := computing.CheckTenantIsolation(conf.GetConfig().TenantIsolation); err != nil {
+		logs.GetLogger().Fatalf("Invalid TenantIsolation config, error: %v", err)
 	}
-}
-func ProjectInit() {
-	if err := conf.InitConfig(); err != nil {
-		logs.GetLogger().Fatal(err)
+	shutdownTracing, err := computing.InitTracing(context.Background())
+	if err != nil {
+		logs.GetLogger().Fatalf("Failed init tracing, error: %v", err)
 	}
 	nodeID := computing.InitComputingProvider()
-	// Start sending heartbeats
-	go sendHeartbeats(nodeID)
+	computing.StartHeartbeat(context.Background(), nodeID)
 
 	computing.RunSyncTask()
 	celeryService := computing.NewCeleryService()
-	celeryService.RegisterTask(constants.TASK_DEPLOY, computing.DeploySpaceTask)
+	// tasks queued by an earlier version carry the arguments of worker.deploy
+	celeryService.RegisterTask(constants.TASK_DEPLOY, computing.DeploySpaceTaskV1)
+	celeryService.RegisterTask(constants.TASK_DEPLOY_V2, computing.DeploySpaceTask)
 	celeryService.Start()
-
+	return shutdownTracing
 }
@@ -0,0 +1,74 @@
+package lad
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/lagrangedao/go-computing-provider/models"
+)
+
+const (
+	PathRegister  = "/cp"
+	PathHeartbeat = "/cp/heartbeat"
+	PathSummary   = "/cp/summary"
+)
+
+// The headers of a signed request.
+const (
+	HeaderAddress   = "X-Provider-Address"
+	HeaderTimestamp = "X-Provider-Timestamp"
+	HeaderSignature = "X-Provider-Signature"
+)
+
+// SigningMessage is the message signed for a request: the method, path, unix timestamp and body separated
+// by newlines. The timestamp lets the server refuse replayed requests.
+func SigningMessage(method, path, timestamp string, body []byte) []byte {
+	message := []byte(method + "\n" + path + "\n" + timestamp + "\n")
+	return append(message, body...)
+}
+
+// Response is the envelope of the responses of the LAD server.
+type Response struct {
+	Status  string          `json:"status"`
+	Code    string          `json:"code,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type HeartbeatReq struct {
+	NodeId string `json:"node_id"`
+	Status string `json:"status"`
+}
+
+// JobStatusReq reports a change of the state of a job to the LAD server.
+type JobStatusReq struct {
+	NodeId       string `json:"node_id"`
+	JobUuid      string `json:"job_uuid"`
+	Status       string `json:"status"`
+	JobResultURI string `json:"job_result_uri,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// Register creates or updates the provider on the LAD server.
+func (c *Client) Register(ctx context.Context, provider *models.ComputingProvider) error {
+	return c.do(ctx, http.MethodPost, PathRegister, provider, nil, true)
+}
+
+// Heartbeat sends one heartbeat, it is not retried since the next heartbeat follows shortly.
+func (c *Client) Heartbeat(ctx context.Context, req *HeartbeatReq) error {
+	return c.do(ctx, http.MethodPost, PathHeartbeat, req, nil, false)
+}
+
+// ReportSummary sends the resources of the nodes of the cluster.
+func (c *Client) ReportSummary(ctx context.Context, summary *models.ClusterResource) error {
+	return c.do(ctx, http.MethodPost, PathSummary, summary, nil, true)
+}
+
+// UpdateJobStatus reports the state of a job to the JobStatusPath of the client, nothing is sent without one.
+func (c *Client) UpdateJobStatus(ctx context.Context, req *JobStatusReq) error {
+	if c.opts.JobStatusPath == "" {
+		return nil
+	}
+	return c.do(ctx, http.MethodPost, c.opts.JobStatusPath, req, nil, true)
+}
@@ -0,0 +1,220 @@
+package lad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout   = 10 * time.Second
+	defaultRetryWait = 500 * time.Millisecond
+	maxErrorBody     = 1024
+)
+
+// Options configures a Client, zero values fall back to the defaults.
+type Options struct {
+	ServerUrl   string
+	AccessToken string
+	// Timeout bounds each attempt of a request.
+	Timeout time.Duration
+	// Retries is the number of times a retryable failure is retried.
+	Retries int
+	// RetryWait is the delay before the first retry, doubled for each following one.
+	RetryWait  time.Duration
+	HttpClient *http.Client
+	// Signer signs each request when set, see SigningMessage.
+	Signer Signer
+	// JobStatusPath is the path job states are reported to, they are not reported when empty.
+	JobStatusPath string
+}
+
+// Signer signs the requests of the provider, the LAD server checks the signatures against the
+// wallet address the provider registered with.
+type Signer interface {
+	Address() string
+	Sign(data []byte) (string, error)
+}
+
+// Client calls the API of the LAD server with the access token of the provider.
+type Client struct {
+	opts Options
+}
+
+func NewClient(opts Options) *Client {
+	opts.ServerUrl = strings.TrimSuffix(opts.ServerUrl, "/")
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.RetryWait <= 0 {
+		opts.RetryWait = defaultRetryWait
+	}
+	if opts.HttpClient == nil {
+		opts.HttpClient = http.DefaultClient
+	}
+	return &Client{opts: opts}
+}
+
+// APIError is returned for a request the server answered with an error status.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Body
+	}
+	return fmt.Sprintf("%s %s returned status %d: %s", e.Method, e.Path, e.StatusCode, msg)
+}
+
+// IsNotFound reports whether the server does not know the resource, for a provider this means it must register again.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone)
+}
+
+// IsUnauthorized reports whether the server rejected the access token.
+func IsUnauthorized(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// IsRetryable reports whether the request may succeed when sent again: the server could not be reached,
+// the attempt timed out or the server failed with a 5xx status. Requests that could not be built or signed,
+// and responses that could not be read after a 2xx status, are not retried. A cancelled context never is.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var respErr *responseError
+	if errors.As(err, &respErr) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// responseError is returned when the server processed a request but its response could not be read,
+// sending the request again would repeat it.
+type responseError struct {
+	path string
+	err  error
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("failed decode response of %s: %v", e.path, e.err)
+}
+
+func (e *responseError) Unwrap() error {
+	return e.err
+}
+
+// do sends the request, retrying retryable failures when retry is set, and decodes the response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, retry bool) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed marshal request of %s: %w", path, err)
+		}
+	}
+
+	attempts := 1
+	if retry {
+		attempts += c.opts.Retries
+	}
+	wait := c.opts.RetryWait
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			wait *= 2
+		}
+		if err = c.send(ctx, method, path, payload, out); !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.opts.ServerUrl+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.opts.AccessToken)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Signer != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		signature, err := c.opts.Signer.Sign(SigningMessage(method, path, timestamp, payload))
+		if err != nil {
+			return fmt.Errorf("failed sign request of %s: %w", path, err)
+		}
+		req.Header.Set(HeaderAddress, c.opts.Signer.Address())
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, signature)
+	}
+
+	resp, err := c.opts.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: string(respBody)}
+		var basic Response
+		if json.Unmarshal(respBody, &basic) == nil {
+			apiErr.Message = basic.Message
+		}
+		return apiErr
+	}
+	if out == nil {
+		if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+			return &responseError{path: path, err: err}
+		}
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+		return &responseError{path: path, err: err}
+	}
+	return nil
+}
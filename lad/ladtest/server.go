@@ -0,0 +1,205 @@
+// Package ladtest provides an in-memory LAD server for testing the provider offline.
+package ladtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/lagrangedao/go-computing-provider/lad"
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/wallet"
+)
+
+// JobStatusPath is the path the server takes job states on.
+const JobStatusPath = "/cp/job/status"
+
+// Server records the requests of the provider. A heartbeat of a node that is not registered is answered
+// with 404, and statuses queued by FailNext are returned before a path is served again. Signed requests
+// are verified, and the heartbeats of a node registered with a wallet address must be signed by it.
+type Server struct {
+	*httptest.Server
+	AccessToken string
+
+	mu          sync.Mutex
+	providers   map[string]models.ComputingProvider
+	heartbeats  []lad.HeartbeatReq
+	summaries   []models.ClusterResource
+	jobStatuses []lad.JobStatusReq
+	failures    map[string][]int
+	requests    map[string]int
+	signers     map[string]string
+}
+
+func NewServer(accessToken string) *Server {
+	s := &Server{
+		AccessToken: accessToken,
+		providers:   make(map[string]models.ComputingProvider),
+		failures:    make(map[string][]int),
+		requests:    make(map[string]int),
+		signers:     make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(lad.PathRegister, s.handle(s.register))
+	mux.HandleFunc(lad.PathHeartbeat, s.handle(s.heartbeat))
+	mux.HandleFunc(lad.PathSummary, s.handle(s.summary))
+	mux.HandleFunc(JobStatusPath, s.handle(s.jobStatus))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client returns a client of the server, the server url, access token and job status path of opts are replaced.
+func (s *Server) Client(opts lad.Options) *lad.Client {
+	opts.ServerUrl = s.URL
+	opts.AccessToken = s.AccessToken
+	opts.JobStatusPath = JobStatusPath
+	return lad.NewClient(opts)
+}
+
+// FailNext answers the next requests of path with the statuses, one per request.
+func (s *Server) FailNext(path string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = append(s.failures[path], statuses...)
+}
+
+// Forget drops the registration of a node, as a server restored from an old state would.
+func (s *Server) Forget(nodeId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.providers, nodeId)
+}
+
+func (s *Server) Provider(nodeId string) (models.ComputingProvider, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	provider, ok := s.providers[nodeId]
+	return provider, ok
+}
+
+// Requests returns the number of requests of path, failed ones included.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// SignedBy returns the address that signed the last request of path, empty for an unsigned request.
+func (s *Server) SignedBy(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signers[path]
+}
+
+func (s *Server) Heartbeats() []lad.HeartbeatReq {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]lad.HeartbeatReq(nil), s.heartbeats...)
+}
+
+func (s *Server) Summaries() []models.ClusterResource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.ClusterResource(nil), s.summaries...)
+}
+
+func (s *Server) JobStatuses() []lad.JobStatusReq {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]lad.JobStatusReq(nil), s.jobStatuses...)
+}
+
+func (s *Server) handle(serve func(r *http.Request) (int, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests[r.URL.Path]++
+
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		signer, signErr := verifySignature(r, body)
+		s.signers[r.URL.Path] = signer
+
+		code, message := http.StatusOK, ""
+		switch {
+		case r.Method != http.MethodPost:
+			code, message = http.StatusMethodNotAllowed, "method not allowed"
+		case r.Header.Get("Authorization") != "Bearer "+s.AccessToken:
+			code, message = http.StatusUnauthorized, "invalid access token"
+		case signErr != nil:
+			code, message = http.StatusUnauthorized, signErr.Error()
+		case len(s.failures[r.URL.Path]) > 0:
+			code, message = s.failures[r.URL.Path][0], "injected failure"
+			s.failures[r.URL.Path] = s.failures[r.URL.Path][1:]
+		default:
+			code, message = serve(r)
+		}
+
+		resp := lad.Response{Status: "success", Message: message}
+		if code != http.StatusOK {
+			resp.Status = "failed"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *Server) register(r *http.Request) (int, string) {
+	var provider models.ComputingProvider
+	if err := json.NewDecoder(r.Body).Decode(&provider); err != nil || provider.NodeId == "" {
+		return http.StatusBadRequest, "invalid provider"
+	}
+	s.providers[provider.NodeId] = provider
+	return http.StatusOK, ""
+}
+
+func (s *Server) heartbeat(r *http.Request) (int, string) {
+	var req lad.HeartbeatReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, "invalid heartbeat"
+	}
+	provider, ok := s.providers[req.NodeId]
+	if !ok {
+		return http.StatusNotFound, "node not registered"
+	}
+	if provider.WalletAddress != "" && !strings.EqualFold(r.Header.Get(lad.HeaderAddress), provider.WalletAddress) {
+		return http.StatusUnauthorized, "heartbeat not signed by the provider wallet"
+	}
+	s.heartbeats = append(s.heartbeats, req)
+	return http.StatusOK, ""
+}
+
+func (s *Server) summary(r *http.Request) (int, string) {
+	var summary models.ClusterResource
+	if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+		return http.StatusBadRequest, "invalid summary"
+	}
+	s.summaries = append(s.summaries, summary)
+	return http.StatusOK, ""
+}
+
+func (s *Server) jobStatus(r *http.Request) (int, string) {
+	var req lad.JobStatusReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JobUuid == "" {
+		return http.StatusBadRequest, "invalid job status"
+	}
+	s.jobStatuses = append(s.jobStatuses, req)
+	return http.StatusOK, ""
+}
+
+func verifySignature(r *http.Request, body []byte) (string, error) {
+	signature := r.Header.Get(lad.HeaderSignature)
+	if signature == "" {
+		return "", nil
+	}
+	address, timestamp := r.Header.Get(lad.HeaderAddress), r.Header.Get(lad.HeaderTimestamp)
+	if err := wallet.Verify(address, lad.SigningMessage(r.Method, r.URL.Path, timestamp, body), signature); err != nil {
+		return "", err
+	}
+	return address, nil
+}
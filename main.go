@@ -1,21 +1,52 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
-	"github.com/filswan/go-swan-lib/logs"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	cors "github.com/itsjamie/gin-cors"
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+	"github.com/lagrangedao/go-computing-provider/computing"
 	"github.com/lagrangedao/go-computing-provider/conf"
 	"github.com/lagrangedao/go-computing-provider/initializer"
 	"github.com/lagrangedao/go-computing-provider/routers"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "identity" {
+		if err := initializer.IdentityCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	insecure := flags.Bool("insecure", false, "allow an empty keystore passphrase")
+	flags.Parse(os.Args[1:])
+	computing.SetInsecureKeystore(*insecure)
+
 	logs.GetLogger().Info("Start in computing provider mode.")
-	initializer.ProjectInit()
+	shutdown := initializer.ProjectInit()
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			logs.GetLogger().Errorf("Failed flush spans, error: %v", err)
+		}
+		os.Exit(0)
+	}()
 
 	r := gin.Default()
 	r.Use(cors.Middleware(cors.Config{
@@ -26,7 +57,11 @@ func main() {
 		MaxAge:          50 * time.Second,
 		ValidateHeaders: false,
 	}))
+	r.Use(computing.TracingMiddleware())
 	pprof.Register(r)
+	r.GET("/metrics", computing.MetricsHandler())
+	r.GET("/healthz", computing.Healthz)
+	r.GET("/readyz", computing.Readyz)
 
 	v1 := r.Group("/api/v1")
 	routers.CPManager(v1.Group("/computing"))
@@ -5,21 +5,28 @@ type ComputingProvider struct {
 	NodeId        string `json:"node_id"`
 	MultiAddress  string `json:"multi_address"`
 	Autobid       int    `json:"autobid"`
-	WalletAddress int    `json:"wallet_address"`
+	WalletAddress string `json:"wallet_address,omitempty"`
 }
 
 type JobData struct {
-	UUID          string `json:"uuid"`
-	Name          string `json:"name"`
-	Status        string `json:"status"`
-	Duration      int    `json:"duration"`
-	Hardware      string `json:"hardware"`
-	JobSourceURI  string `json:"job_source_uri"`
-	JobResultURI  string `json:"job_result_uri"`
-	StorageSource string `json:"storage_source"`
-	TaskUUID      string `json:"task_uuid"`
-	CreatedAt     string `json:"created_at"`
-	UpdatedAt     string `json:"updated_at"`
+	UUID          string  `json:"uuid"`
+	Name          string  `json:"name"`
+	Status        string  `json:"status"`
+	Duration      int     `json:"duration"`
+	Hardware      string  `json:"hardware"`
+	JobSourceURI  string  `json:"job_source_uri"`
+	JobResultURI  string  `json:"job_result_uri"`
+	StorageSource string  `json:"storage_source"`
+	TaskUUID      string  `json:"task_uuid"`
+	CreatedAt     string  `json:"created_at"`
+	UpdatedAt     string  `json:"updated_at"`
+	Price         float64 `json:"price,omitempty"`
+	// Type is service, batch or cron, a service when empty.
+	Type string `json:"type,omitempty"`
+	// Schedule is the cron schedule of a cron job.
+	Schedule string `json:"schedule,omitempty"`
+	// HostName is the host a service is served on.
+	HostName string `json:"host_name,omitempty"`
 }
 
 type DeleteJobReq struct {
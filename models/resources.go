@@ -7,13 +7,15 @@ type ClusterResource struct {
 }
 
 type NodeResource struct {
-	MachineId string `json:"machine_id"`
-	Model     string `json:"model"`
-	Cpu       Common `json:"cpu"`
-	Vcpu      Common `json:"vcpu"`
-	Memory    Common `json:"memory"`
-	Gpu       Gpu    `json:"gpu"`
-	Storage   Common `json:"storage"`
+	MachineId     string   `json:"machine_id"`
+	Model         string   `json:"model"`
+	Cpu           Common   `json:"cpu"`
+	Vcpu          Common   `json:"vcpu"`
+	Memory        Common   `json:"memory"`
+	Gpu           Gpu      `json:"gpu"`
+	GpuAllocation Common   `json:"gpu_allocation"`
+	Storage       Common   `json:"storage"`
+	CachedImages  []string `json:"cached_images"`
 }
 
 type Gpu struct {
@@ -21,6 +23,10 @@ type Gpu struct {
 	CudaVersion   string      `json:"cuda_version"`
 	AttachedGpus  int         `json:"attached_gpus"`
 	Details       []GpuDetail `json:"details"`
+	Shares        []GpuShare  `json:"shares"`
+	Source        string      `json:"source,omitempty"`
+	UpdatedAt     int64       `json:"updated_at,omitempty"`
+	Stale         bool        `json:"stale,omitempty"`
 }
 
 type GpuDetail struct {
@@ -29,10 +35,27 @@ type GpuDetail struct {
 	Bar1MemoryUsage Common `json:"bar1_memory_usage"`
 }
 
+// GpuShare is a fractional GPU resource of a node, a MIG profile or time-sliced replicas.
+type GpuShare struct {
+	Resource string `json:"resource"`
+	Product  string `json:"product"`
+	Memory   string `json:"memory"`
+	Total    int64  `json:"total"`
+	Used     int64  `json:"used"`
+	Free     int64  `json:"free"`
+}
+
+// Common reports a resource as human readable strings, node resources also carry the exact
+// values in Unit, millicores for CPU, bytes for memory and storage, devices for GPU.
+// The values are always sent, an idle or full node reports a used or free value of 0.
 type Common struct {
-	Total string `json:"total"`
-	Used  string `json:"used"`
-	Free  string `json:"free"`
+	Total      string `json:"total"`
+	Used       string `json:"used"`
+	Free       string `json:"free"`
+	Unit       string `json:"unit,omitempty"`
+	TotalValue int64  `json:"total_value"`
+	UsedValue  int64  `json:"used_value"`
+	FreeValue  int64  `json:"free_value"`
 }
 
 type ResourceStatus struct {
@@ -3,9 +3,11 @@ package routers
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
 )
 
 func CPManager(router *gin.RouterGroup) {
+	admin := computing.AdminAuth(conf.GetConfig().API.AdminToken)
 
 	router.GET("/host/info", computing.GetServiceProviderInfo)
 	router.POST("/lagrange/jobs", computing.ReceiveJob)
@@ -13,4 +15,14 @@ func CPManager(router *gin.RouterGroup) {
 	router.DELETE("/lagrange/jobs", computing.DeleteJob)
 	router.GET("/cp", computing.StatisticalSources)
 	router.POST("/lagrange/jobs/renew", computing.ReNewJob)
+	router.GET("/lagrange/jobs/:uuid/image_policy", computing.GetImagePolicyVerdict)
+	router.GET("/lagrange/jobs/:uuid/usage", computing.GetJobUsage)
+	router.GET("/lagrange/jobs/:uuid/receipts", computing.GetJobReceipts)
+	router.GET("/lagrange/jobs/:uuid/results", computing.GetJobResults)
+	router.POST("/lagrange/receipts/verify", computing.VerifyJobReceipt)
+	router.POST("/quote", computing.QuoteJob)
+	router.PUT("/drain", admin, computing.Drain)
+	router.GET("/quotas/:wallet", computing.GetWalletQuota)
+	router.GET("/images/gc", computing.ImageGCRecords)
+	router.POST("/images/gc", admin, computing.RunImageGC)
 }
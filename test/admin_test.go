@@ -0,0 +1,44 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/computing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "no token configured", authorization: "Bearer ", want: http.StatusForbidden},
+		{name: "no token configured, any header", authorization: "Bearer secret", want: http.StatusForbidden},
+		{name: "missing header", token: "secret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer other", want: http.StatusUnauthorized},
+		{name: "token without scheme", token: "secret", authorization: "secret", want: http.StatusUnauthorized},
+		{name: "token prefix", token: "secret", authorization: "Bearer secre", want: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", authorization: "Bearer secret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.PUT("/drain", computing.AdminAuth(tt.token), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodPut, "/drain", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
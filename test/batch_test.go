@@ -0,0 +1,321 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const batchYaml = `
+version: "2.0"
+services:
+  train:
+    image: python:3.11
+    command: ["python", "train.py"]
+    outputs:
+      - /workspace/model
+      - /workspace/metrics
+profiles:
+  compute:
+    train:
+      resources:
+        cpu:
+          units: 2
+        memory:
+          size: 4Gi
+deployment:
+  train:
+    akash:
+      profile: train
+      count: 1
+`
+
+func TestParseYamlOutputs(t *testing.T) {
+	resources, err := yaml.ParseYaml([]byte(batchYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 {
+		t.Fatalf("got %d resources", len(resources))
+	}
+	outputs := resources[0].Outputs
+	if len(outputs) != 2 || outputs[0] != "/workspace/model" || outputs[1] != "/workspace/metrics" {
+		t.Errorf("outputs = %v", outputs)
+	}
+}
+
+func TestNewBatchJob(t *testing.T) {
+	workload := coreV1.Container{Name: "pod-space-train", Image: "python:3.11"}
+	job := computing.NewBatchJob("ns-0xabc", "space", coreV1.PodSpec{}, workload,
+		computing.BatchOptions{Outputs: []string{"/workspace/model", "/workspace/metrics"}, Deadline: 3600})
+
+	if job.Name != "job-space" || job.Labels["lad_app"] != "space" {
+		t.Errorf("job meta = %+v", job.ObjectMeta)
+	}
+	if *job.Spec.BackoffLimit != 0 || *job.Spec.ActiveDeadlineSeconds != 3600 {
+		t.Errorf("backoffLimit = %d, activeDeadlineSeconds = %d", *job.Spec.BackoffLimit, *job.Spec.ActiveDeadlineSeconds)
+	}
+
+	spec := job.Spec.Template.Spec
+	if spec.RestartPolicy != coreV1.RestartPolicyNever {
+		t.Errorf("restartPolicy = %s", spec.RestartPolicy)
+	}
+	if len(spec.InitContainers) != 1 || spec.InitContainers[0].Name != workload.Name {
+		t.Fatalf("init containers = %+v", spec.InitContainers)
+	}
+	mounts := spec.InitContainers[0].VolumeMounts
+	if len(mounts) != 2 || mounts[0].MountPath != "/workspace/model" || mounts[0].SubPath != "0" || mounts[1].SubPath != "1" {
+		t.Errorf("workload mounts = %+v", mounts)
+	}
+
+	if len(spec.Containers) != 1 {
+		t.Fatalf("containers = %+v", spec.Containers)
+	}
+	collector := spec.Containers[0]
+	script := strings.Join(collector.Command, " ")
+	for _, want := range []string{"/lad/outputs/0", "/lad/collect/1.tar.gz", "/lad/collect/ready"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("collector command %q does not contain %q", script, want)
+		}
+	}
+	// pod security is opt-in, nothing is forced on the workload without it
+	if spec.SecurityContext != nil || spec.InitContainers[0].SecurityContext != nil {
+		t.Errorf("security context = %+v, workload security context = %+v", spec.SecurityContext, spec.InitContainers[0].SecurityContext)
+	}
+}
+
+func TestNewBatchJobWithoutDuration(t *testing.T) {
+	job := computing.NewBatchJob("ns-0xabc", "space", coreV1.PodSpec{}, coreV1.Container{Name: "train"}, computing.BatchOptions{Outputs: []string{"/out"}})
+	if job.Spec.ActiveDeadlineSeconds != nil {
+		t.Errorf("activeDeadlineSeconds = %d", *job.Spec.ActiveDeadlineSeconds)
+	}
+}
+
+func TestNewCronJob(t *testing.T) {
+	workload := coreV1.Container{Name: "pod-space-report", Image: "python:3.11"}
+	cronJob := computing.NewCronJob("ns-0xabc", "space", coreV1.PodSpec{}, workload,
+		computing.BatchOptions{Outputs: []string{"/out"}, BackoffLimit: 2, Schedule: "0 * * * *"})
+
+	if cronJob.Name != "cron-space" || cronJob.Labels["lad_app"] != "space" {
+		t.Errorf("cronJob meta = %+v", cronJob.ObjectMeta)
+	}
+	if cronJob.Spec.Schedule != "0 * * * *" || cronJob.Spec.ConcurrencyPolicy != batchV1.ForbidConcurrent {
+		t.Errorf("schedule = %q, concurrencyPolicy = %s", cronJob.Spec.Schedule, cronJob.Spec.ConcurrencyPolicy)
+	}
+	jobSpec := cronJob.Spec.JobTemplate.Spec
+	if *jobSpec.BackoffLimit != 2 || jobSpec.ActiveDeadlineSeconds != nil {
+		t.Errorf("backoffLimit = %d, activeDeadlineSeconds = %v", *jobSpec.BackoffLimit, jobSpec.ActiveDeadlineSeconds)
+	}
+	if init := jobSpec.Template.Spec.InitContainers; len(init) != 1 || init[0].Name != workload.Name {
+		t.Errorf("init containers = %+v", init)
+	}
+}
+
+const multiBatchYaml = `
+version: "2.0"
+services:
+  train:
+    image: python:3.11
+    command: ["python", "train.py"]
+  evaluate:
+    image: python:3.11
+    command: ["python", "evaluate.py"]
+profiles:
+  compute:
+    batch:
+      resources:
+        cpu:
+          units: 1
+        memory:
+          size: 1Gi
+deployment:
+  train:
+    akash:
+      profile: batch
+      count: 1
+  evaluate:
+    akash:
+      profile: batch
+      count: 1
+`
+
+func TestBatchJobsOfMultipleServices(t *testing.T) {
+	resources, err := yaml.ParseYaml([]byte(multiBatchYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("got %d resources", len(resources))
+	}
+
+	jobNames := map[string]bool{}
+	cronJobNames := map[string]bool{}
+	fields := map[string]bool{}
+	for _, resource := range resources {
+		opts := computing.BatchOptions{Service: resource.Name, Schedule: "0 * * * *"}
+		workload := coreV1.Container{Name: "pod-space-" + resource.Name, Image: resource.ImageName}
+		job := computing.NewBatchJob("ns-0xabc", "space", coreV1.PodSpec{}, workload, opts)
+		if want := "job-space-" + resource.Name; job.Name != want {
+			t.Errorf("job name = %s, want %s", job.Name, want)
+		}
+		jobNames[job.Name] = true
+		cronJobNames[computing.NewCronJob("ns-0xabc", "space", coreV1.PodSpec{}, workload, opts).Name] = true
+		fields[computing.BatchRunField("uuid", resource.Name)] = true
+	}
+	if len(jobNames) != 2 || len(cronJobNames) != 2 || len(fields) != 2 {
+		t.Errorf("jobs = %v, cronJobs = %v, redis fields = %v", jobNames, cronJobNames, fields)
+	}
+	if field := computing.BatchRunField("uuid", ""); field != "uuid" {
+		t.Errorf("BatchRunField() of a single image = %s", field)
+	}
+}
+
+func TestJobHasBatchRuns(t *testing.T) {
+	conn := redisConn(t)
+	if active, err := computing.JobHasBatchRuns(conn, "uuid"); err != nil || active {
+		t.Fatalf("JobHasBatchRuns() without runs = %t, %v", active, err)
+	}
+
+	conn.Do("HSET", constants.REDIS_BATCH_ACTIVE, computing.BatchRunField("uuid-2", "train"), "{}")
+	conn.Do("HSET", constants.REDIS_BATCH_ACTIVE, computing.BatchRunField("uuid", "evaluate"), "{}")
+	if active, err := computing.JobHasBatchRuns(conn, "uuid"); err != nil || !active {
+		t.Errorf("JobHasBatchRuns() with a run left = %t, %v", active, err)
+	}
+
+	conn.Do("HDEL", constants.REDIS_BATCH_ACTIVE, computing.BatchRunField("uuid", "evaluate"))
+	if active, err := computing.JobHasBatchRuns(conn, "uuid"); err != nil || active {
+		t.Errorf("JobHasBatchRuns() with only runs of another job = %t, %v", active, err)
+	}
+}
+
+func TestResolveJobType(t *testing.T) {
+	tests := []struct {
+		name     string
+		jobType  string
+		resource yaml.ContainerResource
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", want: "service"},
+		{name: "outputs", resource: yaml.ContainerResource{Outputs: []string{"/out"}}, want: "batch"},
+		{name: "job type", jobType: "Cron", want: "cron"},
+		{name: "service type wins", jobType: "cron", resource: yaml.ContainerResource{Type: "batch"}, want: "batch"},
+		{name: "service with outputs", jobType: "service", resource: yaml.ContainerResource{Outputs: []string{"/out"}}, wantErr: true},
+		{name: "unknown", jobType: "daemon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := computing.ResolveJobType(tt.jobType, tt.resource)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ResolveJobType() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func batchPod(name string, created time.Time, workload *coreV1.ContainerStateTerminated, collectorReady bool) coreV1.Pod {
+	pod := coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: name, CreationTimestamp: metaV1.NewTime(created)}}
+	workloadStatus := coreV1.ContainerStatus{Name: "train"}
+	if workload != nil {
+		workloadStatus.State.Terminated = workload
+	} else {
+		workloadStatus.State.Running = &coreV1.ContainerStateRunning{}
+	}
+	pod.Status.InitContainerStatuses = []coreV1.ContainerStatus{workloadStatus}
+	if workload != nil && workload.ExitCode == 0 {
+		pod.Status.ContainerStatuses = []coreV1.ContainerStatus{{
+			Name:  "collector",
+			Ready: collectorReady,
+			State: coreV1.ContainerState{Running: &coreV1.ContainerStateRunning{}},
+		}}
+	}
+	return pod
+}
+
+func TestInspectBatchJob(t *testing.T) {
+	now := time.Now()
+	failedJob := &batchV1.Job{Status: batchV1.JobStatus{Conditions: []batchV1.JobCondition{{
+		Type:    batchV1.JobFailed,
+		Status:  coreV1.ConditionTrue,
+		Reason:  "DeadlineExceeded",
+		Message: "Job was active longer than specified deadline",
+	}}}}
+	backoffJob := &batchV1.Job{Status: batchV1.JobStatus{Conditions: []batchV1.JobCondition{{
+		Type:   batchV1.JobFailed,
+		Status: coreV1.ConditionTrue,
+		Reason: "BackoffLimitExceeded",
+	}}}}
+
+	tests := []struct {
+		name string
+		job  *batchV1.Job
+		pods []coreV1.Pod
+		want computing.BatchStatus
+	}{
+		{
+			name: "pending",
+			job:  &batchV1.Job{},
+			want: computing.BatchStatus{ExitCode: -1},
+		},
+		{
+			name: "running",
+			job:  &batchV1.Job{},
+			pods: []coreV1.Pod{batchPod("p1", now, nil, false)},
+			want: computing.BatchStatus{PodName: "p1", ExitCode: -1},
+		},
+		{
+			name: "packaging",
+			job:  &batchV1.Job{},
+			pods: []coreV1.Pod{batchPod("p1", now, &coreV1.ContainerStateTerminated{ExitCode: 0}, false)},
+			want: computing.BatchStatus{Succeeded: true, PodName: "p1", ExitCode: 0},
+		},
+		{
+			name: "collectable",
+			job:  &batchV1.Job{},
+			pods: []coreV1.Pod{batchPod("p1", now, &coreV1.ContainerStateTerminated{ExitCode: 0}, true)},
+			want: computing.BatchStatus{Finished: true, Succeeded: true, Collectable: true, PodName: "p1", ExitCode: 0},
+		},
+		{
+			name: "workload failed, retrying",
+			job:  &batchV1.Job{},
+			pods: []coreV1.Pod{batchPod("p1", now, &coreV1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}, false)},
+			want: computing.BatchStatus{PodName: "p1", ExitCode: 137, Reason: "OOMKilled"},
+		},
+		{
+			name: "workload failed",
+			job:  backoffJob,
+			pods: []coreV1.Pod{batchPod("p1", now, &coreV1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}, false)},
+			want: computing.BatchStatus{Finished: true, PodName: "p1", ExitCode: 137, Reason: "OOMKilled"},
+		},
+		{
+			name: "deadline exceeded",
+			job:  failedJob,
+			pods: []coreV1.Pod{batchPod("p1", now, nil, false)},
+			want: computing.BatchStatus{Finished: true, PodName: "p1", ExitCode: -1,
+				Reason: "DeadlineExceeded: Job was active longer than specified deadline"},
+		},
+		{
+			name: "newest pod",
+			job:  &batchV1.Job{},
+			pods: []coreV1.Pod{
+				batchPod("old", now.Add(-time.Minute), &coreV1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}, false),
+				batchPod("new", now, nil, false),
+			},
+			want: computing.BatchStatus{PodName: "new", ExitCode: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computing.InspectBatchJob(tt.job, tt.pods, "train"); got != tt.want {
+				t.Errorf("InspectBatchJob() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
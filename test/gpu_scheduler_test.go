@@ -0,0 +1,52 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+)
+
+func TestMatchGpuModel(t *testing.T) {
+	tests := []struct {
+		requested string
+		product   string
+		want      bool
+	}{
+		{"A100", "NVIDIA A100-SXM4-40GB", true},
+		{"nvidia-a100", "NVIDIA A100-SXM4-40GB", true},
+		{"Nvidia A100 40GB", "NVIDIA A100-SXM4-40GB", true},
+		{"A10", "NVIDIA A100-SXM4-40GB", false},
+		{"Tesla T4", "Tesla-T4", true},
+		{"T4", "Tesla-T4-SHARED", true},
+		{"GeForce RTX 3090", "NVIDIA-GeForce-RTX-3090", true},
+		{"RTX 3090", "NVIDIA GeForce RTX 3080", false},
+		{"AMD Instinct MI250", "Instinct MI250X", false},
+		{"MI250X", "AMD Instinct MI250X", true},
+	}
+	for _, tt := range tests {
+		if got := computing.MatchGpuModel(tt.requested, tt.product); got != tt.want {
+			t.Errorf("MatchGpuModel(%q, %q) = %v, want %v", tt.requested, tt.product, got, tt.want)
+		}
+	}
+}
+
+func TestParseGpuMemory(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{"40960 MiB", 40960},
+		{"40GB", 40960},
+		{"40Gi", 40960},
+		{"1.5 TiB", 1572864},
+		{"15360", 15360},
+		{"512 KiB", 0},
+		{"N/A", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := computing.ParseGpuMemory(tt.size); got != tt.want {
+			t.Errorf("ParseGpuMemory(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/common"
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGpuSharingResourceName(t *testing.T) {
+	tests := []struct {
+		model   string
+		sharing string
+		want    coreV1.ResourceName
+		wantErr bool
+	}{
+		{model: "Nvidia A100 40GB", want: "nvidia.com/gpu"},
+		{model: "AMD Instinct MI250X", want: "amd.com/gpu"},
+		{model: "Nvidia T4", sharing: "shared", want: "nvidia.com/gpu.shared"},
+		{model: "Nvidia T4", sharing: " Shared ", want: "nvidia.com/gpu.shared"},
+		{model: "Nvidia A100", sharing: "1g.5gb", want: "nvidia.com/mig-1g.5gb"},
+		{model: "Nvidia A100", sharing: "mig-3g.20gb", want: "nvidia.com/mig-3g.20gb"},
+		{model: "Nvidia A100", sharing: "MIG-2G.10GB", want: "nvidia.com/mig-2g.10gb"},
+		{model: "Nvidia A100", sharing: "1g", wantErr: true},
+		{model: "Nvidia A100", sharing: "half", wantErr: true},
+		{model: "AMD Instinct MI250X", sharing: "shared", wantErr: true},
+		{model: "Radeon Pro W6800", sharing: "1g.5gb", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := yaml.GpuSharingResourceName(tt.model, tt.sharing)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GpuSharingResourceName(%q, %q) = %q, %v, want %q, error %v", tt.model, tt.sharing, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestIsGpuResource(t *testing.T) {
+	tests := []struct {
+		name coreV1.ResourceName
+		want bool
+	}{
+		{"nvidia.com/gpu", true},
+		{"nvidia.com/gpu.shared", true},
+		{"nvidia.com/mig-1g.5gb", true},
+		{"nvidia.com/mig-7g.40gb", true},
+		{"amd.com/gpu", true},
+		{"nvidia.com/gpu.product", false},
+		{"cpu", false},
+		{"memory", false},
+		{"ephemeral-storage", false},
+	}
+	for _, tt := range tests {
+		if got := yaml.IsGpuResource(tt.name); got != tt.want {
+			t.Errorf("IsGpuResource(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHardwareGpuResource(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    coreV1.ResourceName
+	}{
+		{profile: "0", want: ""},
+		{profile: "7", want: ""},
+		{profile: "6", want: "nvidia.com/gpu"},
+		{profile: "8", want: "nvidia.com/mig-1g.5gb"},
+		{profile: "9", want: "nvidia.com/gpu.shared"},
+	}
+	for _, tt := range tests {
+		res, ok := common.HardwareResource[tt.profile]
+		if !ok {
+			t.Fatalf("no hardware profile %q", tt.profile)
+		}
+		got, err := computing.HardwareGpuResource(res)
+		if err != nil || got != tt.want {
+			t.Errorf("HardwareGpuResource(%q) = %q, %v, want %q", tt.profile, got, err, tt.want)
+		}
+	}
+
+	if _, err := computing.HardwareGpuResource(common.Resource{Gpu: common.Specification{Description: "Nvidia T4", Quantity: 1, Sharing: "quarter"}}); err == nil {
+		t.Error("HardwareGpuResource() of an unknown sharing succeeded")
+	}
+}
+
+func gpuNode(labels map[string]string, allocatable map[coreV1.ResourceName]string) *coreV1.Node {
+	node := &coreV1.Node{
+		ObjectMeta: metaV1.ObjectMeta{Name: "node-1", Labels: labels},
+		Status:     coreV1.NodeStatus{Allocatable: coreV1.ResourceList{}},
+	}
+	for name, quantity := range allocatable {
+		node.Status.Allocatable[name] = resource.MustParse(quantity)
+	}
+	return node
+}
+
+func gpuPod(name coreV1.ResourceName, quantity string) coreV1.Pod {
+	return coreV1.Pod{Spec: coreV1.PodSpec{Containers: []coreV1.Container{{
+		Resources: coreV1.ResourceRequirements{Limits: coreV1.ResourceList{name: resource.MustParse(quantity)}},
+	}}}}
+}
+
+func TestNodeGpuShares(t *testing.T) {
+	details := []models.GpuDetail{{ProductName: "NVIDIA A100-SXM4-40GB", FbMemoryUsage: models.Common{Total: "40960 MiB"}}}
+
+	tests := []struct {
+		name    string
+		node    *coreV1.Node
+		pods    []coreV1.Pod
+		details []models.GpuDetail
+		want    []models.GpuShare
+	}{
+		{
+			name:    "whole GPUs only",
+			node:    gpuNode(nil, map[coreV1.ResourceName]string{"cpu": "8", "nvidia.com/gpu": "2"}),
+			details: details,
+			want:    []models.GpuShare{},
+		},
+		{
+			name: "MIG and time-sliced resources with feature discovery labels",
+			node: gpuNode(map[string]string{
+				"nvidia.com/gpu.product":         "NVIDIA-A100-SXM4-40GB",
+				"nvidia.com/gpu.memory":          "40960",
+				"nvidia.com/mig-1g.5gb.product":  "NVIDIA-A100-SXM4-40GB-MIG-1g.5gb",
+				"nvidia.com/mig-1g.5gb.memory":   "4864",
+				"nvidia.com/mig-2g.10gb.product": "NVIDIA-A100-SXM4-40GB-MIG-2g.10gb",
+			}, map[coreV1.ResourceName]string{
+				"cpu":                    "8",
+				"nvidia.com/gpu":         "0",
+				"nvidia.com/gpu.shared":  "4",
+				"nvidia.com/mig-1g.5gb":  "7",
+				"nvidia.com/mig-2g.10gb": "0",
+			}),
+			pods: []coreV1.Pod{
+				gpuPod("nvidia.com/mig-1g.5gb", "2"),
+				gpuPod("nvidia.com/gpu.shared", "3"),
+				gpuPod("nvidia.com/gpu.shared", "2"),
+				gpuPod("cpu", "1"),
+			},
+			want: []models.GpuShare{
+				{Resource: "nvidia.com/gpu.shared", Product: "NVIDIA-A100-SXM4-40GB", Memory: "40960 MiB", Total: 4, Used: 5, Free: 0},
+				{Resource: "nvidia.com/mig-1g.5gb", Product: "NVIDIA-A100-SXM4-40GB-MIG-1g.5gb", Memory: "4864 MiB", Total: 7, Used: 2, Free: 5},
+			},
+		},
+		{
+			name:    "MIG resource described from the GPU inventory",
+			node:    gpuNode(nil, map[coreV1.ResourceName]string{"nvidia.com/mig-3g.20gb": "2"}),
+			details: details,
+			want: []models.GpuShare{
+				{Resource: "nvidia.com/mig-3g.20gb", Product: "NVIDIA A100-SXM4-40GB-MIG-3g.20gb", Total: 2, Used: 0, Free: 2},
+			},
+		},
+		{
+			name:    "time-sliced resource described from the GPU inventory",
+			node:    gpuNode(nil, map[coreV1.ResourceName]string{"nvidia.com/gpu.shared": "8"}),
+			pods:    []coreV1.Pod{gpuPod("nvidia.com/gpu.shared", "1")},
+			details: details,
+			want: []models.GpuShare{
+				{Resource: "nvidia.com/gpu.shared", Product: "NVIDIA A100-SXM4-40GB", Memory: "40960 MiB", Total: 8, Used: 1, Free: 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computing.NodeGpuShares(tt.node, tt.pods, tt.details)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NodeGpuShares() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+func TestOptionalComponents(t *testing.T) {
+	if optional := computing.OptionalComponents(conf.Health{}); len(optional) != 1 || !optional[computing.ComponentStorage] {
+		t.Errorf("default optional components = %v, want storage", optional)
+	}
+	if optional := computing.OptionalComponents(conf.Health{Optional: []string{}}); len(optional) != 0 {
+		t.Errorf("optional components configured empty = %v", optional)
+	}
+	if optional := computing.OptionalComponents(conf.Health{Optional: []string{" LAD "}}); len(optional) != 1 || !optional[computing.ComponentLad] {
+		t.Errorf("optional components = %v, want lad", optional)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	healthy := func(name string, required bool) computing.ComponentHealth {
+		return computing.ComponentHealth{Name: name, Healthy: true, Required: required}
+	}
+	failing := func(name string, required bool) computing.ComponentHealth {
+		return computing.ComponentHealth{Name: name, Required: required, LastError: "connection refused"}
+	}
+
+	tests := []struct {
+		name       string
+		components []computing.ComponentHealth
+		wantReady  bool
+		wantErr    string
+	}{
+		{name: "all healthy", components: []computing.ComponentHealth{healthy("redis", true), healthy("storage", false)}, wantReady: true},
+		{name: "optional failing", components: []computing.ComponentHealth{healthy("redis", true), failing("storage", false)}, wantReady: true},
+		{name: "required failing", components: []computing.ComponentHealth{failing("redis", true), failing("storage", false)}, wantErr: "redis: connection refused"},
+		{name: "not checked yet", components: []computing.ComponentHealth{healthy("redis", true)}, wantErr: "not checked yet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := computing.NewReadiness(tt.components, 2)
+			if result.Ready != tt.wantReady {
+				t.Errorf("Ready = %v, want %v", result.Ready, tt.wantReady)
+			}
+			err := result.Err()
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "storage") {
+				t.Errorf("Err() = %v names an optional dependency", err)
+			}
+		})
+	}
+}
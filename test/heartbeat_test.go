@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/lad"
+	"github.com/lagrangedao/go-computing-provider/lad/ladtest"
+	"github.com/lagrangedao/go-computing-provider/models"
+)
+
+func TestHeartbeatSendsStatus(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+	client := server.Client(lad.Options{})
+	if err := client.Register(context.Background(), &models.ComputingProvider{NodeId: "node-1"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	heartbeat := computing.NewHeartbeatClient(computing.HeartbeatOptions{
+		Client: client,
+		NodeId: "node-1",
+		Status: func() string { return constants.StatusDraining },
+	})
+	if err := heartbeat.Beat(context.Background()); err != nil {
+		t.Fatalf("Beat() error = %v", err)
+	}
+	got := server.Heartbeats()
+	if len(got) != 1 || got[0].NodeId != "node-1" || got[0].Status != constants.StatusDraining {
+		t.Errorf("heartbeats = %+v", got)
+	}
+}
+
+func TestHeartbeatRegistersOnceAfterOutage(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+	client := server.Client(lad.Options{})
+
+	var registered int
+	heartbeat := computing.NewHeartbeatClient(computing.HeartbeatOptions{
+		Client: client,
+		NodeId: "node-1",
+		Register: func(ctx context.Context) error {
+			if err := client.Register(ctx, &models.ComputingProvider{NodeId: "node-1"}); err != nil {
+				return err
+			}
+			registered++
+			return nil
+		},
+	})
+
+	// the node is not registered yet, the first heartbeat is refused and the next one registers it
+	if err := heartbeat.Beat(context.Background()); !lad.IsNotFound(err) {
+		t.Fatalf("Beat() error = %v, want not found", err)
+	}
+	if err := heartbeat.Beat(context.Background()); err != nil {
+		t.Fatalf("Beat() error = %v", err)
+	}
+
+	server.FailNext(lad.PathHeartbeat, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	for i := 0; i < 3; i++ {
+		if err := heartbeat.Beat(context.Background()); !lad.IsRetryable(err) {
+			t.Fatalf("Beat() error = %v while the server is down", err)
+		}
+	}
+	if requests := server.Requests(lad.PathRegister); requests != 1 {
+		t.Errorf("register was posted %d times, want no registration during the outage", requests-1)
+	}
+	for i := 0; i < 3; i++ {
+		if err := heartbeat.Beat(context.Background()); err != nil {
+			t.Fatalf("Beat() error = %v", err)
+		}
+	}
+	if registered != 2 {
+		t.Errorf("registered %d times, want once at start and once after the outage", registered)
+	}
+	if got := len(server.Heartbeats()); got != 4 {
+		t.Errorf("server got %d heartbeats, want 4", got)
+	}
+}
+
+func TestHeartbeatRegisterFailureIsNotRetried(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+	client := server.Client(lad.Options{})
+	if err := client.Register(context.Background(), &models.ComputingProvider{NodeId: "node-1"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	heartbeat := computing.NewHeartbeatClient(computing.HeartbeatOptions{
+		Client: client,
+		NodeId: "node-1",
+		Register: func(ctx context.Context) error {
+			return client.Register(ctx, &models.ComputingProvider{NodeId: "node-1"})
+		},
+	})
+
+	// the registration after the outage fails, the heartbeats go on without posting it again
+	server.FailNext(lad.PathHeartbeat, http.StatusServiceUnavailable)
+	server.FailNext(lad.PathRegister, http.StatusServiceUnavailable)
+	if err := heartbeat.Beat(context.Background()); !lad.IsRetryable(err) {
+		t.Fatalf("Beat() error = %v while the server is down", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := heartbeat.Beat(context.Background()); err != nil {
+			t.Fatalf("Beat() error = %v", err)
+		}
+	}
+	if requests := server.Requests(lad.PathRegister); requests != 2 {
+		t.Errorf("register was posted %d times, want once at start and once after the outage", requests)
+	}
+}
+
+func TestHeartbeatBackoffIsCapped(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+
+	// the node never registers, so every heartbeat fails
+	heartbeat := computing.NewHeartbeatClient(computing.HeartbeatOptions{
+		Client:     server.Client(lad.Options{}),
+		NodeId:     "node-1",
+		Interval:   time.Hour,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 4 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	heartbeat.Run(ctx)
+
+	// without the cap the delay doubles to over 100ms within 8 retries
+	if requests := server.Requests(lad.PathHeartbeat); requests < 20 {
+		t.Errorf("got %d heartbeats in 300ms, the backoff is not capped at 4ms", requests)
+	}
+}
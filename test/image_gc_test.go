@@ -0,0 +1,111 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/computing"
+)
+
+func redisConn(t *testing.T) redis.Conn {
+	server := miniredis.RunT(t)
+	conn, err := redis.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestImageRetention(t *testing.T) {
+	conn := redisConn(t)
+	now := time.Now().Unix()
+
+	// an image built for a deployment that then failed is still collected
+	if err := computing.RecordBuiltImage(conn, "swan/failed:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := computing.RetainImage(conn, "ns-0xabc", "deploy-a", "swan/space:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := computing.RetainImage(conn, "ns-0xdef", "deploy-b", "docker.io/swan/space:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, err := computing.ImageGCCandidates(conn, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Image != "docker.io/swan/failed:1" {
+		t.Fatalf("candidates = %+v", candidates)
+	}
+
+	released, err := computing.ReleaseImage(conn, "ns-0xabc", "deploy-a", "swan/space:1")
+	if err != nil || released {
+		t.Fatalf("ReleaseImage() = %v, %v, the image is still referenced", released, err)
+	}
+	if released, err = computing.ReleaseImage(conn, "ns-0xdef", "deploy-b", "swan/space:1"); err != nil || !released {
+		t.Fatalf("ReleaseImage() = %v, %v, want the last reference released", released, err)
+	}
+	if released, err = computing.ReleaseImage(conn, "ns-0xabc", "deploy-a", "nginx:1.25"); err != nil || released {
+		t.Errorf("ReleaseImage() of an image not built by the provider = %v, %v", released, err)
+	}
+
+	candidates, err = computing.ImageGCCandidates(conn, map[string]bool{"docker.io/swan/failed:1": true}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Image != "docker.io/swan/space:1" {
+		t.Errorf("candidates without the image used by a pod = %+v", candidates)
+	}
+}
+
+func TestPushedImage(t *testing.T) {
+	conn := redisConn(t)
+	if err := computing.RecordPushedImage(conn, "swan/space:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{image: "swan/space:1", want: true},
+		{image: "docker.io/swan/space:1", want: true},
+		// named by a deploy.yaml under the account of the operator, never pushed by the provider
+		{image: "swan/database:1"},
+		{image: "swan/space:2"},
+	}
+	for _, tt := range tests {
+		if got, err := computing.PushedImage(conn, tt.image); err != nil || got != tt.want {
+			t.Errorf("PushedImage(%s) = %v, %v, want %v", tt.image, got, err, tt.want)
+		}
+	}
+}
+
+func TestImageGCReason(t *testing.T) {
+	now := time.Now().Unix()
+	maxAge := int64(72 * 3600)
+
+	tests := []struct {
+		name       string
+		releasedAt int64
+		pressure   bool
+		want       string
+	}{
+		{name: "recent", releasedAt: now - 3600},
+		{name: "expired", releasedAt: now - maxAge - 1, want: "expired"},
+		{name: "disk pressure", releasedAt: now - 3600, pressure: true, want: "disk_pressure"},
+		{name: "just built under pressure", releasedAt: now - 60, pressure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidate := computing.ImageGCCandidate{Image: "docker.io/swan/space:1", ReleasedAt: tt.releasedAt}
+			if got := computing.ImageGCReason(candidate, now, maxAge, tt.pressure); got != tt.want {
+				t.Errorf("ImageGCReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
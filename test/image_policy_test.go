@@ -0,0 +1,258 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+type fakeImageRuntime struct {
+	user    string
+	ports   []int
+	pullErr error
+	pulled  []string
+}
+
+func (r *fakeImageRuntime) PullImage(imageName string) error {
+	r.pulled = append(r.pulled, imageName)
+	return r.pullErr
+}
+
+func (r *fakeImageRuntime) InspectImage(imageName string) (string, []int, error) {
+	return r.user, r.ports, nil
+}
+
+// fakeScanner writes a trivy stand-in that runs the shell script body.
+func fakeScanner(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "trivy")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckImageRegistry(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		image string
+		want  bool
+	}{
+		{name: "no lists", image: "nginx", want: true},
+		{name: "docker hub short name allowed by host", allow: []string{"docker.io"}, image: "nginx:1.25", want: true},
+		{name: "docker hub alias", allow: []string{"index.docker.io"}, image: "nginx", want: true},
+		{name: "docker hub short name denied by library prefix", deny: []string{"docker.io/library"}, image: "nginx", want: false},
+		{name: "docker hub user image outside library prefix", deny: []string{"docker.io/library"}, image: "swan/space:1", want: true},
+		{name: "host not in allow list", allow: []string{"docker.io"}, image: "ghcr.io/swan/space:1", want: false},
+		{name: "host with port allowed", allow: []string{"registry.example.com:5000"}, image: "registry.example.com:5000/swan/space:1", want: true},
+		{name: "host with other port", allow: []string{"registry.example.com:5000"}, image: "registry.example.com:5001/swan/space:1", want: false},
+		{name: "host without port", allow: []string{"registry.example.com"}, image: "registry.example.com:5000/swan/space", want: false},
+		{name: "digest allowed", allow: []string{"ghcr.io/swan"}, image: "ghcr.io/swan/space@sha256:0123abcd", want: true},
+		{name: "digest denied", deny: []string{"ghcr.io"}, image: "ghcr.io/swan/space:1@sha256:0123abcd", want: false},
+		{name: "repository prefix is not a name prefix", allow: []string{"ghcr.io/swan"}, image: "ghcr.io/swanx/space:1", want: false},
+		{name: "deny wins over allow", allow: []string{"docker.io"}, deny: []string{"docker.io/evil"}, image: "evil/miner", want: false},
+		{name: "entry case and slashes", allow: []string{" GHCR.io/Swan/ "}, image: "ghcr.io/swan/space:1", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := conf.ImagePolicy{AllowRegistries: tt.allow, DenyRegistries: tt.deny}
+			violation := computing.CheckImageRegistry(c, tt.image)
+			if got := violation == ""; got != tt.want {
+				t.Errorf("CheckImageRegistry(%q) = %q, want allowed %v", tt.image, violation, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeViolations(t *testing.T) {
+	tests := []struct {
+		name      string
+		root      bool
+		lowPorts  bool
+		user      string
+		ports     []int
+		wantCount int
+	}{
+		{name: "checks disabled", user: "", ports: []int{80}, wantCount: 0},
+		{name: "empty user is root", root: true, user: "", wantCount: 1},
+		{name: "root user", root: true, user: "root", wantCount: 1},
+		{name: "uid 0 with group", root: true, user: "0:1000", wantCount: 1},
+		{name: "root with group", root: true, user: "root:root", wantCount: 1},
+		{name: "non-root user", root: true, user: "1000:1000", wantCount: 0},
+		{name: "named user", root: true, user: "app", wantCount: 0},
+		{name: "low ports", lowPorts: true, ports: []int{80, 443, 8080}, wantCount: 2},
+		{name: "port at the boundary", lowPorts: true, ports: []int{1023, 1024}, wantCount: 1},
+		{name: "root and low port", root: true, lowPorts: true, user: "root", ports: []int{22}, wantCount: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := conf.ImagePolicy{RejectRoot: tt.root, RejectLowPorts: tt.lowPorts}
+			violations := computing.RuntimeViolations(c, tt.user, tt.ports)
+			if len(violations) != tt.wantCount {
+				t.Errorf("RuntimeViolations(%q, %v) = %q, want %d violations", tt.user, tt.ports, violations, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestEvaluateImagePolicyFailMode(t *testing.T) {
+	report := `echo '{"Results":[{"Vulnerabilities":[{"Severity":"HIGH"},{"Severity":"CRITICAL"}]},{"Vulnerabilities":[{"Severity":"HIGH"}]}]}'`
+	tests := []struct {
+		name        string
+		failClosed  bool
+		scanner     func(t *testing.T) string
+		pullErr     error
+		maxVulns    int
+		wantAllowed bool
+		wantErrors  bool
+	}{
+		{name: "scanner missing, fail-open", scanner: func(t *testing.T) string { return filepath.Join(t.TempDir(), "trivy") }, wantAllowed: true, wantErrors: true},
+		{name: "scanner missing, fail-closed", failClosed: true, scanner: func(t *testing.T) string { return filepath.Join(t.TempDir(), "trivy") }, wantAllowed: false, wantErrors: true},
+		{name: "scanner fails, fail-open", scanner: func(t *testing.T) string { return fakeScanner(t, "echo 'db unavailable' >&2; exit 1") }, wantAllowed: true, wantErrors: true},
+		{name: "scanner fails, fail-closed", failClosed: true, scanner: func(t *testing.T) string { return fakeScanner(t, "echo 'db unavailable' >&2; exit 1") }, wantAllowed: false, wantErrors: true},
+		{name: "unreadable report, fail-closed", failClosed: true, scanner: func(t *testing.T) string { return fakeScanner(t, "echo not-json") }, wantAllowed: false, wantErrors: true},
+		{name: "pull fails, fail-open", pullErr: errors.New("not found"), scanner: func(t *testing.T) string { return fakeScanner(t, report) }, wantAllowed: true, wantErrors: true},
+		{name: "pull fails, fail-closed", failClosed: true, pullErr: errors.New("not found"), scanner: func(t *testing.T) string { return fakeScanner(t, report) }, wantAllowed: false, wantErrors: true},
+		{name: "vulnerabilities within limit", failClosed: true, maxVulns: 3, scanner: func(t *testing.T) string { return fakeScanner(t, report) }, wantAllowed: true},
+		{name: "vulnerabilities over limit, fail-open", maxVulns: 2, scanner: func(t *testing.T) string { return fakeScanner(t, report) }, wantAllowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := conf.ImagePolicy{
+				Enable:             true,
+				FailClosed:         tt.failClosed,
+				Scan:               true,
+				ScannerPath:        tt.scanner(t),
+				MaxVulnerabilities: tt.maxVulns,
+			}
+			runtime := &fakeImageRuntime{user: "1000", pullErr: tt.pullErr}
+			targets := []computing.ImagePolicyTarget{{Image: "swan/space:1", CheckRegistry: true, CheckRuntime: true, Pull: true}}
+
+			verdict := computing.EvaluateImagePolicy(c, "job-1", targets, runtime)
+			if verdict.Allowed != tt.wantAllowed || len(verdict.Images) != 1 || verdict.Images[0].Allowed != tt.wantAllowed {
+				t.Fatalf("EvaluateImagePolicy() = %+v, want allowed %v", verdict, tt.wantAllowed)
+			}
+			if got := len(verdict.Images[0].Errors) > 0; got != tt.wantErrors {
+				t.Errorf("errors = %q, want errors %v", verdict.Images[0].Errors, tt.wantErrors)
+			}
+			wantMode := "fail-open"
+			if tt.failClosed {
+				wantMode = "fail-closed"
+			}
+			if verdict.Mode != wantMode {
+				t.Errorf("mode = %q, want %q", verdict.Mode, wantMode)
+			}
+			if !tt.wantErrors && !reflect.DeepEqual(verdict.Images[0].Vulnerabilities, map[string]int{"HIGH": 2, "CRITICAL": 1}) {
+				t.Errorf("vulnerabilities = %v", verdict.Images[0].Vulnerabilities)
+			}
+		})
+	}
+}
+
+func TestEvaluateImagePolicyTargets(t *testing.T) {
+	c := conf.ImagePolicy{Enable: true, AllowRegistries: []string{"docker.io"}, RejectRoot: true}
+	runtime := &fakeImageRuntime{user: "root"}
+
+	// a denied registry is not pulled, a built image is not matched against the registry lists
+	verdict := computing.EvaluateImagePolicy(c, "job-1", []computing.ImagePolicyTarget{
+		{Image: "ghcr.io/swan/space:1", CheckRegistry: true, CheckRuntime: true, Pull: true},
+		{Image: "registry.example.com/swan/built:1", CheckRuntime: true},
+	}, runtime)
+	if verdict.Allowed || len(verdict.Images) != 2 {
+		t.Fatalf("EvaluateImagePolicy() = %+v", verdict)
+	}
+	if len(runtime.pulled) != 0 {
+		t.Errorf("pulled %v, want no pull of a denied image", runtime.pulled)
+	}
+	if want := []string{"registry is not in the allow list"}; !reflect.DeepEqual(verdict.Images[0].Violations, want) {
+		t.Errorf("violations = %q, want %q", verdict.Images[0].Violations, want)
+	}
+	if want := []string{"image runs as root"}; !reflect.DeepEqual(verdict.Images[1].Violations, want) {
+		t.Errorf("violations = %q, want %q", verdict.Images[1].Violations, want)
+	}
+
+	c.Enable = false
+	if verdict = computing.EvaluateImagePolicy(c, "job-1", []computing.ImagePolicyTarget{{Image: "ghcr.io/swan/space:1", CheckRegistry: true}}, runtime); !verdict.Allowed || len(verdict.Images) != 0 {
+		t.Errorf("EvaluateImagePolicy() with the policy disabled = %+v", verdict)
+	}
+}
+
+func TestImagePolicyVerdictMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  bool
+		second bool
+		want   bool
+	}{
+		{name: "both allowed", first: true, second: true, want: true},
+		{name: "build stage rejected", first: true, second: false, want: false},
+		{name: "source stage rejected", first: false, second: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := computing.ImagePolicyVerdict{JobUuid: "job-1", Allowed: tt.first, Mode: "fail-open", CheckedAt: 1, Images: []computing.ImageCheck{{Image: "python:3.10", Allowed: tt.first}}}
+			verdict.Merge(computing.ImagePolicyVerdict{JobUuid: "job-1", Allowed: tt.second, Mode: "fail-open", CheckedAt: 2, Images: []computing.ImageCheck{{Image: "swan/built:1", Allowed: tt.second}}})
+			if verdict.Allowed != tt.want || verdict.CheckedAt != 2 || verdict.JobUuid != "job-1" {
+				t.Errorf("Merge() = %+v, want allowed %v", verdict, tt.want)
+			}
+			if len(verdict.Images) != 2 || verdict.Images[0].Image != "python:3.10" || verdict.Images[1].Image != "swan/built:1" {
+				t.Errorf("images = %+v", verdict.Images)
+			}
+		})
+	}
+}
+
+func TestServeImagePolicyVerdict(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conn := redisConn(t)
+
+	saved := computing.ImagePolicyVerdict{
+		JobUuid:   "job-1",
+		Allowed:   false,
+		Mode:      "fail-closed",
+		CheckedAt: 1700000000,
+		Images:    []computing.ImageCheck{{Image: "ghcr.io/swan/space:1", Violations: []string{"registry is not in the allow list"}}},
+	}
+	if err := computing.SaveImagePolicyVerdict(conn, saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := computing.SaveImagePolicyVerdict(conn, computing.ImagePolicyVerdict{Allowed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(uuid string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "uuid", Value: uuid}}
+		computing.ServeImagePolicyVerdict(c, conn)
+		return w
+	}
+
+	w := serve("job-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Data computing.ImagePolicyVerdict `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(response.Data, saved) {
+		t.Errorf("served %+v, want %+v", response.Data, saved)
+	}
+
+	if w = serve("job-2"); w.Code != http.StatusNotFound {
+		t.Errorf("status of a job without a verdict = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
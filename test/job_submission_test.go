@@ -0,0 +1,195 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/models"
+)
+
+func submittedJob() models.JobData {
+	return models.JobData{
+		UUID:         "job-1",
+		Name:         "space",
+		Duration:     3600,
+		Hardware:     "CPU only · 2 vCPU · 4 GiB",
+		JobSourceURI: "https://api.lagrangedao.org/spaces/0xabc/space",
+		CreatedAt:    "1700000000",
+	}
+}
+
+func TestJobFingerprint(t *testing.T) {
+	job := submittedJob()
+	fingerprint := computing.JobFingerprint(job)
+
+	// fields set by the provider or refreshed on retries do not change the fingerprint
+	retried := job
+	retried.Status = "Submitted"
+	retried.JobResultURI = "https://abc.example.com"
+	retried.HostName = "abc.example.com"
+	retried.CreatedAt, retried.UpdatedAt = "1700000060", "1700000060"
+	if got := computing.JobFingerprint(retried); got != fingerprint {
+		t.Errorf("fingerprint of a retry = %s, want %s", got, fingerprint)
+	}
+
+	changed := job
+	changed.Duration = 7200
+	if computing.JobFingerprint(changed) == fingerprint {
+		t.Error("fingerprint does not change with the duration")
+	}
+}
+
+func TestMatchJobSubmission(t *testing.T) {
+	job := submittedJob()
+	recorded := &computing.JobSubmission{
+		Fingerprint: computing.JobFingerprint(job),
+		Job:         job,
+	}
+	recorded.Job.HostName = "abc.example.com"
+
+	otherHardware := job
+	otherHardware.Hardware = "NVIDIA A100"
+	otherUuid := job
+	otherUuid.UUID = "job-2"
+
+	tests := []struct {
+		name     string
+		recorded *computing.JobSubmission
+		job      models.JobData
+		conflict bool
+	}{
+		{name: "retry", recorded: recorded, job: job},
+		{name: "different payload", recorded: recorded, job: otherHardware, conflict: true},
+		{name: "key reused for another job", recorded: &computing.JobSubmission{
+			Fingerprint:    recorded.Fingerprint,
+			IdempotencyKey: "key-1",
+			Job:            job,
+		}, job: otherUuid, conflict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := computing.MatchJobSubmission(tt.recorded, tt.job)
+			var conflict *computing.SubmissionConflictError
+			if tt.conflict != errors.As(err, &conflict) {
+				t.Errorf("MatchJobSubmission() error = %v, want conflict %v", err, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestClaimJobSubmission(t *testing.T) {
+	conn := redisConn(t)
+	job := submittedJob()
+
+	recorded, err := computing.ClaimJobSubmission(conn, job, "key-1")
+	if err != nil || recorded != nil {
+		t.Fatalf("first ClaimJobSubmission() = %+v, %v", recorded, err)
+	}
+
+	// a retry gets the recorded submission back
+	recorded, err = computing.ClaimJobSubmission(conn, job, "key-1")
+	if err != nil || recorded == nil {
+		t.Fatalf("retried ClaimJobSubmission() = %+v, %v", recorded, err)
+	}
+	if err = computing.MatchJobSubmission(recorded, job); err != nil {
+		t.Errorf("MatchJobSubmission() of a retry error = %v", err)
+	}
+
+	// the key reused for another job conflicts with the recorded one
+	other := job
+	other.UUID = "job-2"
+	recorded, err = computing.ClaimJobSubmission(conn, other, "key-1")
+	if err != nil || recorded == nil {
+		t.Fatalf("ClaimJobSubmission() of a reused key = %+v, %v", recorded, err)
+	}
+	var conflict *computing.SubmissionConflictError
+	if err = computing.MatchJobSubmission(recorded, other); !errors.As(err, &conflict) {
+		t.Errorf("MatchJobSubmission() of a reused key error = %v, want conflict", err)
+	}
+	// the losing claim does not leave its uuid behind
+	if recorded, err = computing.FindJobSubmission(conn, other.UUID, ""); err != nil || recorded != nil {
+		t.Errorf("FindJobSubmission() of the losing job = %+v, %v", recorded, err)
+	}
+}
+
+func TestReleaseJobSubmission(t *testing.T) {
+	conn := redisConn(t)
+	job := submittedJob()
+	if _, err := computing.ClaimJobSubmission(conn, job, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	computing.ReleaseJobSubmission(conn, job.UUID, "key-1")
+	if recorded, err := computing.FindJobSubmission(conn, job.UUID, "key-1"); err != nil || recorded != nil {
+		t.Fatalf("FindJobSubmission() after release = %+v, %v", recorded, err)
+	}
+	if recorded, err := computing.ClaimJobSubmission(conn, job, "key-1"); err != nil || recorded != nil {
+		t.Errorf("ClaimJobSubmission() after release = %+v, %v", recorded, err)
+	}
+}
+
+func TestUpdateJobSubmission(t *testing.T) {
+	conn := redisConn(t)
+	job := submittedJob()
+
+	// only a claimed submission is updated
+	if err := computing.UpdateJobSubmission(conn, job, "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, _ := computing.FindJobSubmission(conn, job.UUID, ""); recorded != nil {
+		t.Fatalf("UpdateJobSubmission() recorded an unclaimed submission: %+v", recorded)
+	}
+
+	if _, err := computing.ClaimJobSubmission(conn, job, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	job.JobResultURI = "https://storage.example.com/jobs/job-1.json"
+	if err := computing.UpdateJobSubmission(conn, job, "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := computing.FindJobSubmission(conn, "", "key-1")
+	if err != nil || recorded == nil {
+		t.Fatalf("FindJobSubmission() = %+v, %v", recorded, err)
+	}
+	if recorded.Job.JobResultURI != job.JobResultURI || recorded.IdempotencyKey != "key-1" {
+		t.Errorf("recorded job = %+v", recorded)
+	}
+}
+
+func TestSetJobSubmissionResult(t *testing.T) {
+	conn := redisConn(t)
+	job := submittedJob()
+	job.JobResultURI = "https://abc.example.com"
+	if _, err := computing.ClaimJobSubmission(conn, job, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := computing.UpdateJobSubmission(conn, job, "run-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := computing.SetJobSubmissionResult(conn, job.UUID, "run-1", "https://storage.example.com/jobs/run-1.json")
+	if err != nil || !set {
+		t.Fatalf("SetJobSubmissionResult() of the current run = %v, %v", set, err)
+	}
+	recorded, _ := computing.FindJobSubmission(conn, "", "key-1")
+	if recorded == nil || recorded.Job.JobResultURI != "https://storage.example.com/jobs/run-1.json" || recorded.Job.Hardware != job.Hardware {
+		t.Fatalf("recorded job = %+v", recorded)
+	}
+
+	// a redeployment lands before the upload of the first run finishes
+	redeployed := job
+	redeployed.HostName = "def.example.com"
+	redeployed.JobResultURI = "https://def.example.com"
+	if err = computing.UpdateJobSubmission(conn, redeployed, "run-2"); err != nil {
+		t.Fatal(err)
+	}
+	set, err = computing.SetJobSubmissionResult(conn, job.UUID, "run-1", "https://storage.example.com/jobs/late.json")
+	if err != nil || set {
+		t.Fatalf("SetJobSubmissionResult() of an older run = %v, %v", set, err)
+	}
+	recorded, _ = computing.FindJobSubmission(conn, job.UUID, "")
+	if recorded.Job.HostName != "def.example.com" || recorded.Job.JobResultURI != "https://def.example.com" {
+		t.Errorf("redeployed job overwritten: %+v", recorded.Job)
+	}
+}
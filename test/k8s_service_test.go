@@ -85,7 +85,7 @@ func TestDockerBuild(t *testing.T) {
 	//spaceName := "DEMO-123"
 	imageName := "sonic868/demo:v2.0"
 	//dockerService.BuildImage(buildPath, spaceName, imageName)
-	err := dockerService.PushImage(imageName)
+	err := dockerService.PushImage(context.TODO(), imageName)
 	if err != nil {
 		log.Fatalln(err)
 	}
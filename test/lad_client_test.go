@@ -0,0 +1,145 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/lad"
+	"github.com/lagrangedao/go-computing-provider/lad/ladtest"
+	"github.com/lagrangedao/go-computing-provider/models"
+)
+
+func TestLadClientRegister(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+
+	provider := &models.ComputingProvider{Name: "cp", NodeId: "node-1", WalletAddress: "0xabc"}
+	if err := server.Client(lad.Options{}).Register(context.Background(), provider); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	got, ok := server.Provider("node-1")
+	if !ok || got != *provider {
+		t.Errorf("registered provider = %+v, want %+v", got, *provider)
+	}
+}
+
+func TestLadClientRejectsBadToken(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+
+	client := lad.NewClient(lad.Options{ServerUrl: server.URL, AccessToken: "wrong", Retries: 3})
+	err := client.ReportSummary(context.Background(), &models.ClusterResource{NodeId: "node-1"})
+	if !lad.IsUnauthorized(err) || lad.IsRetryable(err) {
+		t.Fatalf("ReportSummary() error = %v, want unauthorized", err)
+	}
+	var apiErr *lad.APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "invalid access token" {
+		t.Errorf("error message = %v, want the message of the server", err)
+	}
+	if got := server.Requests(lad.PathSummary); got != 1 {
+		t.Errorf("sent %d requests, a client error must not be retried", got)
+	}
+}
+
+func TestLadClientRetries(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+	client := server.Client(lad.Options{Retries: 2, RetryWait: time.Millisecond})
+
+	server.FailNext(ladtest.JobStatusPath, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	req := &lad.JobStatusReq{NodeId: "node-1", JobUuid: "job-1", Status: "deployed"}
+	if err := client.UpdateJobStatus(context.Background(), req); err != nil {
+		t.Fatalf("UpdateJobStatus() error = %v", err)
+	}
+	if got := server.JobStatuses(); len(got) != 1 || got[0] != *req {
+		t.Errorf("job statuses = %+v", got)
+	}
+
+	server.FailNext(ladtest.JobStatusPath, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	if err := client.UpdateJobStatus(context.Background(), req); !lad.IsRetryable(err) {
+		t.Errorf("UpdateJobStatus() error = %v after the retries ran out", err)
+	}
+	if got := server.Requests(ladtest.JobStatusPath); got != 6 {
+		t.Errorf("sent %d requests, want 6", got)
+	}
+}
+
+func TestLadClientHeartbeatIsNotRetried(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+	client := server.Client(lad.Options{Retries: 2, RetryWait: time.Millisecond})
+
+	server.FailNext(lad.PathHeartbeat, http.StatusInternalServerError)
+	if err := client.Heartbeat(context.Background(), &lad.HeartbeatReq{NodeId: "node-1"}); !lad.IsRetryable(err) {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if got := server.Requests(lad.PathHeartbeat); got != 1 {
+		t.Errorf("sent %d heartbeats, want 1", got)
+	}
+}
+
+func TestLadClientUnreachable(t *testing.T) {
+	server := ladtest.NewServer("token")
+	client := server.Client(lad.Options{Retries: 1, RetryWait: time.Millisecond})
+	server.Close()
+
+	err := client.Register(context.Background(), &models.ComputingProvider{NodeId: "node-1"})
+	if err == nil || !lad.IsRetryable(err) || lad.IsNotFound(err) {
+		t.Errorf("Register() error = %v, want a retryable transport error", err)
+	}
+}
+
+type failingSigner struct{}
+
+func (failingSigner) Address() string { return "0xabc" }
+
+func (failingSigner) Sign([]byte) (string, error) { return "", errors.New("keystore locked") }
+
+func TestLadClientIsRetryable(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+
+	// a request that cannot be signed is never sent
+	client := server.Client(lad.Options{Retries: 2, RetryWait: time.Millisecond, Signer: failingSigner{}})
+	if err := client.Register(context.Background(), &models.ComputingProvider{NodeId: "node-1"}); err == nil || lad.IsRetryable(err) {
+		t.Errorf("Register() error = %v, want a permanent signing error", err)
+	}
+	if got := server.Requests(lad.PathRegister); got != 0 {
+		t.Errorf("sent %d requests without a signature", got)
+	}
+
+	// a throttled request is not retried
+	client = server.Client(lad.Options{Retries: 2, RetryWait: time.Millisecond})
+	server.FailNext(lad.PathSummary, http.StatusTooManyRequests)
+	if err := client.ReportSummary(context.Background(), &models.ClusterResource{NodeId: "node-1"}); err == nil || lad.IsRetryable(err) {
+		t.Errorf("ReportSummary() error = %v, want a permanent error", err)
+	}
+	if got := server.Requests(lad.PathSummary); got != 1 {
+		t.Errorf("sent %d summaries, want 1", got)
+	}
+
+	for _, err := range []error{errors.New("failed marshal request"), context.Canceled} {
+		if lad.IsRetryable(err) {
+			t.Errorf("IsRetryable(%v) = true", err)
+		}
+	}
+	if !lad.IsRetryable(context.DeadlineExceeded) {
+		t.Error("IsRetryable() of a timeout = false")
+	}
+}
+
+func TestLadClientJobStatusWithoutPath(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+
+	client := lad.NewClient(lad.Options{ServerUrl: server.URL, AccessToken: server.AccessToken})
+	if err := client.UpdateJobStatus(context.Background(), &lad.JobStatusReq{NodeId: "node-1", JobUuid: "job-1"}); err != nil {
+		t.Fatalf("UpdateJobStatus() error = %v", err)
+	}
+	if got := server.Requests(ladtest.JobStatusPath); got != 0 {
+		t.Errorf("sent %d job statuses without a job status path", got)
+	}
+}
@@ -0,0 +1,46 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/common/logs"
+)
+
+func TestLogsCarryJobFields(t *testing.T) {
+	if err := logs.Init("debug", logs.FormatJson, ""); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer logs.Init("info", logs.FormatText, "")
+
+	var buf bytes.Buffer
+	out := logs.GetLogger().Out
+	logs.GetLogger().SetOutput(&buf)
+	defer logs.GetLogger().SetOutput(out)
+
+	ctx := logs.WithJob(context.Background(), "job-1", "space", "0xabc")
+	ctx = logs.WithFields(ctx, map[string]interface{}{"step": "build"})
+	logs.FromContext(ctx).Info("building")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line %q is not json: %v", buf.String(), err)
+	}
+	want := map[string]string{"job_uuid": "job-1", "space_name": "space", "wallet": "0xabc", "step": "build", "msg": "building"}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %s", key, entry[key], value)
+		}
+	}
+}
+
+func TestLogsInitRejectsUnknownSettings(t *testing.T) {
+	if err := logs.Init("loud", "", ""); err == nil {
+		t.Error("Init() accepted an unknown level")
+	}
+	if err := logs.Init("", "xml", ""); err == nil {
+		t.Error("Init() accepted an unknown format")
+	}
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/wallet"
+)
+
+func TestAddJobUsageSample(t *testing.T) {
+	conn := redisConn(t)
+	usage := &computing.JobUsage{JobUuid: "job-1", Namespace: "ns-0xabc", SpaceName: "space", StartedAt: 1000, LastSampleAt: 1000}
+
+	rounds := []struct {
+		name        string
+		now         int64
+		samples     map[string]computing.PodSample
+		wantCpu     float64
+		wantMemory  float64
+		wantGpu     float64
+		wantEgress  int64
+		wantSamples int
+	}{
+		{
+			name:    "first sample",
+			now:     1060,
+			samples: map[string]computing.PodSample{"space-a": {CpuCores: 0.5, MemoryGiB: 2, Gpus: 1, TxBytes: 1000, HasTx: true}},
+			wantCpu: 30, wantMemory: 2.0 / 60, wantGpu: 1.0 / 60, wantEgress: 1000, wantSamples: 1,
+		},
+		{
+			name: "second pod without network stats",
+			now:  1120,
+			samples: map[string]computing.PodSample{
+				"space-a": {CpuCores: 1, MemoryGiB: 4, Gpus: 1, TxBytes: 1500, HasTx: true},
+				"space-b": {CpuCores: 0.25},
+			},
+			wantCpu: 105, wantMemory: 6.0 / 60, wantGpu: 2.0 / 60, wantEgress: 1500, wantSamples: 2,
+		},
+		{
+			name: "container restart resets the counter, replacement pod",
+			now:  1180,
+			samples: map[string]computing.PodSample{
+				"space-a": {CpuCores: 1, TxBytes: 200, HasTx: true},
+				"space-c": {CpuCores: 1, TxBytes: 300, HasTx: true},
+			},
+			wantCpu: 225, wantMemory: 6.0 / 60, wantGpu: 2.0 / 60, wantEgress: 2000, wantSamples: 3,
+		},
+		{
+			name:    "downtime is capped at two intervals",
+			now:     5000,
+			samples: map[string]computing.PodSample{"space-a": {CpuCores: 1, Gpus: 2, TxBytes: 200, HasTx: true}},
+			wantCpu: 345, wantMemory: 6.0 / 60, wantGpu: 2.0/60 + 240.0/3600, wantEgress: 2000, wantSamples: 4,
+		},
+		{
+			name:    "no time elapsed",
+			now:     5000,
+			samples: map[string]computing.PodSample{"space-a": {CpuCores: 8, TxBytes: 900, HasTx: true}},
+			wantCpu: 345, wantMemory: 6.0 / 60, wantGpu: 2.0/60 + 240.0/3600, wantEgress: 2000, wantSamples: 4,
+		},
+	}
+	for _, round := range rounds {
+		if err := computing.AddJobUsageSample(conn, usage, round.samples, round.now, 60); err != nil {
+			t.Fatalf("%s: %v", round.name, err)
+		}
+		if !closeTo(usage.CpuSeconds, round.wantCpu) || !closeTo(usage.MemoryGiBHours, round.wantMemory) || !closeTo(usage.GpuHours, round.wantGpu) {
+			t.Errorf("%s: cpu %v, memory %v, gpu %v, want %v, %v, %v", round.name, usage.CpuSeconds, usage.MemoryGiBHours, usage.GpuHours, round.wantCpu, round.wantMemory, round.wantGpu)
+		}
+		if usage.EgressBytes != round.wantEgress || !usage.EgressMeasured {
+			t.Errorf("%s: egress %d measured %v, want %d", round.name, usage.EgressBytes, usage.EgressMeasured, round.wantEgress)
+		}
+		if usage.Samples != round.wantSamples || usage.LastSampleAt != round.now {
+			t.Errorf("%s: samples %d at %d, want %d at %d", round.name, usage.Samples, usage.LastSampleAt, round.wantSamples, round.now)
+		}
+	}
+
+	// the transmit counters are kept per job
+	other := &computing.JobUsage{JobUuid: "job-2", LastSampleAt: 1000}
+	if err := computing.AddJobUsageSample(conn, other, map[string]computing.PodSample{"space-a": {TxBytes: 100, HasTx: true}}, 1060, 60); err != nil {
+		t.Fatal(err)
+	}
+	if other.EgressBytes != 100 {
+		t.Errorf("egress of another job = %d, want 100", other.EgressBytes)
+	}
+
+	unmeasured := &computing.JobUsage{JobUuid: "job-3", LastSampleAt: 1000}
+	if err := computing.AddJobUsageSample(conn, unmeasured, map[string]computing.PodSample{"space-a": {CpuCores: 1}}, 1060, 60); err != nil {
+		t.Fatal(err)
+	}
+	if unmeasured.EgressMeasured || unmeasured.EgressBytes != 0 {
+		t.Errorf("egress without network stats = %d measured %v", unmeasured.EgressBytes, unmeasured.EgressMeasured)
+	}
+}
+
+func TestCloseJobUsage(t *testing.T) {
+	id, err := wallet.NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := &computing.JobUsage{JobUuid: "job-1", SpaceName: "space", StartedAt: 1000, CpuSeconds: 345, MemoryGiBHours: 0.1, GpuHours: 0.5, EgressBytes: 2000}
+	if err = computing.CloseJobUsage(usage, 5000, id); err != nil {
+		t.Fatal(err)
+	}
+	if usage.EndedAt != 5000 || usage.Summary == nil {
+		t.Fatalf("CloseJobUsage() = %+v", usage)
+	}
+
+	summary := usage.Summary
+	if summary.Provider != id.Address() {
+		t.Errorf("provider = %s, want %s", summary.Provider, id.Address())
+	}
+	if err = wallet.Verify(summary.Provider, []byte(summary.Payload), summary.Signature); err != nil {
+		t.Errorf("Verify() of the summary: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err = json.Unmarshal([]byte(summary.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"job_uuid": "job-1", "space_name": "space", "started_at": 1000.0, "ended_at": 5000.0,
+		"cpu_seconds": 345.0, "memory_gib_hours": 0.1, "gpu_hours": 0.5, "egress_bytes": 2000.0,
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("payload %s = %v, want %v", key, payload[key], value)
+		}
+	}
+
+	// a tampered payload does not verify
+	tampered := []byte(summary.Payload[:len(summary.Payload)-1] + " }")
+	if err = wallet.Verify(summary.Provider, tampered, summary.Signature); err == nil {
+		t.Error("Verify() of a tampered payload succeeded")
+	}
+	if err = computing.CloseJobUsage(&computing.JobUsage{JobUuid: "job-2"}, 5000, failingSigner{}); err == nil {
+		t.Error("CloseJobUsage() with a failing signer succeeded")
+	}
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/models"
+)
+
+func TestNodeResourceCache(t *testing.T) {
+	var fetches int
+	var fail bool
+	cache := computing.NewNodeResourceCache(50*time.Millisecond, func(ctx context.Context) ([]*models.NodeResource, error) {
+		fetches++
+		if fail {
+			return nil, errors.New("apiserver unavailable")
+		}
+		return []*models.NodeResource{{MachineId: "node-1"}}, nil
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		nodes, err := cache.Get(ctx)
+		if err != nil || len(nodes) != 1 {
+			t.Fatalf("Get() = %v, %v", nodes, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d times within the interval, want 1", fetches)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	fail = true
+	if _, err := cache.Get(ctx); err == nil {
+		t.Error("Get() of a failed fetch succeeded")
+	}
+	fail = false
+	if nodes, err := cache.Get(ctx); err != nil || len(nodes) != 1 {
+		t.Errorf("Get() after a failed fetch = %v, %v", nodes, err)
+	}
+	if fetches != 3 {
+		t.Errorf("fetched %d times, a failed fetch must not be cached", fetches)
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+)
+
+func TestGpuHourRate(t *testing.T) {
+	c := conf.Pricing{
+		DefaultGpuHour: 1,
+		GpuHour:        map[string]float64{"A100": 2, "A100 80GB": 3, "T4": 0.35},
+	}
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{"NVIDIA A100-SXM4-40GB", 2},
+		{"Nvidia A100 80GB", 3},
+		{"nvidia-a100", 2},
+		{"Tesla T4", 0.35},
+		{"A10", 1},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		if got := computing.GpuHourRate(c, tt.model); got != tt.want {
+			t.Errorf("GpuHourRate(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteAdd(t *testing.T) {
+	quote := &computing.Quote{Duration: 1800}
+	quote.Add("vcpu", 4, 0.05)
+	quote.Add("memory_gib", 0, 0.01)
+	quote.Add("gpu:A100", 2, 2.5)
+
+	if len(quote.Items) != 2 {
+		t.Fatalf("items = %+v, resources with no quantity are left out", quote.Items)
+	}
+	if quote.Items[0].Hourly != 0.2 || quote.Items[1].Hourly != 5 {
+		t.Errorf("items = %+v", quote.Items)
+	}
+	if quote.Hourly != 5.2 || quote.Total != 2.6 {
+		t.Errorf("hourly = %v, total = %v, want 5.2 and 2.6", quote.Hourly, quote.Total)
+	}
+}
+
+func TestDecideBid(t *testing.T) {
+	quote := &computing.Quote{Duration: 3600, Hourly: 2, Total: 2}
+	utilization := func(cpu, memory, gpu float64) func() (float64, float64, float64, error) {
+		return func() (float64, float64, float64, error) { return cpu, memory, gpu, nil }
+	}
+	unknown := func() (float64, float64, float64, error) { return 0, 0, 0, errors.New("no nodes") }
+
+	tests := []struct {
+		name        string
+		policy      conf.Autobid
+		offered     float64
+		needsGpu    bool
+		ready       error
+		utilization func() (float64, float64, float64, error)
+		want        bool
+	}{
+		{name: "disabled", policy: conf.Autobid{MinJobPrice: 10}, want: true},
+		{name: "disabled but not ready", ready: errors.New("provider is draining")},
+		{name: "quote pays", policy: conf.Autobid{Enable: true, MinJobPrice: 1, MinHourlyPrice: 1}, want: true},
+		{name: "below the job floor", policy: conf.Autobid{Enable: true, MinJobPrice: 3}},
+		{name: "below the hourly floor", policy: conf.Autobid{Enable: true, MinHourlyPrice: 3}},
+		{name: "offer below the quote", policy: conf.Autobid{Enable: true}, offered: 1},
+		{name: "offer above the floor", policy: conf.Autobid{Enable: true, MinJobPrice: 3}, offered: 4, want: true},
+		{name: "cpu limit", policy: conf.Autobid{Enable: true, MaxCpuUtilization: 80}, utilization: utilization(85, 10, 0)},
+		{name: "below the cpu limit", policy: conf.Autobid{Enable: true, MaxCpuUtilization: 80}, utilization: utilization(50, 10, 90), want: true},
+		{name: "gpu limit", policy: conf.Autobid{Enable: true, MaxGpuUtilization: 80}, needsGpu: true, utilization: utilization(0, 0, 90)},
+		{name: "gpu limit without gpus", policy: conf.Autobid{Enable: true, MaxGpuUtilization: 80}, utilization: unknown, want: true},
+		{name: "utilization unknown", policy: conf.Autobid{Enable: true, MaxMemoryUtilization: 80}, utilization: unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := computing.DecideBid(tt.policy, quote, tt.offered, tt.needsGpu, tt.ready, tt.utilization)
+			if decision.Accept != tt.want {
+				t.Errorf("DecideBid() = %+v, want accept %v", decision, tt.want)
+			}
+			if !decision.Accept && len(decision.Reasons) == 0 {
+				t.Error("a declined job has no reason")
+			}
+		})
+	}
+}
+
+func TestParseYamlInvalidQuantity(t *testing.T) {
+	content := strings.Replace(batchYaml, "size: 4Gi", "size: 4 gigs", 1)
+	if _, err := yaml.ParseYaml([]byte(content)); err == nil {
+		t.Error("ParseYaml() with an invalid memory size succeeded")
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/wallet"
+)
+
+func signedReceipt(t *testing.T, id *wallet.Identity, receipt computing.JobReceipt) *computing.SignedReceipt {
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := id.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &computing.SignedReceipt{Payload: string(payload), Provider: id.Address(), Signature: signature}
+}
+
+func TestVerifyReceipt(t *testing.T) {
+	id, _ := wallet.NewIdentity()
+	receipt := computing.JobReceipt{
+		JobUuid:           "job-1",
+		SpaceName:         "space",
+		Wallet:            "0xabc",
+		Hardware:          "CPU only · 2 vCPU · 4 GiB",
+		StartedAt:         1700000000,
+		EndedAt:           1700003600,
+		ImageDigests:      map[string]string{"nginx:1.25": "docker.io/library/nginx@sha256:0123"},
+		ResultURI:         "https://abc.example.com",
+		TerminationReason: computing.TerminationExpired,
+	}
+	signed := signedReceipt(t, id, receipt)
+
+	verification := computing.VerifyReceipt(signed)
+	if !verification.Valid {
+		t.Fatalf("VerifyReceipt() = %+v, want valid", verification)
+	}
+	if verification.Receipt.JobUuid != "job-1" || verification.Receipt.ImageDigests["nginx:1.25"] == "" {
+		t.Errorf("receipt = %+v", verification.Receipt)
+	}
+
+	tampered := *signed
+	tampered.Payload = strings.Replace(signed.Payload, computing.TerminationExpired, computing.TerminationDeleted, 1)
+	if computing.VerifyReceipt(&tampered).Valid {
+		t.Error("VerifyReceipt() accepted a tampered payload")
+	}
+
+	other, _ := wallet.NewIdentity()
+	forged := *signed
+	forged.Provider = other.Address()
+	if computing.VerifyReceipt(&forged).Valid {
+		t.Error("VerifyReceipt() accepted a receipt claimed by another provider")
+	}
+
+	notReceipt := signedReceipt(t, id, receipt)
+	notReceipt.Payload = "not json"
+	notReceipt.Signature, _ = id.Sign([]byte(notReceipt.Payload))
+	if computing.VerifyReceipt(notReceipt).Valid {
+		t.Error("VerifyReceipt() accepted a payload that is not a receipt")
+	}
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/docker"
+)
+
+func registry(c conf.Registry) *docker.Registry {
+	return &docker.Registry{Registry: c, Host: docker.RegistryHost(c.ServerAddress)}
+}
+
+func TestNormalizeImageName(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.25", "docker.io/library/nginx:1.25"},
+		{"User/App:V1", "docker.io/user/app:v1"},
+		{"index.docker.io/user/app:v1", "docker.io/user/app:v1"},
+		{"ghcr.io/org/app", "ghcr.io/org/app:latest"},
+		{"127.0.0.1:30500/app:1", "127.0.0.1:30500/app:1"},
+		{"localhost/app", "localhost/app:latest"},
+		{"nginx:1.25@sha256:0123", "docker.io/library/nginx:1.25"},
+	}
+	for _, tt := range tests {
+		if got := docker.NormalizeImageName(tt.image); got != tt.want {
+			t.Errorf("NormalizeImageName(%s) = %s, want %s", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryImageName(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry conf.Registry
+		want     string
+	}{
+		{name: "docker hub", registry: conf.Registry{ServerAddress: "https://hub.docker.com/", UserName: "Swan"}, want: "swan/space:1"},
+		{name: "repository", registry: conf.Registry{ServerAddress: "ghcr.io", UserName: "bot", Repository: "/org/cp/"}, want: "ghcr.io/org/cp/space:1"},
+		{name: "local", registry: conf.Registry{ServerAddress: "127.0.0.1:30500", Local: true}, want: "127.0.0.1:30500/space:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := registry(tt.registry).ImageName("Space", "1"); got != tt.want {
+				t.Errorf("ImageName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryOwns(t *testing.T) {
+	hub := registry(conf.Registry{ServerAddress: "docker.io", UserName: "swan"})
+	local := registry(conf.Registry{ServerAddress: "127.0.0.1:30500", Local: true})
+
+	tests := []struct {
+		name     string
+		registry *docker.Registry
+		image    string
+		want     bool
+	}{
+		{name: "pushed image", registry: hub, image: "swan/space:1", want: true},
+		{name: "other user", registry: hub, image: "other/space:1"},
+		{name: "official image", registry: hub, image: "nginx:1.25"},
+		{name: "other host", registry: hub, image: "ghcr.io/swan/space:1"},
+		{name: "no repository", registry: local, image: "127.0.0.1:30500/space:1", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.registry.Owns(tt.image); got != tt.want {
+				t.Errorf("Owns(%s) = %v, want %v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerConfigJson(t *testing.T) {
+	data, err := docker.DockerConfigJson([]*docker.Registry{
+		registry(conf.Registry{ServerAddress: "https://hub.docker.com/", UserName: "swan", Password: "secret"}),
+		registry(conf.Registry{ServerAddress: "ghcr.io", UserName: "bot", Password: "token"}),
+		registry(conf.Registry{ServerAddress: "127.0.0.1:30500", Local: true}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Auths) != 2 {
+		t.Fatalf("auths = %+v", config.Auths)
+	}
+	if hub := config.Auths["https://index.docker.io/v1/"]; hub.Username != "swan" || hub.Auth != "c3dhbjpzZWNyZXQ=" {
+		t.Errorf("docker hub auth = %+v", hub)
+	}
+	if ghcr := config.Auths["ghcr.io"]; ghcr.Username != "bot" || ghcr.Password != "token" {
+		t.Errorf("ghcr.io auth = %+v", ghcr)
+	}
+
+	if data, err = docker.DockerConfigJson([]*docker.Registry{registry(conf.Registry{ServerAddress: "127.0.0.1:30500", Local: true})}); err != nil || data != nil {
+		t.Errorf("DockerConfigJson() without credentials = %s, %v", data, err)
+	}
+}
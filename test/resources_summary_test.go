@@ -0,0 +1,223 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/models"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testNode(name string, allocatable coreV1.ResourceList) *coreV1.Node {
+	return &coreV1.Node{
+		ObjectMeta: metaV1.ObjectMeta{Name: name},
+		Status: coreV1.NodeStatus{
+			NodeInfo:    coreV1.NodeSystemInfo{MachineID: name},
+			Capacity:    allocatable,
+			Allocatable: allocatable,
+		},
+	}
+}
+
+func testPod(name, nodeName string, phase coreV1.PodPhase, containers, initContainers []coreV1.ResourceRequirements) *coreV1.Pod {
+	pod := &coreV1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       coreV1.PodSpec{NodeName: nodeName},
+		Status:     coreV1.PodStatus{Phase: phase},
+	}
+	for _, res := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, coreV1.Container{Name: "app", Resources: res})
+	}
+	for _, res := range initContainers {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, coreV1.Container{Name: "init", Resources: res})
+	}
+	return pod
+}
+
+func requests(list coreV1.ResourceList) coreV1.ResourceRequirements {
+	return coreV1.ResourceRequirements{Requests: list}
+}
+
+func TestStatisticalSourcesAccounting(t *testing.T) {
+	allocatable := coreV1.ResourceList{
+		coreV1.ResourceCPU:              resource.MustParse("4"),
+		coreV1.ResourceMemory:           resource.MustParse("16Gi"),
+		coreV1.ResourceEphemeralStorage: resource.MustParse("100Gi"),
+		"nvidia.com/gpu":                resource.MustParse("2"),
+	}
+
+	tests := []struct {
+		name        string
+		pods        []runtime.Object
+		wantCpu     models.Common
+		wantMemory  int64
+		wantStorage int64
+		wantGpu     int64
+	}{
+		{
+			name:       "idle node",
+			wantCpu:    models.Common{Total: "4", Used: "0", Free: "4", Unit: "millicore", TotalValue: 4000, FreeValue: 4000},
+			wantMemory: 0,
+		},
+		{
+			name: "millicores are not rounded up",
+			pods: []runtime.Object{
+				testPod("web", "node-1", coreV1.PodRunning, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("500m"), coreV1.ResourceMemory: resource.MustParse("512Mi")}),
+				}, nil),
+			},
+			wantCpu:    models.Common{Total: "4", Used: "0.5", Free: "3.5", Unit: "millicore", TotalValue: 4000, UsedValue: 500, FreeValue: 3500},
+			wantMemory: 512 * 1024 * 1024,
+		},
+		{
+			name: "pending pods bound to the node count, finished pods do not",
+			pods: []runtime.Object{
+				testPod("pending", "node-1", coreV1.PodPending, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("1")}),
+				}, nil),
+				testPod("unscheduled", "", coreV1.PodPending, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("1")}),
+				}, nil),
+				testPod("done", "node-1", coreV1.PodSucceeded, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("2")}),
+				}, nil),
+			},
+			wantCpu: models.Common{Total: "4", Used: "1", Free: "3", Unit: "millicore", TotalValue: 4000, UsedValue: 1000, FreeValue: 3000},
+		},
+		{
+			name: "init containers reserve their maximum",
+			pods: []runtime.Object{
+				testPod("init", "node-1", coreV1.PodRunning, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("250m")}),
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("250m")}),
+				}, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("1500m"), coreV1.ResourceEphemeralStorage: resource.MustParse("10Gi")}),
+				}),
+			},
+			wantCpu:     models.Common{Total: "4", Used: "1.5", Free: "2.5", Unit: "millicore", TotalValue: 4000, UsedValue: 1500, FreeValue: 2500},
+			wantStorage: 10 * 1024 * 1024 * 1024,
+		},
+		{
+			name: "gpus are allocated from limits",
+			pods: []runtime.Object{
+				testPod("gpu", "node-1", coreV1.PodRunning, []coreV1.ResourceRequirements{
+					{Limits: coreV1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}},
+				}, nil),
+			},
+			wantCpu: models.Common{Total: "4", Used: "0", Free: "4", Unit: "millicore", TotalValue: 4000, FreeValue: 4000},
+			wantGpu: 1,
+		},
+		{
+			name: "overcommitted node has no free resources",
+			pods: []runtime.Object{
+				testPod("big", "node-1", coreV1.PodRunning, []coreV1.ResourceRequirements{
+					requests(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("6")}),
+				}, nil),
+			},
+			wantCpu: models.Common{Total: "4", Used: "6", Free: "0", Unit: "millicore", TotalValue: 4000, UsedValue: 6000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{testNode("node-1", allocatable)}, tt.pods...)
+			service := computing.NewK8sServiceWithClient(fake.NewSimpleClientset(objects...))
+
+			nodes, err := service.StatisticalSources(context.TODO())
+			if err != nil {
+				t.Fatalf("StatisticalSources() error = %v", err)
+			}
+			if len(nodes) != 1 {
+				t.Fatalf("StatisticalSources() returned %d nodes, want 1", len(nodes))
+			}
+			node := nodes[0]
+
+			if node.Cpu != tt.wantCpu {
+				t.Errorf("cpu = %+v, want %+v", node.Cpu, tt.wantCpu)
+			}
+			if node.Vcpu != node.Cpu {
+				t.Errorf("vcpu = %+v, want %+v", node.Vcpu, node.Cpu)
+			}
+			if node.Memory.TotalValue != 16*1024*1024*1024 || node.Memory.Total != "16.00 GiB" {
+				t.Errorf("memory total = %d (%s), want 16Gi", node.Memory.TotalValue, node.Memory.Total)
+			}
+			if node.Memory.UsedValue != tt.wantMemory {
+				t.Errorf("memory used = %d, want %d", node.Memory.UsedValue, tt.wantMemory)
+			}
+			if node.Storage.UsedValue != tt.wantStorage {
+				t.Errorf("storage used = %d, want %d", node.Storage.UsedValue, tt.wantStorage)
+			}
+			if node.Storage.FreeValue != 100*1024*1024*1024-tt.wantStorage {
+				t.Errorf("storage free = %d, want %d", node.Storage.FreeValue, 100*1024*1024*1024-tt.wantStorage)
+			}
+			if node.GpuAllocation.TotalValue != 2 || node.GpuAllocation.UsedValue != tt.wantGpu {
+				t.Errorf("gpu allocation = %+v, want 2 total and %d used", node.GpuAllocation, tt.wantGpu)
+			}
+		})
+	}
+}
+
+func TestStatisticalSourcesGpuInventory(t *testing.T) {
+	node := testNode("gfd-node", coreV1.ResourceList{"nvidia.com/gpu": resource.MustParse("2")})
+	node.Labels = map[string]string{
+		"nvidia.com/gpu.product":        "NVIDIA-A100-SXM4-40GB",
+		"nvidia.com/gpu.memory":         "40960",
+		"nvidia.com/gpu.count":          "2",
+		"nvidia.com/cuda.driver.major":  "525",
+		"nvidia.com/cuda.driver.minor":  "85",
+		"nvidia.com/cuda.driver.rev":    "12",
+		"nvidia.com/cuda.runtime.major": "12",
+		"nvidia.com/cuda.runtime.minor": "0",
+	}
+	plain := testNode("plain-node", coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("2")})
+	// a collector pod without an IP must not break the statistics
+	collector := testPod("hardware-collect", "plain-node", coreV1.PodPending, nil, nil)
+	collector.Labels = map[string]string{"app": "hardware-collect"}
+
+	service := computing.NewK8sServiceWithClient(fake.NewSimpleClientset(node, plain, collector))
+	nodes, err := service.StatisticalSources(context.TODO())
+	if err != nil {
+		t.Fatalf("StatisticalSources() error = %v", err)
+	}
+
+	for _, n := range nodes {
+		switch n.MachineId {
+		case "gfd-node":
+			if n.Gpu.Source != "gpu-feature-discovery" || n.Gpu.Stale {
+				t.Errorf("gpu source = %q, stale = %v", n.Gpu.Source, n.Gpu.Stale)
+			}
+			if n.Gpu.AttachedGpus != 2 || len(n.Gpu.Details) != 2 {
+				t.Fatalf("attached gpus = %d, details = %d, want 2", n.Gpu.AttachedGpus, len(n.Gpu.Details))
+			}
+			if n.Gpu.Details[0].ProductName != "NVIDIA-A100-SXM4-40GB" || n.Gpu.Details[0].FbMemoryUsage.Total != "40960 MiB" {
+				t.Errorf("gpu detail = %+v", n.Gpu.Details[0])
+			}
+			if n.Gpu.DriverVersion != "525.85.12" || n.Gpu.CudaVersion != "12.0" {
+				t.Errorf("driver = %s, cuda = %s", n.Gpu.DriverVersion, n.Gpu.CudaVersion)
+			}
+		case "plain-node":
+			if n.Gpu.Source != "" || len(n.Gpu.Details) != 0 {
+				t.Errorf("plain node reports gpu %+v", n.Gpu)
+			}
+		default:
+			t.Errorf("unexpected node %s", n.MachineId)
+		}
+	}
+}
+
+func TestCommonValuesAreAlwaysSent(t *testing.T) {
+	data, err := json.Marshal(models.Common{Total: "4", Used: "0", Free: "4", Unit: "millicore", TotalValue: 4000, FreeValue: 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"total":"4","used":"0","free":"4","unit":"millicore","total_value":4000,"used_value":0,"free_value":4000}`
+	if string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+)
+
+const testCid = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+
+func TestSpaceSourceName(t *testing.T) {
+	tests := []struct {
+		name      string
+		uri       string
+		creator   string
+		spaceName string
+		wantErr   bool
+	}{
+		{name: "lagrange", uri: "https://api.lagrangedao.org/spaces/0xabc/demo", creator: "0xabc", spaceName: "demo"},
+		{name: "ipfs", uri: "ipfs://" + testCid + "/app?creator=0xabc&space=demo", creator: "0xabc", spaceName: "demo"},
+		{name: "ipfs default name", uri: "ipfs://" + testCid + "?creator=0xabc", creator: "0xabc", spaceName: testCid},
+		{name: "ipfs without creator", uri: "ipfs://" + testCid, wantErr: true},
+		{name: "ipfs malformed cid", uri: "ipfs://x?creator=0xabc", wantErr: true},
+		{name: "ipfs traversal", uri: "ipfs://" + testCid + "/../../../../x?creator=0xabc", wantErr: true},
+		{name: "git", uri: "git+https://github.com/org/demo.git?creator=0xabc#0123abc", creator: "0xabc", spaceName: "demo"},
+		{name: "git creator traversal", uri: "git+https://github.com/org/demo.git?creator=..&space=x#0123abc", wantErr: true},
+		{name: "git space traversal", uri: "git+https://github.com/org/demo.git?creator=0xabc&space=../../x#0123abc", wantErr: true},
+		{name: "unsupported scheme", uri: "ftp://example.com/demo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := computing.NewSpaceSource(tt.uri)
+			var creator, spaceName string
+			if err == nil {
+				creator, spaceName, err = source.SpaceName()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SpaceName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if creator != tt.creator || spaceName != tt.spaceName {
+				t.Errorf("SpaceName() = %s, %s, want %s, %s", creator, spaceName, tt.creator, tt.spaceName)
+			}
+		})
+	}
+}
+
+func TestSpaceSourceFetchStaysInBuildFolder(t *testing.T) {
+	root := t.TempDir()
+	buildFolder := filepath.Join(root, "build")
+	victim := filepath.Join(root, "victim")
+	if err := os.MkdirAll(victim, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uri := range []string{
+		"ipfs://" + testCid + "/../../victim?creator=0xabc",
+		"git+https://github.com/org/demo.git?creator=..&space=..#0123abc",
+	} {
+		source, err := computing.NewSpaceSource(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = source.Fetch(context.Background(), buildFolder); err == nil {
+			t.Errorf("Fetch(%s) succeeded", uri)
+		}
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Errorf("directory outside of the build folder was removed: %v", err)
+	}
+}
+
+// spaceServer serves a Space API response listing names, every file holds its own name.
+func spaceServer(t *testing.T, names ...string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/space" {
+			w.Write([]byte(r.URL.Query().Get("name")))
+			return
+		}
+		type file struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		}
+		var files []file
+		for _, name := range names {
+			files = append(files, file{Name: name, URL: server.URL + "/file?name=" + name})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"files": files}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLagrangeSpaceSourceFetch(t *testing.T) {
+	buildFolder := t.TempDir()
+	server := spaceServer(t, "0xabc/demo/Dockerfile", "0xabc/demo/app/main.py")
+	source, err := computing.NewSpaceSource(server.URL + "/space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spacePath, err := source.Fetch(context.Background(), buildFolder)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if want := filepath.Join(buildFolder, "0xabc", "demo"); spacePath != want {
+		t.Errorf("Fetch() = %s, want %s", spacePath, want)
+	}
+	data, err := os.ReadFile(filepath.Join(spacePath, "app", "main.py"))
+	if err != nil || string(data) != "0xabc/demo/app/main.py" {
+		t.Errorf("downloaded %q, error: %v", data, err)
+	}
+
+	for _, name := range []string{"../victim/Dockerfile", "0xabc/../../victim/Dockerfile", "/tmp/victim/Dockerfile", "0xabc/.."} {
+		root := t.TempDir()
+		buildFolder := filepath.Join(root, "build")
+		server := spaceServer(t, "0xabc/demo/Dockerfile", name)
+		source, err := computing.NewSpaceSource(server.URL + "/space")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = source.Fetch(context.Background(), buildFolder); err == nil {
+			t.Errorf("Fetch() of %s succeeded", name)
+		}
+		if entries, _ := os.ReadDir(root); len(entries) != 0 {
+			t.Errorf("Fetch() of %s wrote %d entries", name, len(entries))
+		}
+	}
+}
+
+func tarArchive(t *testing.T, entries map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range entries {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestUntar(t *testing.T) {
+	dir := t.TempDir()
+	archive := tarArchive(t, map[string]string{testCid + "/Dockerfile": "FROM nginx"})
+	if err := computing.Untar(archive, dir); err != nil {
+		t.Fatalf("Untar() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, testCid, "Dockerfile"))
+	if err != nil || string(data) != "FROM nginx" {
+		t.Errorf("extracted %q, error: %v", data, err)
+	}
+
+	for _, name := range []string{"../escape", "a/../../escape", "/etc/escape"} {
+		dest := filepath.Join(t.TempDir(), "dest")
+		err := computing.Untar(tarArchive(t, map[string]string{name: "x"}), dest)
+		if name == "/etc/escape" {
+			// absolute names are joined under the destination
+			if err != nil {
+				t.Errorf("Untar(%s) error = %v", name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Untar(%s) succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+func writeTempFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "job.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := computing.NewLocalStorage(dir, "https://files.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	object, err := storage.Upload(context.Background(), "jobs/../jobs/job-1.json", writeTempFile(t, `{"uuid":"job-1"}`))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if object.URI != "https://files.example.com/jobs/job-1.json" || object.Size != 16 {
+		t.Errorf("object = %+v", object)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "jobs", "job-1.json"))
+	if err != nil || string(data) != `{"uuid":"job-1"}` {
+		t.Errorf("stored %q, error: %v", data, err)
+	}
+
+	// object names cannot escape the directory
+	if _, err = storage.Upload(context.Background(), "../../escape.json", writeTempFile(t, "{}")); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "escape.json")); err != nil {
+		t.Errorf("escaping object not kept in the directory: %v", err)
+	}
+}
+
+func TestLocalStorageFileUri(t *testing.T) {
+	storage, _ := computing.NewLocalStorage(t.TempDir(), "")
+	object, err := storage.Upload(context.Background(), "receipt.json", writeTempFile(t, "{}"))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if !strings.HasPrefix(object.URI, "file:///") || !strings.HasSuffix(object.URI, "/receipt.json") {
+		t.Errorf("URI = %s", object.URI)
+	}
+}
+
+type flakyStorage struct {
+	failures int
+	uploads  int
+}
+
+func (s *flakyStorage) Upload(ctx context.Context, objectName, filePath string) (*computing.StoredObject, error) {
+	s.uploads++
+	if s.uploads <= s.failures {
+		return nil, errors.New("storage unavailable")
+	}
+	return &computing.StoredObject{Name: objectName}, nil
+}
+
+func (s *flakyStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func TestRetryStorage(t *testing.T) {
+	flaky := &flakyStorage{failures: 2}
+	storage := computing.NewRetryStorage(flaky, 3, time.Millisecond)
+	if _, err := storage.Upload(context.Background(), "job.json", ""); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if flaky.uploads != 3 {
+		t.Errorf("uploads = %d, want 3", flaky.uploads)
+	}
+
+	down := &flakyStorage{failures: 100}
+	storage = computing.NewRetryStorage(down, 2, time.Millisecond)
+	if _, err := storage.Upload(context.Background(), "job.json", ""); err == nil {
+		t.Fatal("Upload() to an unavailable storage succeeded")
+	}
+	if down.uploads != 3 {
+		t.Errorf("uploads = %d, want 3", down.uploads)
+	}
+}
+
+// TestS3Storage runs against an S3 compatible store, e.g. a local MinIO:
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	MINIO_ENDPOINT=localhost:9000 MINIO_ACCESS_KEY=minioadmin MINIO_SECRET_KEY=minioadmin MINIO_BUCKET=cp go test ./test -run TestS3Storage
+//
+// The bucket must exist and allow anonymous reads.
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT is not set")
+	}
+	storage, err := computing.NewS3Storage(conf.S3Storage{
+		Endpoint:  endpoint,
+		Bucket:    os.Getenv("MINIO_BUCKET"),
+		AccessKey: os.Getenv("MINIO_ACCESS_KEY"),
+		SecretKey: os.Getenv("MINIO_SECRET_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	object, err := storage.Upload(context.Background(), "jobs/job-1.json", writeTempFile(t, `{"uuid":"job-1"}`))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	resp, err := http.Get(object.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != `{"uuid":"job-1"}` {
+		t.Errorf("GET %s = %d %q", object.URI, resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,167 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestTenantEgressPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		denyCIDRs    []string
+		apiServerIPs []string
+		wantV4       []string
+		wantV6       []string
+		wantErr      bool
+	}{
+		{
+			name:   "metadata endpoint only",
+			wantV4: []string{"169.254.169.254/32"},
+		},
+		{
+			name:         "api servers",
+			apiServerIPs: []string{"10.0.0.1", "fd00::1"},
+			wantV4:       []string{"169.254.169.254/32", "10.0.0.1/32"},
+			wantV6:       []string{"fd00::1/128"},
+		},
+		{
+			name:      "extra cidrs are normalized",
+			denyCIDRs: []string{" 192.168.1.7/24 ", "fd00:1::/64"},
+			wantV4:    []string{"169.254.169.254/32", "192.168.1.0/24"},
+			wantV6:    []string{"fd00:1::/64"},
+		},
+		{
+			name:      "invalid cidr",
+			denyCIDRs: []string{"10.0.0.0/33"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := computing.TenantEgressPolicy("ns-0xabc", conf.TenantIsolation{DenyEgressCIDRs: tt.denyCIDRs}, tt.apiServerIPs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TenantEgressPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if policy.Namespace != "ns-0xabc" || len(policy.Spec.Egress) != 1 || len(policy.Spec.Egress[0].To) != 2 {
+				t.Fatalf("policy = %+v", policy)
+			}
+			v4, v6 := policy.Spec.Egress[0].To[0].IPBlock, policy.Spec.Egress[0].To[1].IPBlock
+			if v4.CIDR != "0.0.0.0/0" || !equalStrings(v4.Except, tt.wantV4) {
+				t.Errorf("ipv4 block = %+v, want except %v", v4, tt.wantV4)
+			}
+			if v6.CIDR != "::/0" || !equalStrings(v6.Except, tt.wantV6) {
+				t.Errorf("ipv6 block = %+v, want except %v", v6, tt.wantV6)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func container(requests, limits coreV1.ResourceList) coreV1.Container {
+	return coreV1.Container{Resources: coreV1.ResourceRequirements{Requests: requests, Limits: limits}}
+}
+
+func TestPodRequests(t *testing.T) {
+	defaults := coreV1.ResourceList{
+		coreV1.ResourceCPU:    resource.MustParse("500m"),
+		coreV1.ResourceMemory: resource.MustParse("512Mi"),
+	}
+	gpu := coreV1.ResourceName("nvidia.com/gpu")
+
+	tests := []struct {
+		name string
+		spec coreV1.PodSpec
+		want map[coreV1.ResourceName]string
+	}{
+		{
+			name: "defaults",
+			spec: coreV1.PodSpec{Containers: []coreV1.Container{{}}},
+			want: map[coreV1.ResourceName]string{coreV1.ResourceCPU: "500m", coreV1.ResourceMemory: "512Mi"},
+		},
+		{
+			name: "containers are summed",
+			spec: coreV1.PodSpec{Containers: []coreV1.Container{
+				container(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("2")}, nil),
+				container(coreV1.ResourceList{coreV1.ResourceMemory: resource.MustParse("1Gi")}, nil),
+			}},
+			want: map[coreV1.ResourceName]string{coreV1.ResourceCPU: "2500m", coreV1.ResourceMemory: "1536Mi"},
+		},
+		{
+			name: "extended resources are requested at their limit",
+			spec: coreV1.PodSpec{Containers: []coreV1.Container{
+				container(nil, coreV1.ResourceList{gpu: resource.MustParse("2"), coreV1.ResourceCPU: resource.MustParse("4")}),
+			}},
+			want: map[coreV1.ResourceName]string{coreV1.ResourceCPU: "500m", coreV1.ResourceMemory: "512Mi", gpu: "2"},
+		},
+		{
+			name: "an init container above the containers",
+			spec: coreV1.PodSpec{
+				InitContainers: []coreV1.Container{container(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("4")}, nil)},
+				Containers:     []coreV1.Container{{}},
+			},
+			want: map[coreV1.ResourceName]string{coreV1.ResourceCPU: "4", coreV1.ResourceMemory: "512Mi"},
+		},
+		{
+			name: "an init container below the containers",
+			spec: coreV1.PodSpec{
+				InitContainers: []coreV1.Container{container(coreV1.ResourceList{coreV1.ResourceCPU: resource.MustParse("100m")}, nil)},
+				Containers:     []coreV1.Container{{}, {}},
+			},
+			want: map[coreV1.ResourceName]string{coreV1.ResourceCPU: "1", coreV1.ResourceMemory: "1Gi"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computing.PodRequests(&tt.spec, defaults)
+			if len(got) != len(tt.want) {
+				t.Fatalf("PodRequests() = %v, want %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				quantity := got[name]
+				if quantity.Cmp(resource.MustParse(want)) != 0 {
+					t.Errorf("%s = %s, want %s", name, quantity.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTenantIsolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  conf.TenantIsolation
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "valid", config: conf.TenantIsolation{Policies: []string{"ingress", " Quota "}, DefaultCpu: "250m", DefaultMemory: "1Gi"}},
+		{name: "unknown policy", config: conf.TenantIsolation{Policies: []string{"firewall"}}, wantErr: true},
+		{name: "invalid cpu", config: conf.TenantIsolation{DefaultCpu: "half"}, wantErr: true},
+		{name: "invalid memory", config: conf.TenantIsolation{DefaultMemory: "512MB"}, wantErr: true},
+		{name: "invalid cidr", config: conf.TenantIsolation{DenyEgressCIDRs: []string{"10.0.0.1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := computing.CheckTenantIsolation(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("CheckTenantIsolation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
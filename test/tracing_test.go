@@ -0,0 +1,38 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceCarrier(t *testing.T) {
+	if carrier := computing.TraceCarrier(context.Background()); carrier != "" {
+		t.Errorf("TraceCarrier() without a span = %q", carrier)
+	}
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	carrier := computing.TraceCarrier(trace.ContextWithSpanContext(context.Background(), spanContext))
+	if carrier == "" {
+		t.Fatal("TraceCarrier() of a span is empty")
+	}
+
+	restored := trace.SpanContextFromContext(computing.ContextFromCarrier(context.Background(), carrier))
+	if restored.TraceID() != spanContext.TraceID() || restored.SpanID() != spanContext.SpanID() || !restored.IsSampled() || !restored.IsRemote() {
+		t.Errorf("restored span context = %+v, want %+v", restored, spanContext)
+	}
+}
+
+func TestContextFromInvalidCarrier(t *testing.T) {
+	for _, data := range []string{"", "not json", `{"traceparent":"00-invalid"}`} {
+		if spanContext := trace.SpanContextFromContext(computing.ContextFromCarrier(context.Background(), data)); spanContext.IsValid() {
+			t.Errorf("ContextFromCarrier(%q) = %+v", data, spanContext)
+		}
+	}
+}
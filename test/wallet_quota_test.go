@@ -0,0 +1,154 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/yaml"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const testWallet = "0xabc"
+
+func walletJob(jobUuid, spaceName string, gpus int) computing.WalletJob {
+	return computing.WalletJob{JobUuid: jobUuid, SpaceName: spaceName, Gpus: gpus, Duration: 3600, ExpireTime: time.Now().Unix() + 3600}
+}
+
+func walletJobs(t *testing.T, conn redis.Conn) map[string]string {
+	jobs, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_WALLET_JOBS_PREFIX+testWallet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func TestAdmitWalletJob(t *testing.T) {
+	conn := redisConn(t)
+	limit := conf.WalletLimit{MaxConcurrentJobs: 2, MaxGpus: 2}
+
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-1", "space-a", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-2", "space-b", 2)); err == nil {
+		t.Error("AdmitWalletJob() above the gpu limit succeeded")
+	} else if _, ok := err.(*computing.QuotaExceededError); !ok {
+		t.Errorf("AdmitWalletJob() error = %v, want a quota error", err)
+	}
+
+	// a redeploy of the space replaces its job
+	replaced, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-3", "Space-A", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced) != 1 || replaced[0].JobUuid != "job-1" {
+		t.Fatalf("replaced = %+v", replaced)
+	}
+	if jobs := walletJobs(t, conn); len(jobs) != 1 || jobs["job-3"] == "" {
+		t.Errorf("wallet jobs = %v", jobs)
+	}
+}
+
+func TestRollbackWalletJob(t *testing.T) {
+	conn := redisConn(t)
+	limit := conf.WalletLimit{MaxConcurrentJobs: 1}
+
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-1", "space-a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-2", "space-a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	// the deployment of job-2 fails before the space is torn down
+	if err := computing.RollbackWalletJob(conn, testWallet, "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := walletJobs(t, conn); len(jobs) != 1 || jobs["job-1"] == "" {
+		t.Errorf("wallet jobs after the rollback = %v, want the replaced job restored", jobs)
+	}
+
+	// the restored job keeps its place, another space is still refused
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-3", "space-b", 0)); err == nil {
+		t.Error("AdmitWalletJob() above the concurrent job limit succeeded")
+	}
+}
+
+func TestRollbackWalletJobAfterTeardown(t *testing.T) {
+	conn := redisConn(t)
+	limit := conf.WalletLimit{}
+
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-1", "space-a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob("job-2", "space-a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	// the space was torn down for job-2 before its deployment failed
+	if err := computing.DropReplacedWalletJobs(conn, "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := computing.RollbackWalletJob(conn, testWallet, "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := walletJobs(t, conn); len(jobs) != 0 {
+		t.Errorf("wallet jobs after the rollback = %v, want none", jobs)
+	}
+}
+
+func TestRollbackWalletJobReplacedAgain(t *testing.T) {
+	conn := redisConn(t)
+	limit := conf.WalletLimit{}
+
+	for _, jobUuid := range []string{"job-1", "job-2", "job-3"} {
+		if _, err := computing.AdmitWalletJob(conn, testWallet, limit, walletJob(jobUuid, "space-a", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// job-3 replaced job-2 while it was deploying, the failure of job-2 does not bring back job-1
+	if err := computing.RollbackWalletJob(conn, testWallet, "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := walletJobs(t, conn); len(jobs) != 1 || jobs["job-3"] == "" {
+		t.Errorf("wallet jobs after the rollback = %v, want only job-3", jobs)
+	}
+}
+
+func TestClaimWalletGpus(t *testing.T) {
+	conn := redisConn(t)
+	limit := conf.WalletLimit{MaxGpus: 3}
+
+	for _, job := range []computing.WalletJob{walletJob("job-1", "space-a", 1), walletJob("job-2", "space-b", 0)} {
+		if _, err := computing.AdmitWalletJob(conn, testWallet, limit, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := computing.ClaimWalletGpus(conn, testWallet, limit, "job-2", 2); err != nil {
+		t.Fatalf("ClaimWalletGpus() within the limit error = %v", err)
+	}
+	if err := computing.ClaimWalletGpus(conn, testWallet, limit, "job-2", 3); err == nil {
+		t.Error("ClaimWalletGpus() above the gpu limit succeeded")
+	}
+	if err := computing.ClaimWalletGpus(conn, testWallet, limit, "job-unknown", 8); err != nil {
+		t.Errorf("ClaimWalletGpus() of a job not admitted error = %v", err)
+	}
+}
+
+func TestCountGpus(t *testing.T) {
+	gpu := func(name string, count string) yaml.ContainerResource {
+		return yaml.ContainerResource{ResourceLimit: coreV1.ResourceList{
+			coreV1.ResourceCPU:        resource.MustParse("2"),
+			coreV1.ResourceName(name): resource.MustParse(count),
+		}}
+	}
+	service := gpu(string(yaml.NvidiaGpuResource), "2")
+	service.Depends = []yaml.ContainerResource{gpu("amd.com/gpu", "1"), gpu("nvidia.com/mig-1g.5gb", "1")}
+	resources := []yaml.ContainerResource{service, gpu("memory", "1"), {}}
+
+	if got := yaml.CountGpus(resources); got != 4 {
+		t.Errorf("CountGpus() = %d, want 4", got)
+	}
+}
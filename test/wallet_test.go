@@ -0,0 +1,243 @@
+package test
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/lagrangedao/go-computing-provider/computing"
+	"github.com/lagrangedao/go-computing-provider/lad"
+	"github.com/lagrangedao/go-computing-provider/lad/ladtest"
+	"github.com/lagrangedao/go-computing-provider/models"
+	"github.com/lagrangedao/go-computing-provider/wallet"
+)
+
+func lightKeystore(path string) *wallet.Keystore {
+	return &wallet.Keystore{Path: path, ScryptN: keystore.LightScryptN, ScryptP: keystore.LightScryptP}
+}
+
+func TestWalletKeystore(t *testing.T) {
+	ks := lightKeystore(filepath.Join(t.TempDir(), "node", "keystore.json"))
+	id, err := wallet.NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ks.Save(id, "secret"); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	info, err := os.Stat(ks.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("keystore permissions = %o, want 600", perm)
+	}
+
+	loaded, err := ks.Load("secret")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Address() != id.Address() || loaded.NodeId() != id.NodeId() {
+		t.Errorf("loaded %s, want %s", loaded.Address(), id.Address())
+	}
+	if _, err = ks.Load("wrong"); err == nil {
+		t.Error("Load() with a wrong passphrase succeeded")
+	}
+}
+
+func TestWalletKeystoreRestrictsPermissions(t *testing.T) {
+	ks := lightKeystore(filepath.Join(t.TempDir(), "keystore.json"))
+	id, _ := wallet.NewIdentity()
+	if err := ks.Save(id, ""); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(ks.Path, 0644)
+
+	if _, err := ks.Load(""); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if info, _ := os.Stat(ks.Path); info.Mode().Perm() != 0600 {
+		t.Errorf("keystore permissions = %o, want 600", info.Mode().Perm())
+	}
+}
+
+func TestWalletKeystoreReadKeepsPermissions(t *testing.T) {
+	ks := lightKeystore(filepath.Join(t.TempDir(), "keystore.json"))
+	id, _ := wallet.NewIdentity()
+	if err := ks.Save(id, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(ks.Path, 0644)
+
+	read, err := ks.Read("secret")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if read.Address() != id.Address() {
+		t.Errorf("Read() address = %s, want %s", read.Address(), id.Address())
+	}
+	if info, _ := os.Stat(ks.Path); info.Mode().Perm() != 0644 {
+		t.Errorf("keystore permissions = %o, want 644", info.Mode().Perm())
+	}
+}
+
+// chdirTemp runs the test in an empty directory, where the default keystore paths are relative to.
+func chdirTemp(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+const legacyKeyHex = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+func writeLegacyKey(t *testing.T) {
+	if err := os.MkdirAll(".swan_node", 0700); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := hex.DecodeString(legacyKeyHex)
+	if err := os.WriteFile(".swan_node/private_key", key, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadIdentityRequiresPassphrase(t *testing.T) {
+	chdirTemp(t)
+	writeLegacyKey(t)
+	t.Setenv(computing.KeystorePassphraseEnv, "")
+	computing.SetInsecureKeystore(false)
+
+	if _, err := computing.LoadIdentity(); err == nil {
+		t.Fatal("LoadIdentity() without a passphrase succeeded")
+	}
+	if _, err := os.Stat(".swan_node/keystore.json"); !os.IsNotExist(err) {
+		t.Errorf("keystore written without a passphrase, stat error = %v", err)
+	}
+	if _, err := os.Stat(".swan_node/private_key"); err != nil {
+		t.Errorf("legacy key removed, stat error = %v", err)
+	}
+}
+
+func TestLoadIdentityInsecureKeepsLegacyKey(t *testing.T) {
+	chdirTemp(t)
+	writeLegacyKey(t)
+	t.Setenv(computing.KeystorePassphraseEnv, "")
+	computing.SetInsecureKeystore(true)
+	defer computing.SetInsecureKeystore(false)
+
+	id, err := computing.LoadIdentity()
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if id.Address() != "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23" {
+		t.Errorf("Address() = %s", id.Address())
+	}
+	if _, err = os.Stat(".swan_node/private_key"); err != nil {
+		t.Errorf("legacy key removed with an unencrypted keystore, stat error = %v", err)
+	}
+}
+
+func TestLoadIdentityMigratesLegacyKey(t *testing.T) {
+	chdirTemp(t)
+	writeLegacyKey(t)
+	t.Setenv(computing.KeystorePassphraseEnv, "secret")
+
+	if _, err := computing.LoadIdentity(); err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if _, err := os.Stat(".swan_node/private_key"); !os.IsNotExist(err) {
+		t.Errorf("legacy key kept after the migration, stat error = %v", err)
+	}
+}
+
+func TestShowIdentityIsReadOnly(t *testing.T) {
+	chdirTemp(t)
+	writeLegacyKey(t)
+	t.Setenv(computing.KeystorePassphraseEnv, "secret")
+
+	if _, err := computing.ShowIdentity(); err == nil {
+		t.Fatal("ShowIdentity() without a keystore succeeded")
+	}
+	if _, err := os.Stat(".swan_node/keystore.json"); !os.IsNotExist(err) {
+		t.Errorf("ShowIdentity() created the keystore, stat error = %v", err)
+	}
+	if _, err := os.Stat(".swan_node/private_key"); err != nil {
+		t.Errorf("ShowIdentity() removed the legacy key, stat error = %v", err)
+	}
+}
+
+func TestWalletExportImport(t *testing.T) {
+	id, _ := wallet.IdentityFromHex("0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	if id.Address() != "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23" {
+		t.Fatalf("Address() = %s", id.Address())
+	}
+
+	keyJson, err := id.Export("export")
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	imported, err := wallet.Import(keyJson, "export")
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if imported.Address() != id.Address() {
+		t.Errorf("imported %s, want %s", imported.Address(), id.Address())
+	}
+}
+
+func TestWalletSignVerify(t *testing.T) {
+	id, _ := wallet.NewIdentity()
+	other, _ := wallet.NewIdentity()
+	data := []byte(`{"job_uuid":"job-1"}`)
+
+	signature, err := id.Sign(data)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err = wallet.Verify(id.Address(), data, signature); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err = wallet.Verify(other.Address(), data, signature); err == nil {
+		t.Error("Verify() accepted the signature for another address")
+	}
+	if err = wallet.Verify(id.Address(), []byte(`{"job_uuid":"job-2"}`), signature); err == nil {
+		t.Error("Verify() accepted the signature of other data")
+	}
+}
+
+func TestLadClientSignsRequests(t *testing.T) {
+	server := ladtest.NewServer("token")
+	defer server.Close()
+	id, _ := wallet.NewIdentity()
+	client := server.Client(lad.Options{Signer: id})
+
+	provider := &models.ComputingProvider{NodeId: id.NodeId(), WalletAddress: id.Address()}
+	if err := client.Register(context.Background(), provider); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := client.Heartbeat(context.Background(), &lad.HeartbeatReq{NodeId: id.NodeId()}); err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if got := server.SignedBy(lad.PathHeartbeat); got != id.Address() {
+		t.Errorf("heartbeat signed by %q, want %s", got, id.Address())
+	}
+
+	// the heartbeats of a node registered with a wallet must be signed by it
+	impostor, _ := wallet.NewIdentity()
+	err := server.Client(lad.Options{Signer: impostor}).Heartbeat(context.Background(), &lad.HeartbeatReq{NodeId: id.NodeId()})
+	if !lad.IsUnauthorized(err) {
+		t.Errorf("Heartbeat() signed by another wallet error = %v, want unauthorized", err)
+	}
+	err = server.Client(lad.Options{}).Heartbeat(context.Background(), &lad.HeartbeatReq{NodeId: id.NodeId()})
+	if !lad.IsUnauthorized(err) {
+		t.Errorf("unsigned Heartbeat() error = %v, want unauthorized", err)
+	}
+}
@@ -0,0 +1,28 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/computing"
+)
+
+func TestWarmCacheDaemonSet(t *testing.T) {
+	images := []string{"python:3.11", "missing/image:1", "nginx:1.25"}
+	spec := computing.WarmCacheDaemonSet(images, nil).Spec.Template.Spec
+
+	// only the tool install runs before the pulls, an image failing to pull must not block the others
+	if len(spec.InitContainers) != 1 || spec.InitContainers[0].Name != "install-tool" {
+		t.Errorf("init containers = %+v", spec.InitContainers)
+	}
+	if len(spec.Containers) != len(images) {
+		t.Fatalf("got %d containers, want one per image", len(spec.Containers))
+	}
+	for i, container := range spec.Containers {
+		if container.Image != images[i] {
+			t.Errorf("container %d image = %s, want %s", i, container.Image, images[i])
+		}
+		if len(container.Command) == 0 || container.Command[0] != "/warm-cache/busybox" {
+			t.Errorf("container %d command = %v", i, container.Command)
+		}
+	}
+}
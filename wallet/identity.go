@@ -0,0 +1,195 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+)
+
+const (
+	keystoreFileMode = 0600
+	keystoreDirMode  = 0700
+)
+
+// Identity is the secp256k1 key of the provider, the node id, peer id and wallet address derive from it.
+type Identity struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewIdentity generates a new random identity.
+func NewIdentity() (*Identity, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// IdentityFromBytes returns the identity of a raw 32 bytes private key.
+func IdentityFromBytes(raw []byte) (*Identity, error) {
+	key, err := crypto.ToECDSA(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// IdentityFromHex returns the identity of a hex encoded private key, with or without the 0x prefix.
+func IdentityFromHex(hexKey string) (*Identity, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(hexKey), "0x"))
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// NodeId is the hex encoded uncompressed public key.
+func (id *Identity) NodeId() string {
+	return hex.EncodeToString(crypto.FromECDSAPub(&id.key.PublicKey))
+}
+
+// PeerId is the hex encoded SHA-256 of the public key.
+func (id *Identity) PeerId() string {
+	hash := sha256.Sum256(crypto.FromECDSAPub(&id.key.PublicKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// Address is the checksummed Ethereum address of the identity.
+func (id *Identity) Address() string {
+	return crypto.PubkeyToAddress(id.key.PublicKey).String()
+}
+
+// Sign signs data as an EIP-191 personal message and returns the hex encoded signature,
+// anyone holding the address can check it with Verify.
+func (id *Identity) Sign(data []byte) (string, error) {
+	signature, err := crypto.Sign(accounts.TextHash(data), id.key)
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(signature), nil
+}
+
+// Verify checks that signature is the EIP-191 signature of data by address.
+func Verify(address string, data []byte, signature string) error {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if len(sig) != crypto.SignatureLength {
+		return fmt.Errorf("invalid signature length %d", len(sig))
+	}
+	// wallets sign with a recovery id of 27 or 28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	publicKey, err := crypto.SigToPub(accounts.TextHash(data), sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if !common.IsHexAddress(address) || crypto.PubkeyToAddress(*publicKey) != common.HexToAddress(address) {
+		return errors.New("signature does not match the address")
+	}
+	return nil
+}
+
+// Keystore is an encrypted JSON keystore file holding one identity.
+type Keystore struct {
+	Path    string
+	ScryptN int
+	ScryptP int
+}
+
+// NewKeystore returns the keystore at path with the standard scrypt parameters.
+func NewKeystore(path string) *Keystore {
+	return &Keystore{Path: path, ScryptN: keystore.StandardScryptN, ScryptP: keystore.StandardScryptP}
+}
+
+func (ks *Keystore) Exists() bool {
+	_, err := os.Stat(ks.Path)
+	return err == nil
+}
+
+// Load decrypts the identity of the keystore. A keystore readable by other users is restricted to its owner.
+func (ks *Keystore) Load(passphrase string) (*Identity, error) {
+	info, err := os.Stat(ks.Path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&^keystoreFileMode != 0 {
+		if err = os.Chmod(ks.Path, keystoreFileMode); err != nil {
+			return nil, fmt.Errorf("failed restrict the permissions of %s: %w", ks.Path, err)
+		}
+	}
+	return ks.Read(passphrase)
+}
+
+// Read decrypts the identity of the keystore without changing the file.
+func (ks *Keystore) Read(passphrase string) (*Identity, error) {
+	keyJson, err := os.ReadFile(ks.Path)
+	if err != nil {
+		return nil, err
+	}
+	return Import(keyJson, passphrase)
+}
+
+// Save encrypts the identity into the keystore, replacing the file atomically.
+func (ks *Keystore) Save(id *Identity, passphrase string) error {
+	keyJson, err := id.export(passphrase, ks.ScryptN, ks.ScryptP)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(ks.Path), keystoreDirMode); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.Path), "."+filepath.Base(ks.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(keystoreFileMode); err == nil {
+		_, err = tmp.Write(keyJson)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ks.Path)
+}
+
+// Export returns the identity as keystore JSON encrypted with passphrase.
+func (id *Identity) Export(passphrase string) ([]byte, error) {
+	return id.export(passphrase, keystore.StandardScryptN, keystore.StandardScryptP)
+}
+
+func (id *Identity) export(passphrase string, scryptN, scryptP int) ([]byte, error) {
+	key := &keystore.Key{
+		Id:         uuid.New(),
+		Address:    crypto.PubkeyToAddress(id.key.PublicKey),
+		PrivateKey: id.key,
+	}
+	return keystore.EncryptKey(key, passphrase, scryptN, scryptP)
+}
+
+// Import decrypts an identity from keystore JSON.
+func Import(keyJson []byte, passphrase string) (*Identity, error) {
+	key, err := keystore.DecryptKey(keyJson, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key.PrivateKey}, nil
+}
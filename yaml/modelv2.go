@@ -1,9 +1,11 @@
 package yaml
 
 import (
+	"fmt"
 	"gopkg.in/errgo.v2/errors"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"regexp"
 	"strings"
 )
 
@@ -76,25 +78,13 @@ func (dy *DeployYamlV2) ServiceToK8sResource() ([]ContainerResource, error) {
 
 					var resourceList = make(corev1.ResourceList)
 					if cpRs, ok := dy.Profiles.Compute[deployment.Akash.Profile]; ok {
-						if cpRs.Resources.Cpu.Units != "" {
-							resourceList[corev1.ResourceCPU] = resource.MustParse(cpRs.Resources.Cpu.Units)
-						}
-						if cpRs.Resources.Memory.Size != "" {
-							resourceList[corev1.ResourceMemory] = resource.MustParse(cpRs.Resources.Memory.Size)
-						}
-						if cpRs.Resources.Storage.Size != "" {
-							resourceList[corev1.ResourceStorage] = resource.MustParse(cpRs.Resources.Storage.Size)
-						}
-						if cpRs.Resources.Gpu.Model != "" {
-							if strings.Contains(cpRs.Resources.Gpu.Model, "amd") {
-
-							}
-							if strings.Contains(cpRs.Resources.Gpu.Model, "nvidia") {
-								resourceList["nvidia.com/gpu"] = resource.MustParse(cpRs.Resources.Gpu.Units)
-								//resourceList["nvidia.com/gpu-memory"] = resource.MustParse(cpRs.Resources.Gpu.Size)
-								container.GpuModel = cpRs.Resources.Gpu.Model
-							}
+						var err error
+						if resourceList, err = cpRs.resourceList(); err != nil {
+							return nil, err
 						}
+						container.GpuModel = cpRs.Resources.Gpu.Model
+						container.GpuMemory = cpRs.Resources.Gpu.Size
+						container.GpuSharing = cpRs.Resources.Gpu.Sharing
 					}
 
 					if len(service.ReadyCmd) > 0 {
@@ -143,30 +133,21 @@ func (dy *DeployYamlV2) ServiceToK8sResource() ([]ContainerResource, error) {
 					Path: service.Config.Path,
 				}
 			}
+			containerNew.Type = service.Type
+			containerNew.Schedule = service.Schedule
+			containerNew.Retries = service.Retries
+			containerNew.Outputs = service.Outputs
 		}
 
 		var resourceList = make(corev1.ResourceList)
 		if cpRs, ok := dy.Profiles.Compute[deployment.Akash.Profile]; ok {
-			if cpRs.Resources.Cpu.Units != "" {
-				resourceList[corev1.ResourceCPU] = resource.MustParse(cpRs.Resources.Cpu.Units)
-			}
-			if cpRs.Resources.Memory.Size != "" {
-				resourceList[corev1.ResourceMemory] = resource.MustParse(cpRs.Resources.Memory.Size)
-			}
-			if cpRs.Resources.Storage.Size != "" {
-				resourceList[corev1.ResourceStorage] = resource.MustParse(cpRs.Resources.Storage.Size)
-			}
-
-			if cpRs.Resources.Gpu.Model != "" {
-				if strings.Contains(cpRs.Resources.Gpu.Model, "amd") {
-
-				}
-				if strings.Contains(cpRs.Resources.Gpu.Model, "nvidia") {
-					resourceList["nvidia.com/gpu"] = resource.MustParse(cpRs.Resources.Gpu.Units)
-					//resourceList["nvidia.com/gpu-memory"] = resource.MustParse(cpRs.Resources.Gpu.Size)
-					containerNew.GpuModel = cpRs.Resources.Gpu.Model
-				}
+			var err error
+			if resourceList, err = cpRs.resourceList(); err != nil {
+				return nil, err
 			}
+			containerNew.GpuModel = cpRs.Resources.Gpu.Model
+			containerNew.GpuMemory = cpRs.Resources.Gpu.Size
+			containerNew.GpuSharing = cpRs.Resources.Gpu.Sharing
 		}
 		containerNew.ResourceLimit = resourceList
 		containerNew.Count = deployment.Akash.Count
@@ -203,6 +184,15 @@ type Service struct {
 		Path string `yaml:"path"`
 	} `yaml:"config"`
 	ReadyCmd []string `yaml:"ready-cmd"`
+	// Type is service, batch or cron, it overrides the type of the job.
+	Type string `yaml:"type"`
+	// Schedule is the cron schedule of a cron service.
+	Schedule string `yaml:"schedule"`
+	// Retries is how many times a failed batch or cron run is retried.
+	Retries int `yaml:"retries"`
+	// Outputs are the directories a batch or cron service leaves its results in, they are uploaded
+	// once a run exits. A service declaring outputs without a type is a batch service.
+	Outputs []string `yaml:"outputs"`
 }
 
 type Expose struct {
@@ -230,13 +220,116 @@ type Compute struct {
 			Size string `yaml:"size"`
 		} `yaml:"storage"`
 		Gpu struct {
-			Model string `yaml:"model"`
-			Units string `yaml:"units"`
-			Size  string `yaml:"size"`
+			Model   string `yaml:"model"`
+			Units   string `yaml:"units"`
+			Size    string `yaml:"size"`
+			Sharing string `yaml:"sharing"`
 		} `yaml:"gpu"`
 	} `yaml:"resources"`
 }
 
+const (
+	NvidiaGpuResource       corev1.ResourceName = "nvidia.com/gpu"
+	NvidiaSharedGpuResource corev1.ResourceName = "nvidia.com/gpu.shared"
+	NvidiaMigResourcePrefix string              = "nvidia.com/mig-"
+	AmdGpuResource          corev1.ResourceName = "amd.com/gpu"
+
+	GpuSharingTimeSlicing = "shared"
+)
+
+var migProfilePattern = regexp.MustCompile(`^(mig-)?(\d+g\.\d+gb)$`)
+
+// GpuResourceName returns the extended resource of the device plugin serving the GPU model,
+// models naming AMD or its Radeon and Instinct lines use amd.com/gpu, any other model nvidia.com/gpu.
+func GpuResourceName(model string) corev1.ResourceName {
+	lower := strings.ToLower(model)
+	for _, amd := range []string{"amd", "radeon", "instinct"} {
+		if strings.Contains(lower, amd) {
+			return AmdGpuResource
+		}
+	}
+	return NvidiaGpuResource
+}
+
+// GpuSharingResourceName returns the resource of a fractional GPU. Sharing is either "shared" for a
+// time-sliced replica, exposed by the NVIDIA device plugin as nvidia.com/gpu.shared, or a MIG profile
+// such as "1g.5gb" or "mig-1g.5gb", exposed as nvidia.com/mig-1g.5gb. An empty sharing claims whole GPUs.
+func GpuSharingResourceName(model, sharing string) (corev1.ResourceName, error) {
+	name := GpuResourceName(model)
+	sharing = strings.ToLower(strings.TrimSpace(sharing))
+	if sharing == "" {
+		return name, nil
+	}
+	if name != NvidiaGpuResource {
+		return "", fmt.Errorf("gpu sharing %q is only supported on NVIDIA GPUs", sharing)
+	}
+	if sharing == GpuSharingTimeSlicing {
+		return NvidiaSharedGpuResource, nil
+	}
+	if match := migProfilePattern.FindStringSubmatch(sharing); match != nil {
+		return corev1.ResourceName(NvidiaMigResourcePrefix + match[2]), nil
+	}
+	return "", fmt.Errorf("unknown gpu sharing %q, use %q or a MIG profile like 1g.5gb", sharing, GpuSharingTimeSlicing)
+}
+
+// IsGpuResource reports whether a resource is a whole, time-sliced or MIG GPU.
+func IsGpuResource(name corev1.ResourceName) bool {
+	return name == NvidiaGpuResource || name == NvidiaSharedGpuResource || name == AmdGpuResource ||
+		strings.HasPrefix(string(name), NvidiaMigResourcePrefix)
+}
+
+// CountGpus returns the gpus limited by the services of a deploy.yaml and their dependencies.
+func CountGpus(resources []ContainerResource) int {
+	var gpus int64
+	for _, r := range resources {
+		for name, quantity := range r.ResourceLimit {
+			if IsGpuResource(name) {
+				gpus += quantity.Value()
+			}
+		}
+		gpus += int64(CountGpus(r.Depends))
+	}
+	return int(gpus)
+}
+
+func (c Compute) resourceList() (corev1.ResourceList, error) {
+	var resourceList = make(corev1.ResourceList)
+	add := func(name corev1.ResourceName, value string) error {
+		if value == "" {
+			return nil
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		resourceList[name] = quantity
+		return nil
+	}
+	if err := add(corev1.ResourceCPU, c.Resources.Cpu.Units); err != nil {
+		return nil, err
+	}
+	if err := add(corev1.ResourceMemory, c.Resources.Memory.Size); err != nil {
+		return nil, err
+	}
+	if err := add(corev1.ResourceStorage, c.Resources.Storage.Size); err != nil {
+		return nil, err
+	}
+	if c.Resources.Gpu.Model != "" {
+		name, err := GpuSharingResourceName(c.Resources.Gpu.Model, c.Resources.Gpu.Sharing)
+		if err != nil {
+			return nil, err
+		}
+		units := c.Resources.Gpu.Units
+		if units == "" {
+			units = "1"
+		}
+		if err = add(name, units); err != nil {
+			return nil, err
+		}
+	}
+	return resourceList, nil
+}
+
 type Deployment struct {
 	Akash struct {
 		Profile string `yaml:"profile"`
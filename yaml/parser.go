@@ -20,6 +20,12 @@ type ContainerResource struct {
 	Depends       []ContainerResource
 	ReadyCmd      []string
 	GpuModel      string
+	GpuMemory     string
+	GpuSharing    string
+	Type          string
+	Schedule      string
+	Retries       int
+	Outputs       []string
 }
 
 type ConfigFile struct {
@@ -67,7 +73,12 @@ func HandlerYaml(yamlFilePath string) ([]ContainerResource, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed unable to read file, %w", err)
 	}
+	return ParseYaml(yamlFile)
+}
 
+// ParseYaml converts the content of a deploy.yaml to container resources.
+func ParseYaml(yamlFile []byte) ([]ContainerResource, error) {
+	var err error
 	var containerResources []ContainerResource
 	version, _ := getYAMLFileVersion(yamlFile)
 	switch version {
@@ -81,7 +92,7 @@ func HandlerYaml(yamlFilePath string) ([]ContainerResource, error) {
 			return nil, fmt.Errorf("failed unable to parse YAML file for k8s, %w", err)
 		}
 	default:
-		return nil, fmt.Errorf("not support yaml version: %d", version)
+		return nil, fmt.Errorf("not support yaml version: %s", version)
 	}
 	return containerResources, err
 }